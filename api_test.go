@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/blobstore"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const testJWTSecret = "test-secret"
+
+// newTestAPI is an apiConfig over a MemoryStore and in-memory blob stores,
+// as handler tests need it.
+func newTestAPI(t *testing.T) *apiConfig {
+	t.Helper()
+	videoStore := blobstore.NewMemory("http://localhost/blobs")
+	thumbnailStore := blobstore.NewMemory("http://localhost/blobs")
+	cfg := &apiConfig{
+		db:                   database.NewMemoryStore(),
+		jwtSecret:            testJWTSecret,
+		platform:             "dev",
+		videoStore:           videoStore,
+		thumbnailStore:       thumbnailStore,
+		videoURLResolver:     storeURLResolver{videoStore},
+		thumbnailURLResolver: storeURLResolver{thumbnailStore},
+		acceptedVideoTypes:   []string{"video/mp4"},
+		thumbnailWidths:      []int{320},
+		stagingRoot:          t.TempDir(),
+		events:               newVideoEvents(),
+	}
+	cfg.jobs = newJobQueue(cfg, 1)
+	return cfg
+}
+
+// createTestUser creates a user and returns it with an access token.
+func createTestUser(t *testing.T, cfg *apiConfig, email string) (database.User, string) {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *user, token
+}
+
+func createTestVideo(t *testing.T, cfg *apiConfig, user database.User) database.Video {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{Title: "test video", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return video
+}
+
+// serve runs req through the handler registered for pattern, so path values
+// are filled in as in main.
+func serve(pattern string, handler http.HandlerFunc, req *http.Request, token string) *httptest.ResponseRecorder {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(pattern, handler)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
@@ -186,9 +186,14 @@ async function uploadVideoFile(videoID) {
 
 const videoStateHandler = createVideoStateHandler();
 
-async function getVideos() {
+// getVideos loads the first page of videos, or appends the page after cursor
+async function getVideos(cursor) {
   try {
-    const res = await fetch('/api/videos', {
+    const params = new URLSearchParams({ limit: '20' });
+    if (cursor) {
+      params.set('cursor', cursor);
+    }
+    const res = await fetch(`/api/videos?${params}`, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -200,14 +205,26 @@ async function getVideos() {
     }
 
     const videos = await res.json();
+    const nextCursor = res.headers.get('X-Next-Cursor');
     const videoList = document.getElementById('video-list');
-    videoList.innerHTML = '';
+    if (cursor) {
+      videoList.querySelector('.load-more')?.remove();
+    } else {
+      videoList.innerHTML = '';
+    }
     for (const video of videos) {
       const listItem = document.createElement('li');
       listItem.textContent = video.title;
       listItem.onclick = () => videoStateHandler(video.id);
       videoList.appendChild(listItem);
     }
+    if (nextCursor) {
+      const loadMore = document.createElement('li');
+      loadMore.className = 'load-more';
+      loadMore.textContent = 'Load more...';
+      loadMore.onclick = () => getVideos(nextCursor);
+      videoList.appendChild(loadMore);
+    }
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
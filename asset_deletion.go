@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/blobstore"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const (
+	deletionStoreVideo     = "video"
+	deletionStoreThumbnail = "thumbnail"
+
+	deletionRetryBatch = 100
+	deletionMaxBackoff = 24 * time.Hour
+)
+
+func (cfg *apiConfig) blobStoreByName(name string) (blobstore.BlobStore, error) {
+	switch name {
+	case deletionStoreVideo:
+		return cfg.videoStore, nil
+	case deletionStoreThumbnail:
+		return cfg.thumbnailStore, nil
+	}
+	return nil, fmt.Errorf("unknown blob store %q", name)
+}
+
+// storedKey prefers the stored key and falls back to a legacy URL column.
+func storedKey(store blobstore.BlobStore, key, legacyURL *string) (string, bool) {
+	if key != nil {
+		return *key, true
+	}
+	if legacyURL != nil {
+		return keyFromURL(store, *legacyURL)
+	}
+	return "", false
+}
+
+// videoArtifactPrefix is the prefix under which derived files (renditions,
+// generated thumbnails...) of the video stored at key live.
+func videoArtifactPrefix(key string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + "/"
+}
+
+// uploadedThumbnailKeys lists the stored keys of an uploaded thumbnail: the
+// thumbnail itself, its width variants and their format siblings, which may
+// not all exist.
+func uploadedThumbnailKeys(store blobstore.BlobStore, video database.Video) []string {
+	key, ok := storedKey(store, video.ThumbnailKey, video.ThumbnailURL)
+	if !ok {
+		if video.ThumbnailURL != nil {
+			log.Printf("video %s: can't map thumbnail url %q to a storage key", video.ID, *video.ThumbnailURL)
+		}
+		return nil
+	}
+	images := []string{key}
+	for _, w := range thumbnailWidths(video) {
+		if variant := thumbnailVariantKey(path.Dir(key), w, path.Ext(key)); variant != key {
+			images = append(images, variant)
+		}
+	}
+	keys := []string{}
+	for _, image := range images {
+		keys = append(keys, image)
+		for _, f := range thumbnailFormats {
+			keys = append(keys, thumbnailFormatKey(image, f.ext))
+		}
+	}
+	return keys
+}
+
+// queueThumbnailDeletion records an uploaded thumbnail that is being replaced
+// as pending deletion.
+func (cfg *apiConfig) queueThumbnailDeletion(video database.Video) error {
+	blobs := []database.BlobRef{}
+	for _, key := range uploadedThumbnailKeys(cfg.thumbnailStore, video) {
+		blobs = append(blobs, database.BlobRef{Store: deletionStoreThumbnail, Key: key})
+	}
+	return cfg.queueBlobDeletions(blobs)
+}
+
+func (cfg *apiConfig) queueBlobDeletions(blobs []database.BlobRef) error {
+	for _, blob := range blobs {
+		if _, err := cfg.db.CreatePendingDeletion(blob.Store, blob.Key); err != nil {
+			return fmt.Errorf("couldn't record pending deletion: %w", err)
+		}
+	}
+	return nil
+}
+
+// storedVideoBlobs lists the video file of video and every artifact derived
+// from it (original, renditions, sprites...).
+func (cfg *apiConfig) storedVideoBlobs(ctx context.Context, video database.Video) ([]database.BlobRef, error) {
+	key, ok := storedKey(cfg.videoStore, video.VideoKey, video.VideoURL)
+	if !ok {
+		if video.VideoURL != nil {
+			log.Printf("video %s: can't map video url %q to a storage key", video.ID, *video.VideoURL)
+		}
+		return nil, nil
+	}
+	blobs := []database.BlobRef{{Store: deletionStoreVideo, Key: key}}
+	derived, err := cfg.videoStore.List(ctx, videoArtifactPrefix(key))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't list video artifacts: %w", err)
+	}
+	for _, obj := range derived {
+		blobs = append(blobs, database.BlobRef{Store: deletionStoreVideo, Key: obj.Key})
+	}
+	return blobs, nil
+}
+
+// videoAssetBlobs lists every stored artifact belonging to video, to be
+// queued for deletion along with it.
+func (cfg *apiConfig) videoAssetBlobs(ctx context.Context, video database.Video) ([]database.BlobRef, error) {
+	blobs, err := cfg.storedVideoBlobs(ctx, video)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get thumbnail candidates: %w", err)
+	}
+	for _, c := range candidates {
+		blobs = append(blobs, database.BlobRef{Store: deletionStoreThumbnail, Key: c.Key})
+	}
+	// a generated thumbnail is one of the candidates already
+	if !video.ThumbnailGenerated {
+		for _, key := range uploadedThumbnailKeys(cfg.thumbnailStore, video) {
+			blobs = append(blobs, database.BlobRef{Store: deletionStoreThumbnail, Key: key})
+		}
+	}
+	return blobs, nil
+}
+
+// processPendingDeletions attempts every deletion that is due and
+// reschedules the ones that fail with exponential backoff.
+func (cfg *apiConfig) processPendingDeletions(ctx context.Context) error {
+	deletions, err := cfg.db.GetDuePendingDeletions(time.Now(), deletionRetryBatch)
+	if err != nil {
+		return err
+	}
+	for _, d := range deletions {
+		err := cfg.deleteBlob(ctx, d)
+		if err == nil {
+			if err := cfg.db.DeletePendingDeletion(d.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		log.Printf("couldn't delete %s blob %q (attempt %d): %v", d.Store, d.Key, d.Attempts+1, err)
+		backoff := time.Minute << min(d.Attempts, 12)
+		if backoff > deletionMaxBackoff {
+			backoff = deletionMaxBackoff
+		}
+		if err := cfg.db.MarkPendingDeletionFailed(d.ID, err.Error(), time.Now().Add(backoff)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) deleteBlob(ctx context.Context, d database.PendingDeletion) error {
+	store, err := cfg.blobStoreByName(d.Store)
+	if err != nil {
+		return err
+	}
+	return store.Delete(ctx, d.Key)
+}
+
+func (cfg *apiConfig) runDeletionRetrier(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := cfg.processPendingDeletions(ctx); err != nil {
+			log.Printf("Couldn't process pending deletions: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -40,7 +40,16 @@ func (cfg apiConfig) getObjectURL(key string) string {
 	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", cfg.s3Bucket, cfg.s3Region, key)
 }
 
+// knownExts covers media types whose subtype isn't their usual extension.
+var knownExts = map[string]string{
+	"video/quicktime":  ".mov",
+	"video/x-matroska": ".mkv",
+}
+
 func mediaTypeToExt(mediaType string) string {
+	if ext, ok := knownExts[mediaType]; ok {
+		return ext
+	}
 	parts := strings.Split(mediaType, "/")
 	if len(parts) != 2 {
 		return ".bin"
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cfsign"
+)
+
+// cdnSigning holds the CloudFront key pair used to restrict playback to
+// viewers the API handed a signed URL or cookie to.
+type cdnSigning struct {
+	signer       *cfsign.Signer
+	baseURL      string
+	expiry       time.Duration
+	bindClientIP bool
+	cookieDomain string
+}
+
+type clientIPKey struct{}
+
+// clientIPMiddleware records the caller's address so URL resolvers can bind
+// signed policies to it.
+func clientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if addr, err := netip.ParseAddr(host); err == nil {
+			r = r.WithContext(context.WithValue(r.Context(), clientIPKey{}, addr.Unmap()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *cdnSigning) policy(ctx context.Context, resource string) cfsign.Policy {
+	p := cfsign.Policy{
+		Resource: resource,
+		Expires:  time.Now().Add(c.expiry),
+	}
+	if addr, ok := ctx.Value(clientIPKey{}).(netip.Addr); ok && c.bindClientIP {
+		p.IPRange = netip.PrefixFrom(addr, addr.BitLen()).String()
+	}
+	return p
+}
+
+func (c *cdnSigning) url(key string) string {
+	return strings.TrimSuffix(c.baseURL, "/") + "/" + key
+}
+
+type unsignedKey struct{}
+
+// withoutSignatures makes cdnSigning resolve plain, unsigned URLs for
+// requests that mustn't be granted access.
+func withoutSignatures(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unsignedKey{}, true)
+}
+
+func (c *cdnSigning) ResolveURL(ctx context.Context, key string) (string, error) {
+	u := c.url(key)
+	if unsigned, _ := ctx.Value(unsignedKey{}).(bool); unsigned {
+		return u, nil
+	}
+	return c.signer.SignURL(u, c.policy(ctx, u))
+}
+
+// setVideoCookies grants access to the video and every derived artifact
+// stored beside it (renditions, segments...) through signed cookies.
+func (c *cdnSigning) setVideoCookies(ctx context.Context, w http.ResponseWriter, key string) error {
+	resource := c.url(strings.TrimSuffix(videoArtifactPrefix(key), "/")) + "*"
+	cookies, err := c.signer.SignedCookies(c.policy(ctx, resource), c.cookieDomain, "/")
+	if err != nil {
+		return err
+	}
+	for _, cookie := range cookies {
+		http.SetCookie(w, cookie)
+	}
+	return nil
+}
+
+// newCDNSigning loads the signing configuration from the environment:
+// CF_KEY_PAIR_ID, CF_PRIVATE_KEY_PATH, CF_SIGNED_URL_EXPIRY (default 1h),
+// CF_SIGN_BIND_IP ("true" restricts policies to the caller's IP) and
+// CF_COOKIE_DOMAIN.
+func newCDNSigning(baseURL string) (*cdnSigning, error) {
+	keyPairID := os.Getenv("CF_KEY_PAIR_ID")
+	if keyPairID == "" {
+		return nil, errors.New("CF_KEY_PAIR_ID environment variable is not set")
+	}
+	keyPath := os.Getenv("CF_PRIVATE_KEY_PATH")
+	if keyPath == "" {
+		return nil, errors.New("CF_PRIVATE_KEY_PATH environment variable is not set")
+	}
+	pemBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := cfsign.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	expiry := time.Hour
+	if v := os.Getenv("CF_SIGNED_URL_EXPIRY"); v != "" {
+		expiry, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CF_SIGNED_URL_EXPIRY: %w", err)
+		}
+	}
+
+	return &cdnSigning{
+		signer:       cfsign.NewSigner(keyPairID, key),
+		baseURL:      baseURL,
+		expiry:       expiry,
+		bindClientIP: os.Getenv("CF_SIGN_BIND_IP") == "true",
+		cookieDomain: os.Getenv("CF_COOKIE_DOMAIN"),
+	}, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/blobstore"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const directUploadExpiry = time.Hour
+
+// directUploadKey is where a client uploads a video straight to the bucket
+// before it is processed into its final location. Every presign gets its own
+// key, so a new upload never overwrites one a queued job is still reading.
+func directUploadKey(videoID uuid.UUID, contentType string) string {
+	return fmt.Sprintf("uploads/%s/%s%s", videoID, uuid.New(), mediaTypeToExt(contentType))
+}
+
+// isDirectUploadKey reports whether key could have been handed out by
+// directUploadKey for videoID and one of the accepted types.
+func isDirectUploadKey(videoID uuid.UUID, key string, acceptedTypes []string) bool {
+	name, ok := strings.CutPrefix(key, "uploads/"+videoID.String()+"/")
+	if !ok {
+		return false
+	}
+	ext := path.Ext(name)
+	nonce, err := uuid.Parse(strings.TrimSuffix(name, ext))
+	if err != nil || nonce.String() != strings.TrimSuffix(name, ext) {
+		return false
+	}
+	return slices.ContainsFunc(acceptedTypes, func(mediaType string) bool {
+		return mediaTypeToExt(mediaType) == ext
+	})
+}
+
+// authorizeVideoOwner validates the JWT on r and checks the caller owns the
+// video in the videoID path value.
+func (cfg *apiConfig) authorizeVideoOwner(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "could not extract video by ID", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Couldn't get video", nil)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "You are not the owner of this video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
+
+func (cfg *apiConfig) handlerDirectUploadPresign(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		blobstore.PresignedUpload
+		Key       string    `json:"key"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	presigner, ok := cfg.videoStore.(blobstore.UploadPresigner)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads aren't supported by this video store", nil)
+		return
+	}
+
+	// the bucket enforces the Content-Type chosen here
+	contentType := r.URL.Query().Get("content_type")
+	if contentType == "" {
+		contentType = "video/mp4"
+	}
+	if err := validateVideo(contentType, cfg.acceptedVideoTypes); err != nil {
+		respondWithMediaError(w, err)
+		return
+	}
+
+	key := directUploadKey(video.ID, contentType)
+	upload, err := presigner.PresignUpload(r.Context(), key, contentType, cfg.maxDirectUploadSize, directUploadExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't presign upload", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		PresignedUpload: upload,
+		Key:             key,
+		ExpiresAt:       time.Now().UTC().Add(directUploadExpiry),
+	})
+}
+
+func (cfg *apiConfig) handlerDirectUploadComplete(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	// 1. Verify the object the client uploaded, at the key its presign
+	// returned
+	key := r.URL.Query().Get("key")
+	if !isDirectUploadKey(video.ID, key, cfg.acceptedVideoTypes) {
+		respondWithError(w, http.StatusBadRequest, "Invalid upload key for this video", nil)
+		return
+	}
+	info, err := cfg.videoStore.Stat(r.Context(), key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		respondWithError(w, http.StatusBadRequest, "No upload found for this video", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check upload", err)
+		return
+	}
+	if info.Size <= 0 || info.Size > cfg.maxDirectUploadSize {
+		respondWithError(w, http.StatusBadRequest, "Uploaded file has an invalid size", nil)
+		return
+	}
+	if mediaTypeToExt(normalizeMediaType(info.ContentType)) != path.Ext(key) {
+		respondWithError(w, http.StatusBadRequest, "Uploaded file doesn't have the presigned content type", nil)
+		return
+	}
+
+	// 2. Queue the usual processing; the worker fetches the object and checks
+	// its content, so large uploads don't tie up this request
+	cfg.respondWithQueuedVideo(w, r, video, processVideoPayload{
+		SourceKey: key,
+		MediaType: info.ContentType,
+	})
+}
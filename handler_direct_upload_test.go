@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/blobstore"
+	"github.com/google/uuid"
+)
+
+// presigningMemory is a memory store that hands out direct uploads, as the
+// S3 store does.
+type presigningMemory struct {
+	*blobstore.Memory
+}
+
+func (m presigningMemory) PresignUpload(ctx context.Context, key, contentType string, maxSize int64, expiry time.Duration) (blobstore.PresignedUpload, error) {
+	return blobstore.PresignedUpload{Method: http.MethodPut, URL: m.URL(key)}, nil
+}
+
+func newDirectUploadTestAPI(t *testing.T) *apiConfig {
+	t.Helper()
+	cfg := newTestAPI(t)
+	cfg.videoStore = presigningMemory{blobstore.NewMemory("http://localhost/blobs")}
+	cfg.acceptedVideoTypes = []string{"video/mp4", "video/quicktime"}
+	cfg.maxDirectUploadSize = 1 << 20
+	return cfg
+}
+
+func TestIsDirectUploadKey(t *testing.T) {
+	videoID := uuid.New()
+	accepted := []string{"video/mp4", "video/quicktime"}
+	tests := []struct {
+		name string
+		key  string
+		want bool
+	}{
+		{"mp4", directUploadKey(videoID, "video/mp4"), true},
+		{"mov", directUploadKey(videoID, "video/quicktime"), true},
+		{"another video's upload", directUploadKey(uuid.New(), "video/mp4"), false},
+		{"unaccepted type", directUploadKey(videoID, "video/webm"), false},
+		{"old fixed key", "uploads/" + videoID.String() + ".mp4", false},
+		{"nonce that isn't a UUID", "uploads/" + videoID.String() + "/x.mp4", false},
+		{"nested path", "uploads/" + videoID.String() + "/" + uuid.NewString() + "/a.mp4", false},
+		{"processed video", "landscape/" + uuid.NewString() + ".mp4", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isDirectUploadKey(videoID, tt.key, accepted); got != tt.want {
+				t.Errorf("isDirectUploadKey(%q) = %v; want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerDirectUploadPresign(t *testing.T) {
+	tests := []struct {
+		name        string
+		caller      string
+		contentType string
+		memoryStore bool
+		wantStatus  int
+		wantExt     string
+	}{
+		{"default type", "owner", "", false, http.StatusOK, ".mp4"},
+		{"mov", "owner", "video/quicktime", false, http.StatusOK, ".mov"},
+		{"unaccepted type", "owner", "image/png", false, http.StatusUnsupportedMediaType, ""},
+		{"no JWT", "", "", false, http.StatusUnauthorized, ""},
+		{"other user", "other", "", false, http.StatusUnauthorized, ""},
+		{"store can't presign", "owner", "", true, http.StatusNotImplemented, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newDirectUploadTestAPI(t)
+			if tt.memoryStore {
+				cfg.videoStore = blobstore.NewMemory("")
+			}
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, owner)
+			token := map[string]string{"owner": ownerToken, "other": otherToken}[tt.caller]
+
+			presign := func() *httptest.ResponseRecorder {
+				target := "/api/video_upload/" + video.ID.String() + "/presign?" + url.Values{"content_type": {tt.contentType}}.Encode()
+				req := httptest.NewRequest(http.MethodPost, target, nil)
+				return serve("POST /api/video_upload/{videoID}/presign", cfg.handlerDirectUploadPresign, req, token)
+			}
+			rec := presign()
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var first struct {
+				Key string `json:"key"`
+				URL string `json:"url"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &first); err != nil {
+				t.Fatal(err)
+			}
+			if !isDirectUploadKey(video.ID, first.Key, cfg.acceptedVideoTypes) || path.Ext(first.Key) != tt.wantExt {
+				t.Errorf("key = %q; want a %s upload key of the video", first.Key, tt.wantExt)
+			}
+			if !strings.HasSuffix(first.URL, first.Key) {
+				t.Errorf("url = %q; want one for %q", first.URL, first.Key)
+			}
+			var second struct {
+				Key string `json:"key"`
+			}
+			if err := json.Unmarshal(presign().Body.Bytes(), &second); err != nil {
+				t.Fatal(err)
+			}
+			if second.Key == first.Key {
+				t.Errorf("a second presign returned the same key %q", first.Key)
+			}
+		})
+	}
+}
+
+func TestHandlerDirectUploadComplete(t *testing.T) {
+	tests := []struct {
+		name string
+		// key picks the key to complete; nil completes the uploaded one
+		key        func(videoID uuid.UUID, uploaded string) string
+		storedType string
+		caller     string
+		wantStatus int
+	}{
+		{"uploaded key", nil, "video/mp4", "owner", http.StatusAccepted},
+		{"no key", func(uuid.UUID, string) string { return "" }, "video/mp4", "owner", http.StatusBadRequest},
+		{"another video's key", func(uuid.UUID, string) string { return directUploadKey(uuid.New(), "video/mp4") }, "video/mp4", "owner", http.StatusBadRequest},
+		{"nothing uploaded", func(id uuid.UUID, _ string) string { return directUploadKey(id, "video/mp4") }, "video/mp4", "owner", http.StatusBadRequest},
+		{"other content type", nil, "video/quicktime", "owner", http.StatusBadRequest},
+		{"no JWT", nil, "video/mp4", "", http.StatusUnauthorized},
+		{"other user", nil, "video/mp4", "other", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newDirectUploadTestAPI(t)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, owner)
+			token := map[string]string{"owner": ownerToken, "other": otherToken}[tt.caller]
+
+			uploaded := directUploadKey(video.ID, "video/mp4")
+			if err := cfg.videoStore.Put(context.Background(), uploaded, bytes.NewReader([]byte("video")), tt.storedType); err != nil {
+				t.Fatal(err)
+			}
+			key := uploaded
+			if tt.key != nil {
+				key = tt.key(video.ID, uploaded)
+			}
+
+			target := "/api/video_upload/" + video.ID.String() + "/complete?" + url.Values{"key": {key}}.Encode()
+			req := httptest.NewRequest(http.MethodPost, target, nil)
+			rec := serve("POST /api/video_upload/{videoID}/complete", cfg.handlerDirectUploadComplete, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusAccepted {
+				return
+			}
+
+			var resp struct {
+				JobID uuid.UUID `json:"job_id"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			job, err := cfg.db.GetJob(resp.JobID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var payload processVideoPayload
+			if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+				t.Fatal(err)
+			}
+			if payload.SourceKey != uploaded || payload.MediaType != "video/mp4" {
+				t.Errorf("job payload = %+v; want the upload at %q", payload, uploaded)
+			}
+		})
+	}
+}
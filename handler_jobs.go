@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerJobGet(w http.ResponseWriter, r *http.Request) {
+	jobID, err := uuid.Parse(r.PathValue("jobID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid job ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	job, err := cfg.db.GetJob(jobID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get job", err)
+		return
+	}
+	if job.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Job not found", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You are not the owner of this job", nil)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, job)
+}
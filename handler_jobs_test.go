@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestHandlerJobGet(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller string
+		// jobID is the job to get; empty gets the video's job
+		jobID      string
+		wantStatus int
+	}{
+		{"owner", "owner", "", http.StatusOK},
+		{"no JWT", "", "", http.StatusUnauthorized},
+		{"bad JWT", "bad", "", http.StatusUnauthorized},
+		{"other user", "other", "", http.StatusForbidden},
+		{"invalid ID", "owner", "not-a-uuid", http.StatusBadRequest},
+		{"unknown job", "owner", uuid.NewString(), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, owner)
+			job, err := cfg.jobs.enqueueVideoProcessing(video, processVideoPayload{SourceKey: "uploads/a", MediaType: "video/mp4"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			token := map[string]string{"owner": ownerToken, "other": otherToken, "bad": "not-a-jwt"}[tt.caller]
+			jobID := tt.jobID
+			if jobID == "" {
+				jobID = job.ID.String()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/jobs/"+jobID, nil)
+			rec := serve("GET /api/jobs/{jobID}", cfg.handlerJobGet, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got database.Job
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || got.ID != job.ID || got.VideoID != video.ID {
+				t.Errorf("job = %+v, %v; want %s of video %s", got, err, job.ID, video.ID)
+			}
+		})
+	}
+}
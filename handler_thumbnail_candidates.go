@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerThumbnailCandidatesGet(w http.ResponseWriter, r *http.Request) {
+	type candidate struct {
+		database.ThumbnailCandidate
+		URL      string `json:"url"`
+		Selected bool   `json:"selected"`
+	}
+
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	candidates, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidates", err)
+		return
+	}
+
+	resp := make([]candidate, 0, len(candidates))
+	for _, c := range candidates {
+		u, err := cfg.thumbnailURLResolver.ResolveURL(r.Context(), c.Key)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't resolve thumbnail URL", err)
+			return
+		}
+		resp = append(resp, candidate{
+			ThumbnailCandidate: c,
+			URL:                u,
+			Selected:           video.ThumbnailKey != nil && *video.ThumbnailKey == c.Key,
+		})
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// handlerThumbnailSelect makes one of the video's generated candidates its
+// thumbnail. An uploaded thumbnail it replaces is deleted.
+func (cfg *apiConfig) handlerThumbnailSelect(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		CandidateID uuid.UUID `json:"candidate_id"`
+	}
+
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	if err := decoder.Decode(&params); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+
+	candidate, err := cfg.db.GetThumbnailCandidate(params.CandidateID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get thumbnail candidate", err)
+		return
+	}
+	if candidate.ID == uuid.Nil || candidate.VideoID != video.ID {
+		respondWithError(w, http.StatusNotFound, "Couldn't find thumbnail candidate", nil)
+		return
+	}
+
+	if !video.ThumbnailGenerated {
+		if err := cfg.queueThumbnailDeletion(video); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't queue thumbnail deletion", err)
+			return
+		}
+	}
+
+	video.ThumbnailKey = &candidate.Key
+	video.ThumbnailWidths = ""
+	video.ThumbnailFormats = ""
+	video.ThumbnailGenerated = true
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		return
+	}
+
+	video, err = cfg.resolveVideoURLs(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video URLs", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, video)
+}
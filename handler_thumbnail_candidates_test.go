@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// createTestCandidates gives video two generated candidates and an uploaded
+// thumbnail, and returns the candidates in position order.
+func createTestCandidates(t *testing.T, cfg *apiConfig, video database.Video) []database.ThumbnailCandidate {
+	t.Helper()
+	video.ThumbnailKey = aws.String("thumbnails/a/320w.png")
+	video.ThumbnailWidths = "320"
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.db.ReplaceThumbnailCandidates(video.ID, []database.ThumbnailCandidate{
+		{Position: 0, Key: "generated/a/0.jpg"},
+		{Position: 1, Key: "generated/a/1.jpg"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	candidates, err := cfg.db.GetThumbnailCandidates(video.ID)
+	if err != nil || len(candidates) != 2 {
+		t.Fatalf("GetThumbnailCandidates = %v, %v", candidates, err)
+	}
+	return candidates
+}
+
+func TestHandlerThumbnailCandidatesGet(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller string
+		// videoID is the video asked for; empty asks for the owner's
+		videoID    string
+		wantStatus int
+	}{
+		{"owner", "owner", "", http.StatusOK},
+		{"no JWT", "", "", http.StatusUnauthorized},
+		{"bad JWT", "bad", "", http.StatusUnauthorized},
+		{"other user", "other", "", http.StatusUnauthorized},
+		{"invalid ID", "owner", "not-a-uuid", http.StatusBadRequest},
+		{"missing video", "owner", uuid.NewString(), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, owner)
+			candidates := createTestCandidates(t, cfg, video)
+			token := map[string]string{"owner": ownerToken, "other": otherToken, "bad": "not-a-jwt"}[tt.caller]
+			videoID := tt.videoID
+			if videoID == "" {
+				videoID = video.ID.String()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/"+videoID+"/thumbnails", nil)
+			rec := serve("GET /api/videos/{videoID}/thumbnails", cfg.handlerThumbnailCandidatesGet, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got []struct {
+				ID       uuid.UUID `json:"id"`
+				URL      string    `json:"url"`
+				Selected bool      `json:"selected"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(candidates) {
+				t.Fatalf("got %d candidates; want %d", len(got), len(candidates))
+			}
+			for i, c := range got {
+				want := "http://localhost/blobs/" + candidates[i].Key
+				if c.ID != candidates[i].ID || c.URL != want || c.Selected {
+					t.Errorf("candidate %d = %+v; want %s at %q, not selected", i, c, candidates[i].ID, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerThumbnailSelect(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller string
+		// videoID is the video to change; empty changes the owner's
+		videoID string
+		// body is the request body given the video's candidates and another
+		// video's
+		body       func(own []database.ThumbnailCandidate, other database.ThumbnailCandidate) string
+		wantStatus int
+	}{
+		{"own candidate", "owner", "", selectBody(1), http.StatusOK},
+		{"another video's candidate", "owner", "", func(_ []database.ThumbnailCandidate, other database.ThumbnailCandidate) string {
+			return `{"candidate_id":"` + other.ID.String() + `"}`
+		}, http.StatusNotFound},
+		{"unknown candidate", "owner", "", func([]database.ThumbnailCandidate, database.ThumbnailCandidate) string {
+			return `{"candidate_id":"` + uuid.NewString() + `"}`
+		}, http.StatusNotFound},
+		{"malformed body", "owner", "", func([]database.ThumbnailCandidate, database.ThumbnailCandidate) string { return `{"candidate_id":` }, http.StatusBadRequest},
+		{"no JWT", "", "", selectBody(1), http.StatusUnauthorized},
+		{"bad JWT", "bad", "", selectBody(1), http.StatusUnauthorized},
+		{"other user", "other", "", selectBody(1), http.StatusUnauthorized},
+		{"invalid ID", "owner", "not-a-uuid", selectBody(1), http.StatusBadRequest},
+		{"missing video", "owner", uuid.NewString(), selectBody(1), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			other, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, owner)
+			candidates := createTestCandidates(t, cfg, video)
+			otherCandidates := createTestCandidates(t, cfg, createTestVideo(t, cfg, other))
+			token := map[string]string{"owner": ownerToken, "other": otherToken, "bad": "not-a-jwt"}[tt.caller]
+			videoID := tt.videoID
+			if videoID == "" {
+				videoID = video.ID.String()
+			}
+
+			body := strings.NewReader(tt.body(candidates, otherCandidates[0]))
+			req := httptest.NewRequest(http.MethodPut, "/api/videos/"+videoID+"/thumbnail", body)
+			req.Header.Set("Content-Type", "application/json")
+			rec := serve("PUT /api/videos/{videoID}/thumbnail", cfg.handlerThumbnailSelect, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			stored, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			deletions := pendingDeletionKeys(t, cfg.db)
+			if rec.Code != http.StatusOK {
+				if *stored.ThumbnailKey != "thumbnails/a/320w.png" || stored.ThumbnailGenerated {
+					t.Errorf("rejected request changed the thumbnail to %q", *stored.ThumbnailKey)
+				}
+				if len(deletions) != 0 {
+					t.Errorf("rejected request queued deletions %v", deletions)
+				}
+				return
+			}
+			if *stored.ThumbnailKey != candidates[1].Key || !stored.ThumbnailGenerated || stored.ThumbnailWidths != "" {
+				t.Errorf("thumbnail = %q, generated %v, widths %q; want candidate %q", *stored.ThumbnailKey, stored.ThumbnailGenerated, stored.ThumbnailWidths, candidates[1].Key)
+			}
+			if !slices.Contains(deletions, "thumbnails/a/320w.png") {
+				t.Errorf("pending deletions = %v; want the replaced upload", deletions)
+			}
+		})
+	}
+}
+
+// selectBody picks the video's candidate at position n.
+func selectBody(n int) func([]database.ThumbnailCandidate, database.ThumbnailCandidate) string {
+	return func(own []database.ThumbnailCandidate, _ database.ThumbnailCandidate) string {
+		return `{"candidate_id":"` + own[n].ID.String() + `"}`
+	}
+}
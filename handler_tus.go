@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// The handlers in this file implement the tus 1.0 resumable upload protocol
+// (core plus the creation, expiration and termination extensions). Uploads
+// are created against a video and assembled into the regular processing path
+// once the last byte arrives.
+
+func setTusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	setTusHeaders(w)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return false
+	}
+	return true
+}
+
+func setUploadExpires(w http.ResponseWriter, upload database.Upload) {
+	w.Header().Set("Upload-Expires", upload.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	setTusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,expiration,termination")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(cfg.tus.maxSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	if length > cfg.tus.maxSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Upload exceeds Tus-Max-Size", nil)
+		return
+	}
+
+	rawMetadata := r.Header.Get("Upload-Metadata")
+	metadata, err := parseTusMetadata(rawMetadata)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Metadata", err)
+		return
+	}
+	if err := validateVideo(tusFileType(metadata), cfg.acceptedVideoTypes); err != nil {
+		respondWithMediaError(w, err)
+		return
+	}
+
+	upload, err := cfg.db.CreateUpload(database.CreateUploadParams{
+		VideoID:  video.ID,
+		UserID:   video.UserID,
+		Length:   length,
+		Metadata: rawMetadata,
+		TTL:      cfg.tus.ttl,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	f, err := os.Create(cfg.tus.dataPath(upload.ID))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+	f.Close()
+
+	setUploadExpires(w, upload)
+	w.Header().Set("Location", fmt.Sprintf("/api/tus/uploads/%s", upload.ID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// authorizeUpload loads the upload in the uploadID path value and checks it
+// belongs to the caller and hasn't expired.
+func (cfg *apiConfig) authorizeUpload(w http.ResponseWriter, r *http.Request) (database.Upload, bool) {
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Invalid upload ID", err)
+		return database.Upload{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Upload{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Upload{}, false
+	}
+
+	upload, err := cfg.db.GetUpload(uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return database.Upload{}, false
+	}
+	if upload.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return database.Upload{}, false
+	}
+	if upload.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You are not the owner of this upload", nil)
+		return database.Upload{}, false
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		respondWithError(w, http.StatusGone, "Upload has expired", nil)
+		return database.Upload{}, false
+	}
+	return upload, true
+}
+
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	upload, ok := cfg.authorizeUpload(w, r)
+	if !ok {
+		return
+	}
+
+	setUploadExpires(w, upload)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	if upload.Metadata != "" {
+		w.Header().Set("Upload-Metadata", upload.Metadata)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+	upload, ok := cfg.authorizeUpload(w, r)
+	if !ok {
+		return
+	}
+
+	unlock := cfg.tus.lock(upload.ID)
+	defer unlock()
+
+	// re-read under the lock, a concurrent PATCH may have moved the offset
+	upload, err := cfg.db.GetUpload(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return
+	}
+	if upload.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		respondWithError(w, http.StatusGone, "Upload has expired", nil)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+	if offset != upload.Offset {
+		respondWithError(w, http.StatusConflict, "Upload-Offset doesn't match the current offset", nil)
+		return
+	}
+
+	f, err := os.OpenFile(cfg.tus.dataPath(upload.ID), os.O_WRONLY, 0644)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open upload file", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't seek upload file", err)
+		return
+	}
+
+	// keep whatever arrived even if the connection drops mid-chunk, so the
+	// client can resume from there
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, upload.Length-offset))
+	upload.Offset += n
+	if err := cfg.db.UpdateUploadOffset(upload.ID, upload.Offset); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload offset", err)
+		return
+	}
+	if copyErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write chunk", copyErr)
+		return
+	}
+
+	if upload.Offset == upload.Length {
+		if err := cfg.finishTusUpload(upload); err != nil {
+			respondWithPipelineError(w, err)
+			return
+		}
+	}
+
+	setUploadExpires(w, upload)
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishTusUpload moves a fully received upload to the staging directory,
+// queues it for the regular processing path and drops the upload state. If it
+// can't be queued the data is put back, so a PATCH of no bytes at the final
+// offset retries.
+func (cfg *apiConfig) finishTusUpload(upload database.Upload) error {
+	video, err := cfg.db.GetVideo(upload.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		return errors.New("video for upload no longer exists")
+	}
+
+	metadata, err := parseTusMetadata(upload.Metadata)
+	if err != nil {
+		return err
+	}
+	mediaType := tusFileType(metadata)
+
+	stagedPath := filepath.Join(cfg.stagingRoot, fmt.Sprintf("tus-%s%s", upload.ID, mediaTypeToExt(mediaType)))
+	if err := moveFile(cfg.tus.dataPath(upload.ID), stagedPath); err != nil {
+		return err
+	}
+	if _, err := cfg.jobs.enqueueVideoProcessing(video, processVideoPayload{
+		SourcePath: stagedPath,
+		MediaType:  mediaType,
+	}); err != nil {
+		if moveErr := moveFile(stagedPath, cfg.tus.dataPath(upload.ID)); moveErr != nil {
+			// without its data the upload can't be retried, so drop it
+			log.Printf("Couldn't restore upload %s: %v", upload.ID, moveErr)
+			os.Remove(stagedPath)
+			if delErr := cfg.db.DeleteUpload(upload.ID); delErr != nil {
+				log.Printf("Couldn't delete upload %s: %v", upload.ID, delErr)
+			}
+		}
+		return err
+	}
+
+	if err := cfg.db.DeleteUpload(upload.ID); err != nil {
+		return err
+	}
+	return cfg.tus.remove(upload.ID)
+}
+
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	upload, ok := cfg.authorizeUpload(w, r)
+	if !ok {
+		return
+	}
+
+	unlock := cfg.tus.lock(upload.ID)
+	defer unlock()
+
+	if err := cfg.db.DeleteUpload(upload.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete upload", err)
+		return
+	}
+	if err := cfg.tus.remove(upload.ID); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete upload file", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func newTusTestAPI(t *testing.T, ttl time.Duration) *apiConfig {
+	t.Helper()
+	cfg := newTestAPI(t)
+	tus, err := newTusUploads(t.TempDir(), 1<<20, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.tus = tus
+	return cfg
+}
+
+// tusRequest is a tus request for path with the protocol version set.
+func tusRequest(method, path string, body []byte, headers map[string]string) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return req
+}
+
+// createTusUpload creates an upload of length bytes and returns its ID.
+func createTusUpload(t *testing.T, cfg *apiConfig, video database.Video, token string, length int) uuid.UUID {
+	t.Helper()
+	req := tusRequest(http.MethodPost, "/api/tus/"+video.ID.String(), nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filetype " + base64.StdEncoding.EncodeToString([]byte("video/mp4")),
+	})
+	rec := serve("POST /api/tus/{videoID}", cfg.handlerTusCreate, req, token)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create: status = %d: %s", rec.Code, rec.Body)
+	}
+	id, err := uuid.Parse(strings.TrimPrefix(rec.Header().Get("Location"), "/api/tus/uploads/"))
+	if err != nil {
+		t.Fatalf("Location %q: %v", rec.Header().Get("Location"), err)
+	}
+	return id
+}
+
+func patchTus(cfg *apiConfig, id uuid.UUID, token string, offset int, chunk []byte) *httptest.ResponseRecorder {
+	req := tusRequest(http.MethodPatch, "/api/tus/uploads/"+id.String(), chunk, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	})
+	return serve("PATCH /api/tus/uploads/{uploadID}", cfg.handlerTusPatch, req, token)
+}
+
+func headTus(cfg *apiConfig, id uuid.UUID, token string) *httptest.ResponseRecorder {
+	req := tusRequest(http.MethodHead, "/api/tus/uploads/"+id.String(), nil, nil)
+	return serve("HEAD /api/tus/uploads/{uploadID}", cfg.handlerTusHead, req, token)
+}
+
+func TestHandlerTusCreate(t *testing.T) {
+	mp4 := "filetype " + base64.StdEncoding.EncodeToString([]byte("video/mp4"))
+	tests := []struct {
+		name       string
+		caller     string
+		headers    map[string]string
+		noVersion  bool
+		wantStatus int
+	}{
+		{"created", "owner", map[string]string{"Upload-Length": "10", "Upload-Metadata": mp4}, false, http.StatusCreated},
+		{"no metadata defaults to MP4", "owner", map[string]string{"Upload-Length": "10"}, false, http.StatusCreated},
+		{"no Tus-Resumable", "owner", map[string]string{"Upload-Length": "10"}, true, http.StatusPreconditionFailed},
+		{"no JWT", "", map[string]string{"Upload-Length": "10"}, false, http.StatusUnauthorized},
+		{"other user", "other", map[string]string{"Upload-Length": "10"}, false, http.StatusUnauthorized},
+		{"no length", "owner", nil, false, http.StatusBadRequest},
+		{"too large", "owner", map[string]string{"Upload-Length": strconv.Itoa(1<<20 + 1)}, false, http.StatusRequestEntityTooLarge},
+		{"bad metadata", "owner", map[string]string{"Upload-Length": "10", "Upload-Metadata": "filetype !!"}, false, http.StatusBadRequest},
+		{"unaccepted type", "owner", map[string]string{"Upload-Length": "10", "Upload-Metadata": "filetype " + base64.StdEncoding.EncodeToString([]byte("application/pdf"))}, false, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTusTestAPI(t, time.Hour)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, owner)
+			token := map[string]string{"owner": ownerToken, "other": otherToken}[tt.caller]
+
+			req := tusRequest(http.MethodPost, "/api/tus/"+video.ID.String(), nil, tt.headers)
+			if tt.noVersion {
+				req.Header.Del("Tus-Resumable")
+			}
+			rec := serve("POST /api/tus/{videoID}", cfg.handlerTusCreate, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Header().Get("Tus-Resumable") != tusVersion {
+				t.Errorf("Tus-Resumable = %q", rec.Header().Get("Tus-Resumable"))
+			}
+			if rec.Code != http.StatusCreated {
+				return
+			}
+			id, err := uuid.Parse(strings.TrimPrefix(rec.Header().Get("Location"), "/api/tus/uploads/"))
+			if err != nil {
+				t.Fatalf("Location = %q", rec.Header().Get("Location"))
+			}
+			if upload, _ := cfg.db.GetUpload(id); upload.Length != 10 || upload.VideoID != video.ID {
+				t.Errorf("stored upload = %+v", upload)
+			}
+			if rec.Header().Get("Upload-Expires") == "" {
+				t.Errorf("no Upload-Expires")
+			}
+		})
+	}
+}
+
+func TestHandlerTusHead(t *testing.T) {
+	cfg := newTusTestAPI(t, time.Hour)
+	owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+	_, otherToken := createTestUser(t, cfg, "other@example.com")
+	id := createTusUpload(t, cfg, createTestVideo(t, cfg, owner), ownerToken, 10)
+	if rec := patchTus(cfg, id, ownerToken, 0, []byte("abcd")); rec.Code != http.StatusNoContent {
+		t.Fatalf("PATCH: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		name       string
+		id         uuid.UUID
+		token      string
+		wantStatus int
+		wantOffset string
+	}{
+		{"owner", id, ownerToken, http.StatusOK, "4"},
+		{"no JWT", id, "", http.StatusUnauthorized, ""},
+		{"other user", id, otherToken, http.StatusForbidden, ""},
+		{"unknown upload", uuid.New(), ownerToken, http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := headTus(cfg, tt.id, tt.token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if got := rec.Header().Get("Upload-Offset"); got != tt.wantOffset {
+				t.Errorf("Upload-Offset = %q; want %q", got, tt.wantOffset)
+			}
+			if tt.wantStatus == http.StatusOK && rec.Header().Get("Upload-Length") != "10" {
+				t.Errorf("Upload-Length = %q; want 10", rec.Header().Get("Upload-Length"))
+			}
+		})
+	}
+}
+
+func TestHandlerTusPatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		caller      string
+		contentType string
+		offset      int
+		wantStatus  int
+		wantOffset  int64
+	}{
+		{"appends", "owner", "application/offset+octet-stream", 4, http.StatusNoContent, 8},
+		{"wrong offset", "owner", "application/offset+octet-stream", 0, http.StatusConflict, 4},
+		{"offset past the end", "owner", "application/offset+octet-stream", 6, http.StatusConflict, 4},
+		{"wrong Content-Type", "owner", "application/octet-stream", 4, http.StatusUnsupportedMediaType, 4},
+		{"no JWT", "", "application/offset+octet-stream", 4, http.StatusUnauthorized, 4},
+		{"other user", "other", "application/offset+octet-stream", 4, http.StatusForbidden, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTusTestAPI(t, time.Hour)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			id := createTusUpload(t, cfg, createTestVideo(t, cfg, owner), ownerToken, 10)
+			if rec := patchTus(cfg, id, ownerToken, 0, []byte("abcd")); rec.Code != http.StatusNoContent {
+				t.Fatalf("first PATCH: status = %d: %s", rec.Code, rec.Body)
+			}
+			token := map[string]string{"owner": ownerToken, "other": otherToken}[tt.caller]
+
+			req := tusRequest(http.MethodPatch, "/api/tus/uploads/"+id.String(), []byte("efgh"), map[string]string{
+				"Content-Type":  tt.contentType,
+				"Upload-Offset": strconv.Itoa(tt.offset),
+			})
+			rec := serve("PATCH /api/tus/uploads/{uploadID}", cfg.handlerTusPatch, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if upload, _ := cfg.db.GetUpload(id); upload.Offset != tt.wantOffset {
+				t.Errorf("stored offset = %d; want %d", upload.Offset, tt.wantOffset)
+			}
+			data, err := os.ReadFile(cfg.tus.dataPath(id))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(data)) != tt.wantOffset {
+				t.Errorf("upload file has %d bytes; want %d", len(data), tt.wantOffset)
+			}
+		})
+	}
+}
+
+// failingJobStore fails to create jobs while fail is set.
+type failingJobStore struct {
+	database.Store
+	fail bool
+}
+
+func (s *failingJobStore) CreateJob(params database.CreateJobParams) (database.Job, error) {
+	if s.fail {
+		return database.Job{}, errors.New("job store unavailable")
+	}
+	return s.Store.CreateJob(params)
+}
+
+func TestHandlerTusPatchCompletes(t *testing.T) {
+	cfg := newTusTestAPI(t, time.Hour)
+	store := &failingJobStore{Store: cfg.db, fail: true}
+	cfg.db = store
+	owner, token := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, owner)
+	id := createTusUpload(t, cfg, video, token, 8)
+	if rec := patchTus(cfg, id, token, 0, []byte("abcd")); rec.Code != http.StatusNoContent {
+		t.Fatalf("first PATCH: status = %d: %s", rec.Code, rec.Body)
+	}
+
+	// the last chunk arrives but processing can't be queued: the data is
+	// kept for a retry
+	if rec := patchTus(cfg, id, token, 4, []byte("efgh")); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("last PATCH with a failing queue: status = %d: %s", rec.Code, rec.Body)
+	}
+	if data, err := os.ReadFile(cfg.tus.dataPath(id)); err != nil || string(data) != "abcdefgh" {
+		t.Fatalf("upload data after a failed enqueue = %q, %v", data, err)
+	}
+	if rec := headTus(cfg, id, token); rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != "8" {
+		t.Fatalf("HEAD after a failed enqueue: status = %d, Upload-Offset = %q", rec.Code, rec.Header().Get("Upload-Offset"))
+	}
+
+	// an empty PATCH at the final offset retries
+	store.fail = false
+	if rec := patchTus(cfg, id, token, 8, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("retry PATCH: status = %d: %s", rec.Code, rec.Body)
+	}
+	if upload, _ := cfg.db.GetUpload(id); upload.ID != uuid.Nil {
+		t.Errorf("upload still exists after completing")
+	}
+	if _, err := os.Stat(cfg.tus.dataPath(id)); !os.IsNotExist(err) {
+		t.Errorf("upload file still exists after completing: %v", err)
+	}
+
+	job, err := cfg.db.ClaimNextJob(time.Now().Add(time.Second))
+	if err != nil || job.VideoID != video.ID || job.Kind != jobKindProcessVideo {
+		t.Fatalf("queued job = %+v, %v", job, err)
+	}
+	var payload processVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(payload.SourcePath); err != nil || string(data) != "abcdefgh" {
+		t.Errorf("staged upload = %q, %v", data, err)
+	}
+}
+
+func TestHandlerTusDelete(t *testing.T) {
+	tests := []struct {
+		name       string
+		caller     string
+		wantStatus int
+		wantGone   bool
+	}{
+		{"owner", "owner", http.StatusNoContent, true},
+		{"no JWT", "", http.StatusUnauthorized, false},
+		{"other user", "other", http.StatusForbidden, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTusTestAPI(t, time.Hour)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			id := createTusUpload(t, cfg, createTestVideo(t, cfg, owner), ownerToken, 10)
+			token := map[string]string{"owner": ownerToken, "other": otherToken}[tt.caller]
+
+			req := tusRequest(http.MethodDelete, "/api/tus/uploads/"+id.String(), nil, nil)
+			rec := serve("DELETE /api/tus/uploads/{uploadID}", cfg.handlerTusDelete, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			_, statErr := os.Stat(cfg.tus.dataPath(id))
+			if gone := os.IsNotExist(statErr); gone != tt.wantGone {
+				t.Errorf("upload file removed = %v; want %v", gone, tt.wantGone)
+			}
+			wantHead := http.StatusOK
+			if tt.wantGone {
+				wantHead = http.StatusNotFound
+			}
+			if rec := headTus(cfg, id, ownerToken); rec.Code != wantHead {
+				t.Errorf("HEAD afterwards: status = %d; want %d", rec.Code, wantHead)
+			}
+		})
+	}
+}
+
+func TestTusUploadExpiry(t *testing.T) {
+	cfg := newTusTestAPI(t, -time.Second)
+	owner, token := createTestUser(t, cfg, "owner@example.com")
+	id := createTusUpload(t, cfg, createTestVideo(t, cfg, owner), token, 10)
+
+	if rec := headTus(cfg, id, token); rec.Code != http.StatusGone {
+		t.Errorf("HEAD of an expired upload: status = %d; want %d", rec.Code, http.StatusGone)
+	}
+	if rec := patchTus(cfg, id, token, 0, []byte("abcd")); rec.Code != http.StatusGone {
+		t.Errorf("PATCH of an expired upload: status = %d; want %d", rec.Code, http.StatusGone)
+	}
+
+	if err := cfg.cleanupExpiredUploads(); err != nil {
+		t.Fatalf("cleanupExpiredUploads: %v", err)
+	}
+	if upload, _ := cfg.db.GetUpload(id); upload.ID != uuid.Nil {
+		t.Errorf("expired upload still exists")
+	}
+	if _, err := os.Stat(cfg.tus.dataPath(id)); !os.IsNotExist(err) {
+		t.Errorf("expired upload's file still exists: %v", err)
+	}
+}
+
+// TestTusLockOutlivesRemove checks removing an upload's data while a request
+// holds its lock doesn't let the next request in alongside it.
+func TestTusLockOutlivesRemove(t *testing.T) {
+	tus, err := newTusUploads(t.TempDir(), 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.New()
+
+	unlock := tus.lock(id)
+	if err := tus.remove(id); err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		unlock := tus.lock(id)
+		close(acquired)
+		unlock()
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("a second request got the lock while the first held it")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-acquired
+
+	tus.mu.Lock()
+	defer tus.mu.Unlock()
+	if len(tus.locks) != 0 {
+		t.Errorf("%d locks left after every request released them", len(tus.locks))
+	}
+}
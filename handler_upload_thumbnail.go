@@ -1,37 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
-	"os"
-
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
-	"github.com/google/uuid"
+	"strconv"
+	"strings"
 )
 
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
-	videoIDString := r.PathValue("videoID")
-	videoID, err := uuid.Parse(videoIDString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+	// check ownership before doing any work on the upload
+	db_video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
 		return
 	}
 
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
-	}
-
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
-
 	const maxMemory = 10 << 20 // 10MB memory
 	r.ParseMultipartForm(maxMemory)
 
@@ -42,46 +27,83 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 	}
 	defer file.Close()
 
-	mediaType := header.Header.Get("Content-Type")
-	if mediaType == "" {
-		respondWithError(w, http.StatusBadRequest, "Missing Content-Type for thumbnail", nil)
+	const maxThumbnailSize = 20 << 20 // 20MB
+	data, err := io.ReadAll(io.LimitReader(file, maxThumbnailSize+1))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to read thumbnail", err)
 		return
 	}
-	if mediaType != "image/jpeg" && mediaType != "image/png" {
-		respondWithError(w, http.StatusBadRequest, "Format Not Supported: Thumbnail should by either jpg or png", nil)
+	if len(data) > maxThumbnailSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Thumbnail is too large", nil)
 		return
 	}
 
-	assetPath := getAssetPath(mediaType)
-	assetDiskPath := cfg.getAssetDiskPath(assetPath)
-
-	dst, err := os.Create(assetDiskPath)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Unable to create file on server", err)
+	// The stored type comes from the file's magic bytes; a declared type
+	// that disagrees with them is rejected
+	mediaType := sniffImageType(data[:min(len(data), sniffLen)])
+	if declared := header.Header.Get("Content-Type"); declared != "" && normalizeMediaType(declared) != normalizeMediaType(mediaType) {
+		err := fmt.Errorf("%w: declared %s, content is %s", errMediaTypeMismatch, declared, mediaType)
+		respondWithMediaError(w, err)
 		return
 	}
-	defer dst.Close()
-	if _, err = io.Copy(dst, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error saving file", err)
+	mediaType = normalizeMediaType(mediaType)
+	if mediaType != "image/jpeg" && mediaType != "image/png" {
+		err := fmt.Errorf("%w %q: Thumbnail should by either jpg or png", errUnsupportedMediaType, mediaType)
+		respondWithMediaError(w, err)
 		return
 	}
 
-	db_video, err := cfg.db.GetVideo(videoID)
+	// Decode, strip metadata and resize to the configured widths
+	variants, err := processThumbnail(data, mediaType, cfg.thumbnailWidths)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to get video details from DB", err)
+		respondWithPipelineError(w, err)
 		return
 	}
-	if db_video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "You are not authorized to update this video", err)
-		return
+	dir := "thumbnails/" + strings.TrimSuffix(getAssetPath(mediaType), mediaTypeToExt(mediaType))
+	widths := make([]string, 0, len(variants))
+	keys := make([]string, 0, len(variants))
+	var assetPath string
+	for _, variant := range variants {
+		assetPath = thumbnailVariantKey(dir, variant.width, mediaTypeToExt(mediaType))
+		err = cfg.thumbnailStore.Put(r.Context(), assetPath, bytes.NewReader(variant.data), mediaType)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Error saving file", err)
+			return
+		}
+		widths = append(widths, strconv.Itoa(variant.width))
+		keys = append(keys, assetPath)
+	}
+
+	// an uploaded thumbnail this one replaces is no longer used anywhere
+	if !db_video.ThumbnailGenerated {
+		if err := cfg.queueThumbnailDeletion(db_video); err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't queue thumbnail deletion", err)
+			return
+		}
 	}
 
-	url := cfg.getAssetURL(assetPath)
-	db_video.ThumbnailURL = &url
+	// the largest variant doubles as the thumbnail
+	db_video.ThumbnailKey = &assetPath
+	db_video.ThumbnailWidths = strings.Join(widths, ",")
+	db_video.ThumbnailFormats = ""
+	db_video.ThumbnailGenerated = false
 	err = cfg.db.UpdateVideo(db_video)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
 		return
 	}
+
+	if len(cfg.thumbnailFormats) > 0 {
+		// the other formats are an optimization; the upload stands without them
+		if _, err := cfg.jobs.enqueueThumbnailFormats(db_video, keys); err != nil {
+			log.Printf("video %s: couldn't queue thumbnail formats: %v", db_video.ID, err)
+		}
+	}
+
+	db_video, err = cfg.resolveVideoURLs(r.Context(), db_video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video URLs", err)
+		return
+	}
 	respondWithJSON(w, http.StatusOK, db_video)
 }
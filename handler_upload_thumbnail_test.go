@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestNormalizeMediaType(t *testing.T) {
+	tests := map[string]string{
+		"image/png":                  "image/png",
+		"IMAGE/PNG":                  "image/png",
+		"image/jpg":                  "image/jpeg",
+		"image/jpeg; name=boots.jpg": "image/jpeg",
+		"image/pjpeg":                "image/jpeg",
+		"application/pdf":            "application/pdf",
+		"not a type;;":               "not a type;;",
+	}
+	for in, want := range tests {
+		if got := normalizeMediaType(in); got != want {
+			t.Errorf("normalizeMediaType(%q) = %q; want %q", in, got, want)
+		}
+	}
+}
+
+func thumbnailUploadRequest(t *testing.T, videoID, contentType string, data []byte) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", `form-data; name="thumbnail"; filename="upload"`)
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/thumbnail_upload/"+videoID, body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, image.NewRGBA(image.Rect(0, 0, 64, 36)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestHandlerUploadThumbnail(t *testing.T) {
+	horizontal := testPNG(t, 64, 36)
+	vertical := testPNG(t, 36, 64)
+	pdf := []byte("%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\ntrailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	mp4 := []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom\x00\x00\x00\x08free")
+
+	tests := []struct {
+		name   string
+		caller string
+		// videoID is the video to upload to; empty uses the owner's
+		videoID     string
+		data        []byte
+		contentType string
+		wantStatus  int
+		wantCode    string
+	}{
+		{"png", "owner", "", horizontal, "image/png", http.StatusOK, ""},
+		{"png with parameters", "owner", "", vertical, "image/png; name=boots.png", http.StatusOK, ""},
+		{"png without a declared type", "owner", "", horizontal, "", http.StatusOK, ""},
+		{"jpeg", "owner", "", testJPEG(t), "image/jpeg", http.StatusOK, ""},
+		// browsers and clients send the nonstandard image/jpg
+		{"jpeg declared as image/jpg", "owner", "", testJPEG(t), "image/jpg", http.StatusOK, ""},
+		{"png declared as jpeg", "owner", "", horizontal, "image/jpeg", http.StatusUnsupportedMediaType, "media_type_mismatch"},
+		{"pdf", "owner", "", pdf, "application/pdf", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{"pdf declared as png", "owner", "", pdf, "image/png", http.StatusUnsupportedMediaType, "media_type_mismatch"},
+		{"video without a declared type", "owner", "", mp4, "", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{"no JWT", "", "", horizontal, "image/png", http.StatusUnauthorized, ""},
+		{"bad JWT", "bad", "", horizontal, "image/png", http.StatusUnauthorized, ""},
+		{"other user", "other", "", horizontal, "image/png", http.StatusUnauthorized, ""},
+		{"invalid ID", "owner", "not-a-uuid", horizontal, "image/png", http.StatusBadRequest, ""},
+		{"missing video", "owner", uuid.NewString(), horizontal, "image/png", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, owner)
+			token := map[string]string{"owner": ownerToken, "other": otherToken, "bad": "not-a-jwt"}[tt.caller]
+			videoID := tt.videoID
+			if videoID == "" {
+				videoID = video.ID.String()
+			}
+
+			req := thumbnailUploadRequest(t, videoID, tt.contentType, tt.data)
+			rec := serve("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			stored, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusOK {
+				if tt.wantCode != "" {
+					var resp struct{ Code string }
+					if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Code != tt.wantCode {
+						t.Errorf("error code = %q, %v; want %q", resp.Code, err, tt.wantCode)
+					}
+				}
+				if stored.ThumbnailKey != nil {
+					t.Errorf("rejected upload recorded thumbnail %q", *stored.ThumbnailKey)
+				}
+				objects, err := cfg.thumbnailStore.List(context.Background(), "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(objects) != 0 {
+					t.Errorf("rejected upload stored %v", objects)
+				}
+				return
+			}
+			if stored.ThumbnailKey == nil {
+				t.Fatalf("no thumbnail recorded")
+			}
+			if _, err := cfg.thumbnailStore.Stat(context.Background(), *stored.ThumbnailKey); err != nil {
+				t.Errorf("Stat(%q): %v", *stored.ThumbnailKey, err)
+			}
+		})
+	}
+}
@@ -1,30 +1,34 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"mime"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 	"github.com/google/uuid"
 )
 
-func processVideoForFastStart(inputFilePath string) (string, error) {
+func processVideoForFastStart(ctx context.Context, inputFilePath string, duration time.Duration, codecArgs []string, onProgress func(percent float64)) (string, error) {
 	processedFilePath := fmt.Sprintf("%s.processing", inputFilePath)
 
-	cmd := exec.Command("ffmpeg", "-i", inputFilePath, "-movflags", "faststart", "-codec", "copy", "-f", "mp4", processedFilePath)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("error processing video: %s, %v", stderr.String(), err)
+	args := append([]string{"-i", inputFilePath, "-movflags", "faststart"}, codecArgs...)
+	args = append(args, "-f", "mp4", processedFilePath)
+	if err := runFFmpeg(ctx, args, duration, onProgress); err != nil {
+		return "", fmt.Errorf("error processing video: %w", err)
 	}
 
 	fileInfo, err := os.Stat(processedFilePath)
@@ -38,161 +42,334 @@ func processVideoForFastStart(inputFilePath string) (string, error) {
 	return processedFilePath, nil
 }
 
-func getVideoAspectRatio(filePath string) (string, error) {
-	cmd := exec.Command("ffprobe", "-v", "error", "-print_format", "json", "-show_streams", filePath)
+// generateSpriteSheets writes one frame every interval, scaled to
+// tileWidth x tileHeight, tiled cols x rows per JPEG sheet into outDir as
+// sprite-001.jpg, sprite-002.jpg...
+func generateSpriteSheets(ctx context.Context, inputPath, outDir string, duration, interval time.Duration, tileWidth, tileHeight, cols, rows int, onProgress func(percent float64)) error {
+	filter := fmt.Sprintf("fps=1/%s,scale=%d:%d,setsar=1,tile=%dx%d",
+		strconv.FormatFloat(interval.Seconds(), 'f', -1, 64), tileWidth, tileHeight, cols, rows)
+	args := []string{"-i", inputPath, "-vf", filter, "-q:v", "4", "-y", filepath.Join(outDir, "sprite-%03d.jpg")}
+	if err := runFFmpeg(ctx, args, duration, onProgress); err != nil {
+		return fmt.Errorf("error generating sprite sheets: %w", err)
+	}
+	return nil
+}
+
+// runFFmpeg runs ffmpeg with args, reporting progress through the input's
+// duration to onProgress. The error carries ffmpeg's stderr.
+func runFFmpeg(ctx context.Context, args []string, duration time.Duration, onProgress func(percent float64)) error {
+	args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	reportFFmpegProgress(stdout, duration, onProgress)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s, %v", stderr.String(), err)
+	}
+	return nil
+}
+
+// reportFFmpegProgress reads ffmpeg's -progress output (key=value lines) and
+// reports the share of duration processed so far.
+func reportFFmpegProgress(progress io.Reader, duration time.Duration, onProgress func(percent float64)) {
+	scanner := bufio.NewScanner(progress)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok || onProgress == nil || duration <= 0 {
+			continue
+		}
+		switch key {
+		case "out_time_us", "out_time_ms": // both are microseconds
+			us, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || us < 0 {
+				continue
+			}
+			percent := float64(us) / float64(duration.Microseconds()) * 100
+			onProgress(min(percent, 100))
+		case "progress":
+			if value == "end" {
+				onProgress(100)
+			}
+		}
+	}
+}
+
+var errNoVideoStream = errors.New("no video stream found")
+
+// getVideoMediaInfo runs ffprobe once over the container and its streams.
+func getVideoMediaInfo(ctx context.Context, filePath string) (database.MediaInfo, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-print_format", "json", "-show_format", "-show_streams", filePath)
 	var buf bytes.Buffer
 	cmd.Stdout = &buf
-	err := cmd.Run()
-	if err != nil {
-		fmt.Println(err)
-		return "Unable to execute the command", err
+	if err := cmd.Run(); err != nil {
+		return database.MediaInfo{}, err
 	}
+	return parseMediaInfo(buf.Bytes())
+}
 
-	type streamDetails struct {
+// parseMediaInfo reads ffprobe's JSON output, taking the first video and
+// audio streams whatever their order.
+func parseMediaInfo(data []byte) (database.MediaInfo, error) {
+	params := struct {
+		Format struct {
+			FormatName string `json:"format_name"`
+			Duration   string `json:"duration"`
+			BitRate    string `json:"bit_rate"`
+		} `json:"format"`
 		Streams []struct {
-			DisplayAspectRatio string `json:"display_aspect_ratio,omitempty"`
+			CodecType    string `json:"codec_type"`
+			CodecName    string `json:"codec_name"`
+			Profile      string `json:"profile"`
+			Width        int    `json:"width"`
+			Height       int    `json:"height"`
+			SampleAspect string `json:"sample_aspect_ratio"`
+			AvgFrameRate string `json:"avg_frame_rate"`
+			RFrameRate   string `json:"r_frame_rate"`
+			Channels     int    `json:"channels"`
+			SampleRate   string `json:"sample_rate"`
+			Tags         struct {
+				Rotate string `json:"rotate"`
+			} `json:"tags"`
+			SideDataList []struct {
+				Rotation *float64 `json:"rotation"`
+			} `json:"side_data_list"`
 		} `json:"streams"`
+	}{}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return database.MediaInfo{}, err
 	}
-	params := streamDetails{}
-	err = json.Unmarshal(buf.Bytes(), &params)
-	if err != nil {
-		return "", err
+
+	info := database.MediaInfo{Container: params.Format.FormatName}
+	if params.Format.Duration != "" {
+		seconds, err := strconv.ParseFloat(params.Format.Duration, 64)
+		if err != nil {
+			return database.MediaInfo{}, fmt.Errorf("invalid duration %q: %w", params.Format.Duration, err)
+		}
+		info.DurationSeconds = seconds
+	}
+	info.BitRate, _ = strconv.ParseInt(params.Format.BitRate, 10, 64)
+
+	foundVideo, foundAudio := false, false
+	for _, stream := range params.Streams {
+		switch {
+		case stream.CodecType == "video" && !foundVideo:
+			foundVideo = true
+			info.VideoCodec = stream.CodecName
+			info.VideoProfile = stream.Profile
+			info.Width = stream.Width
+			info.Height = stream.Height
+			info.SampleAspectRatio = stream.SampleAspect
+			info.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			if info.FrameRate == 0 {
+				info.FrameRate = parseFrameRate(stream.RFrameRate)
+			}
+			// the display matrix is counter-clockwise; the legacy tag clockwise
+			for _, sd := range stream.SideDataList {
+				if sd.Rotation != nil {
+					info.Rotation = int(-*sd.Rotation)
+				}
+			}
+			if rotate, err := strconv.Atoi(stream.Tags.Rotate); err == nil && info.Rotation == 0 {
+				info.Rotation = rotate
+			}
+			info.Rotation = ((info.Rotation % 360) + 360) % 360
+		case stream.CodecType == "audio" && !foundAudio:
+			foundAudio = true
+			info.AudioCodec = stream.CodecName
+			info.AudioChannels = stream.Channels
+			info.AudioSampleRate, _ = strconv.Atoi(stream.SampleRate)
+		}
 	}
-	video_aspect_ratio := params.Streams[0].DisplayAspectRatio
-	if video_aspect_ratio == "16:9" || video_aspect_ratio == "9:16" {
-		return video_aspect_ratio, nil
+	if !foundVideo || info.Width <= 0 || info.Height <= 0 {
+		return database.MediaInfo{}, errNoVideoStream
 	}
-	return "other", nil
+	return info, nil
+}
 
+// parseFrameRate parses ffprobe rates such as "30000/1001"; unknown rates
+// ("0/0") are 0.
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(rate, 64)
+		return f
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
 }
 
-func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
-	const uploadLimit = 1 << 30 //1GB (setting an upload limit of 1GB)
-	r.Body = http.MaxBytesReader(w, r.Body, uploadLimit)
+// aspectTolerance is how far (relative) a display aspect ratio may be from a
+// bucket's and still land in it, so e.g. 1920x1088 encodes count as 16:9.
+const aspectTolerance = 0.03
 
-	// 1. Extracting Video ID from URL and Parse it into UUID
-	videoIdString := r.PathValue("videoID")
-	videoId, err := uuid.Parse(videoIdString)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
-		return
+// aspectBuckets are the storage prefixes for common display aspect ratios.
+var aspectBuckets = []struct {
+	prefix string
+	ratio  float64
+}{
+	{"landscape", 16.0 / 9},
+	{"portrait", 9.0 / 16},
+	{"square", 1},
+	{"standard", 4.0 / 3},
+	{"standard-portrait", 3.0 / 4},
+}
+
+// ultrawideRatio is where cinema formats (21:9, 2.39:1...) start.
+const ultrawideRatio = 2.2
+
+// displayDimensions applies the sample aspect ratio and rotation of the video
+// stream to its coded dimensions.
+func displayDimensions(info database.MediaInfo) (width, height float64) {
+	width, height = float64(info.Width), float64(info.Height)
+	if num, den, ok := parseRatio(info.SampleAspectRatio); ok {
+		width = width * num / den
 	}
-	// 2. Validating the User to get the Owner of the Video
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
-		return
+	if info.Rotation == 90 || info.Rotation == 270 {
+		width, height = height, width
 	}
-	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
-		return
+	return width, height
+}
+
+// aspectPrefixes lists every prefix classifyAspectRatio can return.
+func aspectPrefixes() []string {
+	prefixes := []string{}
+	for _, bucket := range aspectBuckets {
+		prefixes = append(prefixes, bucket.prefix)
 	}
-	// 3. Getting the video details from the DB
-	video, err := cfg.db.GetVideo(videoId)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "could not extract video by ID", err)
-		return
+	return append(prefixes, "ultrawide", "other")
+}
+
+// classifyAspectRatio picks the storage prefix for a video from its display
+// dimensions, or "other" if it doesn't fit any bucket.
+func classifyAspectRatio(info database.MediaInfo) string {
+	width, height := displayDimensions(info)
+	if width <= 0 || height <= 0 {
+		return "other"
 	}
-	// 3.1 Checking if the video Owner in the DB is the same as the User obtained from JWT
-	if video.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "You are not the owner of this video", err)
-		return
+	ratio := width / height
+	for _, bucket := range aspectBuckets {
+		if math.Abs(ratio-bucket.ratio)/bucket.ratio <= aspectTolerance {
+			return bucket.prefix
+		}
 	}
-	// 4. Parse the Video File from the Uploaded form Data (from the UI)
-	file, handler, err := r.FormFile("video")
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
-		return
+	if ratio >= ultrawideRatio {
+		return "ultrawide"
 	}
-	defer file.Close()
-	// 5. Getting the Media Type of the Resource and Validating it for mp4 type.
-	mediaType, _, err := mime.ParseMediaType(handler.Header.Get("Content-Type"))
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "could not parse the media type", err)
-		return
+	return "other"
+}
+
+// parseRatio parses "num:den" ratios as ffprobe prints them; "0:1" and
+// "N/A" mean unknown.
+func parseRatio(ratio string) (num, den float64, ok bool) {
+	n, d, found := strings.Cut(ratio, ":")
+	if !found {
+		return 0, 0, false
 	}
-	if mediaType != "video/mp4" {
-		respondWithError(w, http.StatusBadRequest, "Invalid file type, only MP4 is allowed", nil)
-		return
+	num, err1 := strconv.ParseFloat(n, 64)
+	den, err2 := strconv.ParseFloat(d, 64)
+	if err1 != nil || err2 != nil || num <= 0 || den <= 0 {
+		return 0, 0, false
 	}
+	return num, den, true
+}
 
-	// 6. Save the Uploaded File to a Temporary File on Disk(locally)
-	os_file, err := os.CreateTemp("", "tubely-upload.mp4") // creating a temp file <tubely-upload.mp4> on current directory
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "could not create the temp file locally", err)
+func respondWithPipelineError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidUpload) {
+		respondWithMediaError(w, err)
 		return
 	}
-	defer os.Remove(os_file.Name())
-	defer os_file.Close() //defer is LIFO, so it will close before the remove
+	respondWithError(w, http.StatusInternalServerError, "Error processing video", err)
+}
 
-	// 6. copy the content to the temp file <os_file>
-	if _, err := io.Copy(os_file, file); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not write file to disk", err)
-		return
+// respondWithQueuedVideo queues processing of a received upload and answers
+// 202 with the job ID.
+func (cfg *apiConfig) respondWithQueuedVideo(w http.ResponseWriter, r *http.Request, video database.Video, source processVideoPayload) {
+	type response struct {
+		JobID uuid.UUID      `json:"job_id"`
+		Video database.Video `json:"video"`
 	}
 
-	_, err = os_file.Seek(0, io.SeekStart) // put the pointer back to front again
+	job, err := cfg.jobs.enqueueVideoProcessing(video, source)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not reset file pointer", err)
+		if source.SourcePath != "" {
+			os.Remove(source.SourcePath)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue video processing", err)
 		return
 	}
 
-	//6.1 Get the Video prefix("potrait", "landscape", "other")
-	video_prefix := ""
-	aspectRatio, err := getVideoAspectRatio(os_file.Name())
-
+	video, err = cfg.db.GetVideo(video.ID)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error determining aspect ratio", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
 		return
 	}
-	switch aspectRatio {
-	case "16:9":
-		video_prefix = "landscape"
-	case "9:16":
-		video_prefix = "portrait"
-	default:
-		video_prefix = "other"
+	video, err = cfg.resolveVideoURLs(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video URLs", err)
+		return
 	}
+	respondWithJSON(w, http.StatusAccepted, response{
+		JobID: job.ID,
+		Video: video,
+	})
+}
 
-	// 6.2 Process the video
-
-	// 7. put the <os_file> into S3 bucket..add the prefix to the path
+func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
+	const uploadLimit = 1 << 30 //1GB (setting an upload limit of 1GB)
+	r.Body = http.MaxBytesReader(w, r.Body, uploadLimit)
 
-	key := video_prefix + "/" + getAssetPath(mediaType)
-	processedFilePath, err := processVideoForFastStart(os_file.Name())
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error processing video", err)
+	// 1. Checking the caller owns the video named in the URL
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
 		return
 	}
-	defer os.Remove(processedFilePath)
-
-	processedFile, err := os.Open(processedFilePath)
+	// 2. Parse the Video File from the Uploaded form Data (from the UI)
+	file, handler, err := r.FormFile("video")
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Could not open processed file", err)
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
 		return
 	}
-	defer processedFile.Close()
-	_, err = cfg.s3Client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket:      aws.String(cfg.s3Bucket),
-		Key:         aws.String(key),
-		Body:        os_file,
-		ContentType: aws.String(mediaType),
-	})
+	defer file.Close()
+	// 3. Getting the declared Media Type of the Resource, if any; the content decides
+	var declaredType string
+	if contentType := handler.Header.Get("Content-Type"); contentType != "" {
+		declaredType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "could not parse the media type", err)
+			return
+		}
+	}
 
+	// 4. Save the Uploaded File to the staging directory
+	receivedPath, err := receiveVideo(cfg.stagingRoot, file)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Error uploading file to S3", err)
+		respondWithError(w, http.StatusInternalServerError, "Could not write file to disk", err)
 		return
 	}
 
-	// 8. store video_URL as <bucket_name>,<key>
-	video.VideoURL = aws.String((fmt.Sprintf("%s/%s", cfg.s3CfDistribution, key)))
-	fmt.Println(*video.VideoURL)
-	err = cfg.db.UpdateVideo(video)
+	// 4.1 Sniff the content and check it against the declared and accepted types
+	mediaType, err := checkVideoContent(r.Context(), receivedPath, declaredType, cfg.acceptedVideoTypes)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+		os.Remove(receivedPath)
+		respondWithPipelineError(w, err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, video)
+	// 5. Queue the probe/process/store stages; the job owns the staged file now
+	cfg.respondWithQueuedVideo(w, r, video, processVideoPayload{
+		SourcePath: receivedPath,
+		MediaType:  mediaType,
+	})
 }
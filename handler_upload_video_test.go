@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// videoUploadRequest posts data as the form's video file; nil data leaves the
+// file out.
+func videoUploadRequest(t *testing.T, videoID, contentType string, data []byte) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	if data != nil {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="video"; filename="upload"`)
+		if contentType != "" {
+			h.Set("Content-Type", contentType)
+		}
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(data)
+	}
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/video_upload/"+videoID, body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// testMP4 is a short video ffmpeg can probe; the test is skipped without it.
+func testMP4(t *testing.T) []byte {
+	t.Helper()
+	requireFFmpeg(t)
+	path := filepath.Join(t.TempDir(), "input.mp4")
+	cmd := exec.Command("ffmpeg", "-f", "lavfi", "-i", "testsrc=duration=1:size=320x180:rate=25",
+		"-c:v", "libx264", "-y", path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generating input: %v\n%s", err, out)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestHandlerUploadVideo(t *testing.T) {
+	// headers sniffing recognizes, rejected before anything probes them
+	mp4Header := []byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")
+	webmHeader := []byte("\x1a\x45\xdf\xa3\x9f\x42\x82\x84webm")
+
+	tests := []struct {
+		name   string
+		caller string
+		// videoID is the video to upload to; empty uses the owner's
+		videoID     string
+		data        func(t *testing.T) []byte
+		contentType string
+		wantStatus  int
+		wantCode    string
+	}{
+		{"mp4", "owner", "", testMP4, "video/mp4", http.StatusAccepted, ""},
+		{"no JWT", "", "", func(*testing.T) []byte { return mp4Header }, "video/mp4", http.StatusUnauthorized, ""},
+		{"bad JWT", "bad", "", func(*testing.T) []byte { return mp4Header }, "video/mp4", http.StatusUnauthorized, ""},
+		{"other user", "other", "", func(*testing.T) []byte { return mp4Header }, "video/mp4", http.StatusUnauthorized, ""},
+		{"invalid ID", "owner", "not-a-uuid", func(*testing.T) []byte { return mp4Header }, "video/mp4", http.StatusBadRequest, ""},
+		{"missing video", "owner", uuid.NewString(), func(*testing.T) []byte { return mp4Header }, "video/mp4", http.StatusNotFound, ""},
+		{"no file", "owner", "", func(*testing.T) []byte { return nil }, "", http.StatusBadRequest, ""},
+		{"not a video", "owner", "", func(*testing.T) []byte { return []byte("hello") }, "", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+		{"mp4 declared as webm", "owner", "", func(*testing.T) []byte { return mp4Header }, "video/webm", http.StatusUnsupportedMediaType, "media_type_mismatch"},
+		{"unaccepted container", "owner", "", func(*testing.T) []byte { return webmHeader }, "", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := tt.data(t)
+			cfg := newTestAPI(t)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, owner)
+			token := map[string]string{"owner": ownerToken, "other": otherToken, "bad": "not-a-jwt"}[tt.caller]
+			videoID := tt.videoID
+			if videoID == "" {
+				videoID = video.ID.String()
+			}
+
+			req := videoUploadRequest(t, videoID, tt.contentType, data)
+			rec := serve("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			staged, err := os.ReadDir(cfg.stagingRoot)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusAccepted {
+				if tt.wantCode != "" {
+					var resp struct{ Code string }
+					if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Code != tt.wantCode {
+						t.Errorf("error code = %q, %v; want %q", resp.Code, err, tt.wantCode)
+					}
+				}
+				if len(staged) != 0 {
+					t.Errorf("rejected upload left %d staged files", len(staged))
+				}
+				return
+			}
+
+			var resp struct {
+				JobID uuid.UUID `json:"job_id"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			job, err := cfg.db.GetJob(resp.JobID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var payload processVideoPayload
+			if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := os.ReadFile(payload.SourcePath); err != nil || !bytes.Equal(got, data) || payload.MediaType != "video/mp4" {
+				t.Errorf("job payload = %+v (%v); want the staged mp4", payload, err)
+			}
+		})
+	}
+}
+
+func TestParseMediaInfo(t *testing.T) {
+	const audio = `{"codec_type": "audio", "codec_name": "aac", "channels": 2, "sample_rate": "48000"}`
+	video := func(extra string) string {
+		return `{"codec_type": "video", "codec_name": "h264", "width": 1920, "height": 1080, "avg_frame_rate": "30000/1001"` + extra + `}`
+	}
+	tests := []struct {
+		name         string
+		streams      string
+		wantRotation int
+		wantErr      error
+	}{
+		{"video then audio", video("") + "," + audio, 0, nil},
+		{"audio before video", audio + "," + video(""), 0, nil},
+		{"rotate tag", video(`, "tags": {"rotate": "90"}`), 90, nil},
+		{"display matrix", video(`, "side_data_list": [{"rotation": -90}]`), 90, nil},
+		{"display matrix 270", video(`, "side_data_list": [{"rotation": 90}]`), 270, nil},
+		{"display matrix over tag", video(`, "tags": {"rotate": "180"}, "side_data_list": [{"rotation": -270}]`), 270, nil},
+		{"audio only", audio, 0, errNoVideoStream},
+		{"no dimensions", `{"codec_type": "video", "codec_name": "h264"}`, 0, errNoVideoStream},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := fmt.Sprintf(`{"format": {"format_name": "mov,mp4", "duration": "12.5"}, "streams": [%s]}`, tt.streams)
+			info, err := parseMediaInfo([]byte(data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseMediaInfo = %v; want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if info.VideoCodec != "h264" || info.Width != 1920 || info.Height != 1080 || info.DurationSeconds != 12.5 {
+				t.Errorf("info = %+v", info)
+			}
+			if info.Rotation != tt.wantRotation {
+				t.Errorf("Rotation = %d; want %d", info.Rotation, tt.wantRotation)
+			}
+		})
+	}
+}
+
+func TestClassifyAspectRatio(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		sar           string
+		rotation      int
+		want          string
+	}{
+		{"16:9", 1920, 1080, "1:1", 0, "landscape"},
+		{"1920x1088", 1920, 1088, "1:1", 0, "landscape"},
+		{"9:16", 1080, 1920, "1:1", 0, "portrait"},
+		{"rotated 90", 1920, 1080, "1:1", 90, "portrait"},
+		{"rotated 270", 1920, 1080, "1:1", 270, "portrait"},
+		{"rotated 180", 1920, 1080, "1:1", 180, "landscape"},
+		{"anamorphic HDV", 1440, 1080, "4:3", 0, "landscape"},
+		{"anamorphic PAL widescreen", 720, 576, "64:45", 0, "landscape"},
+		{"NTSC 4:3", 720, 480, "8:9", 0, "standard"},
+		{"anamorphic and rotated", 1440, 1080, "4:3", 90, "portrait"},
+		{"missing SAR", 1440, 1080, "", 0, "standard"},
+		{"zero SAR", 1440, 1080, "0:1", 0, "standard"},
+		{"N/A SAR", 1440, 1080, "N/A", 0, "standard"},
+		{"square", 1080, 1080, "1:1", 0, "square"},
+		{"4:3", 1440, 1080, "1:1", 0, "standard"},
+		{"3:4", 1080, 1440, "1:1", 0, "standard-portrait"},
+		{"21:9", 2560, 1080, "1:1", 0, "ultrawide"},
+		{"2.39:1", 1920, 803, "1:1", 0, "ultrawide"},
+		{"ultrawide threshold", 2200, 1000, "1:1", 0, "ultrawide"},
+		{"just below ultrawide", 2190, 1000, "1:1", 0, "other"},
+		// 16:9 is 1.7778; the tolerance is 3% either side
+		{"upper tolerance edge", 1831, 1000, "1:1", 0, "landscape"},
+		{"past the upper edge", 1832, 1000, "1:1", 0, "other"},
+		{"lower tolerance edge", 1725, 1000, "1:1", 0, "landscape"},
+		{"past the lower edge", 1724, 1000, "1:1", 0, "other"},
+		{"zero dimensions", 0, 0, "", 0, "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := database.MediaInfo{Width: tt.width, Height: tt.height, SampleAspectRatio: tt.sar, Rotation: tt.rotation}
+			if got := classifyAspectRatio(info); got != tt.want {
+				w, h := displayDimensions(info)
+				t.Errorf("classifyAspectRatio(%dx%d, SAR %q, rotation %d) = %q (display %.0fx%.0f); want %q",
+					tt.width, tt.height, tt.sar, tt.rotation, got, w, h, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisplayDimensions(t *testing.T) {
+	tests := []struct {
+		name                  string
+		info                  database.MediaInfo
+		wantWidth, wantHeight float64
+	}{
+		{"square pixels", database.MediaInfo{Width: 1920, Height: 1080, SampleAspectRatio: "1:1"}, 1920, 1080},
+		{"wide pixels", database.MediaInfo{Width: 1440, Height: 1080, SampleAspectRatio: "4:3"}, 1920, 1080},
+		{"narrow pixels", database.MediaInfo{Width: 720, Height: 480, SampleAspectRatio: "8:9"}, 640, 480},
+		{"rotated after SAR", database.MediaInfo{Width: 1440, Height: 1080, SampleAspectRatio: "4:3", Rotation: 270}, 1080, 1920},
+		{"unknown SAR", database.MediaInfo{Width: 1440, Height: 1080, SampleAspectRatio: "0:1"}, 1440, 1080},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, h := displayDimensions(tt.info)
+			if w != tt.wantWidth || h != tt.wantHeight {
+				t.Errorf("displayDimensions = %vx%v; want %vx%v", w, h, tt.wantWidth, tt.wantHeight)
+			}
+		})
+	}
+}
+
+func TestParseRatio(t *testing.T) {
+	tests := []struct {
+		ratio    string
+		num, den float64
+		ok       bool
+	}{
+		{"16:9", 16, 9, true},
+		{"64:45", 64, 45, true},
+		{"1:1", 1, 1, true},
+		{"0:1", 0, 0, false},
+		{"4:0", 0, 0, false},
+		{"N/A", 0, 0, false},
+		{"", 0, 0, false},
+		{"a:b", 0, 0, false},
+		{"16/9", 0, 0, false},
+	}
+	for _, tt := range tests {
+		num, den, ok := parseRatio(tt.ratio)
+		if num != tt.num || den != tt.den || ok != tt.ok {
+			t.Errorf("parseRatio(%q) = %v, %v, %v; want %v, %v, %v", tt.ratio, num, den, ok, tt.num, tt.den, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func postJSON(path, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// createLoginUser creates a user who can log in with password, as
+// handlerUsersCreate would.
+func createLoginUser(t *testing.T, cfg *apiConfig, email, password string) *database.User {
+	t.Helper()
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := cfg.db.CreateUser(database.CreateUserParams{Email: email, Password: hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// createRefreshToken stores a refresh token for user.
+func createRefreshToken(t *testing.T, cfg *apiConfig, user *database.User) string {
+	t.Helper()
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.db.CreateRefreshToken(database.CreateRefreshTokenParams{
+		UserID:    user.ID,
+		Token:     token,
+		ExpiresAt: time.Now().UTC().Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestHandlerUsersCreate(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"email and password", `{"email":"a@example.com","password":"hunter2"}`, http.StatusCreated},
+		{"no password", `{"email":"a@example.com"}`, http.StatusBadRequest},
+		{"no email", `{"password":"hunter2"}`, http.StatusBadRequest},
+		{"malformed body", `{"email":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			rec := serve("POST /api/users", cfg.handlerUsersCreate, postJSON("/api/users", tt.body), "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusCreated {
+				return
+			}
+			var created database.User
+			if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil || created.Email != "a@example.com" {
+				t.Fatalf("created user = %+v, %v", created, err)
+			}
+			stored, err := cfg.db.GetUserByEmail("a@example.com")
+			if err != nil || auth.CheckPasswordHash("hunter2", stored.Password) != nil {
+				t.Errorf("stored user = %+v, %v; want the password hashed", stored, err)
+			}
+		})
+	}
+}
+
+func TestHandlerLogin(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{"right password", `{"email":"a@example.com","password":"hunter2"}`, http.StatusOK},
+		{"wrong password", `{"email":"a@example.com","password":"wrong"}`, http.StatusUnauthorized},
+		{"unknown email", `{"email":"b@example.com","password":"hunter2"}`, http.StatusUnauthorized},
+		{"malformed body", `{"email":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			user := createLoginUser(t, cfg, "a@example.com", "hunter2")
+
+			rec := serve("POST /api/login", cfg.handlerLogin, postJSON("/api/login", tt.body), "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var login struct {
+				Email        string `json:"email"`
+				Token        string `json:"token"`
+				RefreshToken string `json:"refresh_token"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &login); err != nil || login.Email != "a@example.com" {
+				t.Fatalf("login response = %+v, %v", login, err)
+			}
+			if userID, err := auth.ValidateJWT(login.Token, cfg.jwtSecret); err != nil || userID != user.ID {
+				t.Errorf("access token is for %v, %v; want %v", userID, err, user.ID)
+			}
+			if got, err := cfg.db.GetUserByRefreshToken(login.RefreshToken); err != nil || got == nil || got.ID != user.ID {
+				t.Errorf("refresh token is for %+v, %v; want %v", got, err, user.ID)
+			}
+		})
+	}
+}
+
+func TestHandlerRefresh(t *testing.T) {
+	tests := []struct {
+		name string
+		// token picks the refresh token sent; empty sends none
+		token      func(stored string) string
+		wantStatus int
+	}{
+		{"stored token", func(stored string) string { return stored }, http.StatusOK},
+		{"no token", func(string) string { return "" }, http.StatusBadRequest},
+		{"unknown token", func(string) string { return "not-a-refresh-token" }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			user := createLoginUser(t, cfg, "a@example.com", "hunter2")
+			stored := createRefreshToken(t, cfg, user)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/refresh", nil)
+			rec := serve("POST /api/refresh", cfg.handlerRefresh, req, tt.token(stored))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Token string `json:"token"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if userID, err := auth.ValidateJWT(resp.Token, cfg.jwtSecret); err != nil || userID != user.ID {
+				t.Errorf("access token is for %v, %v; want %v", userID, err, user.ID)
+			}
+		})
+	}
+}
+
+func TestHandlerRevoke(t *testing.T) {
+	tests := []struct {
+		name       string
+		sendToken  bool
+		wantStatus int
+	}{
+		{"stored token", true, http.StatusNoContent},
+		{"no token", false, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			user := createLoginUser(t, cfg, "a@example.com", "hunter2")
+			stored := createRefreshToken(t, cfg, user)
+			token := ""
+			if tt.sendToken {
+				token = stored
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/revoke", nil)
+			rec := serve("POST /api/revoke", cfg.handlerRevoke, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			rt, err := cfg.db.GetRefreshToken(stored)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revoked := rt.RevokedAt != nil; revoked != tt.sendToken {
+				t.Errorf("refresh token revoked = %v; want %v", revoked, tt.sendToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const sseHeartbeatInterval = 15 * time.Second
+
+// statusEvent maps a stored processing status to the event a client would
+// have last seen.
+func statusEvent(video database.Video) *videoEvent {
+	var eventType string
+	switch video.ProcessingStatus {
+	case database.VideoStatusQueued:
+		eventType = videoEventUploaded
+	case database.VideoStatusProcessing:
+		eventType = videoEventProcessing
+	case database.VideoStatusReady:
+		eventType = videoEventStored
+	case database.VideoStatusFailed:
+		eventType = videoEventFailed
+	default:
+		return nil
+	}
+	return &videoEvent{Type: eventType, Time: video.UpdatedAt}
+}
+
+func writeSSE(w http.ResponseWriter, event videoEvent) error {
+	dat, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, dat)
+	return err
+}
+
+// handlerVideoEvents streams a video's processing events, starting with its
+// current state. The stream ends after a stored or final failed event;
+// progress events may be collapsed for slow clients, others are all sent.
+func (cfg *apiConfig) handlerVideoEvents(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Streaming isn't supported", nil)
+		return
+	}
+
+	// subscribe before sending the current state so nothing falls in between
+	sub, last, unsubscribe := cfg.events.subscribe(video.ID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	current := last
+	if current == nil {
+		current = statusEvent(video)
+	}
+	if current != nil {
+		if err := writeSSE(w, *current); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+	if current != nil && current.terminal() {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.ready():
+			for _, event := range sub.take() {
+				if err := writeSSE(w, event); err != nil {
+					return
+				}
+				if event.terminal() {
+					flusher.Flush()
+					return
+				}
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestHandlerVideoEvents(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller string
+		// videoID is the video to follow; empty follows the owner's
+		videoID string
+		status  string
+		// inFlight is published before the client connects
+		inFlight   *videoEvent
+		wantStatus int
+		// wantEvents are the event lines the stream sends before it ends or
+		// the client goes away
+		wantEvents []string
+	}{
+		{"stored video", "owner", "", database.VideoStatusReady, nil, http.StatusOK, []string{"event: stored"}},
+		{"failed video", "owner", "", database.VideoStatusFailed, nil, http.StatusOK, []string{"event: failed"}},
+		{"queued video", "owner", "", database.VideoStatusQueued, nil, http.StatusOK, []string{"event: uploaded"}},
+		{"in-flight progress", "owner", "", database.VideoStatusProcessing, &videoEvent{Type: videoEventProcessing, Stage: progressStageHLS}, http.StatusOK, []string{"event: processing"}},
+		{"nothing uploaded", "owner", "", "", nil, http.StatusOK, nil},
+		{"no JWT", "", "", database.VideoStatusReady, nil, http.StatusUnauthorized, nil},
+		{"bad JWT", "bad", "", database.VideoStatusReady, nil, http.StatusUnauthorized, nil},
+		{"other user", "other", "", database.VideoStatusReady, nil, http.StatusUnauthorized, nil},
+		{"invalid ID", "owner", "not-a-uuid", "", nil, http.StatusBadRequest, nil},
+		{"missing video", "owner", uuid.NewString(), "", nil, http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, owner)
+			if err := cfg.db.UpdateVideoProcessingStatus(video.ID, tt.status); err != nil {
+				t.Fatal(err)
+			}
+			if tt.inFlight != nil {
+				cfg.events.publish(video.ID, *tt.inFlight)
+			}
+			token := map[string]string{"owner": ownerToken, "other": otherToken, "bad": "not-a-jwt"}[tt.caller]
+			videoID := tt.videoID
+			if videoID == "" {
+				videoID = video.ID.String()
+			}
+
+			// a stream that doesn't end by itself stops once the client is gone
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/"+videoID+"/events", nil).WithContext(ctx)
+			rec := serve("GET /api/videos/{videoID}/events", cfg.handlerVideoEvents, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Content-Type = %q; want text/event-stream", ct)
+			}
+			var events []string
+			for _, line := range strings.Split(rec.Body.String(), "\n") {
+				if strings.HasPrefix(line, "event: ") {
+					events = append(events, line)
+				}
+			}
+			if strings.Join(events, ",") != strings.Join(tt.wantEvents, ",") {
+				t.Errorf("events = %q; want %q", events, tt.wantEvents)
+			}
+		})
+	}
+}
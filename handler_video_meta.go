@@ -29,7 +29,7 @@ func (cfg *apiConfig) handlerVideoMetaCreate(w http.ResponseWriter, r *http.Requ
 	params := parameters{}
 	err = decoder.Decode(&params)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't decode parameters", err)
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
 		return
 	}
 	params.UserID = userID
@@ -63,7 +63,7 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 	}
 
 	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
+	if err != nil || video.ID == uuid.Nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
@@ -72,7 +72,14 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	blobs, err := cfg.videoAssetBlobs(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't list video assets", err)
+		return
+	}
+
+	// the deletion retrier removes the blobs once the video is gone
+	err = cfg.db.DeleteVideoQueueingBlobs(videoID, blobs)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
@@ -81,6 +88,8 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// handlerVideoGet is public, but in signed-cdn mode only the video's owner is
+// handed signed URLs and cookies; everyone else gets unsigned URLs.
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -90,14 +99,53 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	video, err := cfg.db.GetVideo(videoID)
-	if err != nil {
+	if err != nil || video.ID == uuid.Nil {
 		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
 		return
 	}
 
+	ctx := r.Context()
+	signed := cfg.cdnSigning != nil && cfg.isVideoOwner(r, video)
+	if cfg.cdnSigning != nil && !signed {
+		ctx = withoutSignatures(ctx)
+	}
+
+	video, err = cfg.resolveVideoURLs(ctx, video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video URLs", err)
+		return
+	}
+	video.MediaInfo, err = cfg.db.GetMediaInfo(video.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get media info", err)
+		return
+	}
+
+	if signed && video.VideoKey != nil {
+		err = cfg.cdnSigning.setVideoCookies(r.Context(), w, *video.VideoKey)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign CDN cookies", err)
+			return
+		}
+	}
+
 	respondWithJSON(w, http.StatusOK, video)
 }
 
+// isVideoOwner reports whether the request carries a valid JWT of the video's
+// owner. Unlike authorizeVideoOwner it doesn't reject anyone.
+func (cfg *apiConfig) isVideoOwner(r *http.Request, video database.Video) bool {
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	return err == nil && userID == video.UserID
+}
+
+// handlerVideosRetrieve lists the caller's videos as a JSON array. Paging is
+// opt-in: with a limit or cursor it returns one page, and the cursor of the
+// next one, if any, in the X-Next-Cursor header.
 func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Request) {
 	token, err := auth.GetBearerToken(r.Header)
 	if err != nil {
@@ -110,11 +158,32 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	query := r.URL.Query()
+	params, err := parseListVideosParams(userID, query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	var page database.VideoPage
+	if query.Has("limit") || query.Has("cursor") {
+		page, err = cfg.db.ListVideos(params)
+	} else {
+		page, err = cfg.listAllVideos(params)
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
+	videos, err := cfg.resolveVideosURLs(r.Context(), page.Videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve video URLs", err)
+		return
+	}
+
+	if page.Next != nil {
+		w.Header().Set("X-Next-Cursor", encodeVideoCursor(params.Sort, params.Ascending, *page.Next))
+	}
 	respondWithJSON(w, http.StatusOK, videos)
 }
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cfsign"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestHandlerVideoMetaCreate(t *testing.T) {
+	tests := []struct {
+		name       string
+		caller     string
+		body       string
+		wantStatus int
+	}{
+		{"owner", "owner", `{"title":"boots","description":"a bear"}`, http.StatusCreated},
+		{"no JWT", "", `{"title":"boots"}`, http.StatusUnauthorized},
+		{"bad JWT", "bad", `{"title":"boots"}`, http.StatusUnauthorized},
+		{"malformed body", "owner", `{"title":`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			token := map[string]string{"owner": ownerToken, "bad": "not-a-jwt"}[tt.caller]
+
+			rec := serve("POST /api/videos", cfg.handlerVideoMetaCreate, postJSON("/api/videos", tt.body), token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			page, err := cfg.db.ListVideos(database.ListVideosParams{UserID: owner.ID, Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusCreated {
+				if len(page.Videos) != 0 {
+					t.Errorf("rejected request created %d videos", len(page.Videos))
+				}
+				return
+			}
+			var created database.Video
+			if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+				t.Fatal(err)
+			}
+			if created.Title != "boots" || created.Description != "a bear" || created.UserID != owner.ID {
+				t.Errorf("created video = %+v", created)
+			}
+			if len(page.Videos) != 1 || page.Videos[0].ID != created.ID {
+				t.Errorf("stored videos = %+v; want the created one", page.Videos)
+			}
+		})
+	}
+}
+
+func TestHandlerVideoGet(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		signed     bool
+		caller     string
+		videoID    string
+		wantStatus int
+		// wantSignatures is whether the video URL is signed and cookies set
+		wantSignatures bool
+	}{
+		{"public, owner", false, "owner", "", http.StatusOK, false},
+		{"public, other user", false, "other", "", http.StatusOK, false},
+		{"public, anonymous", false, "", "", http.StatusOK, false},
+		{"signed-cdn, owner", true, "owner", "", http.StatusOK, true},
+		{"signed-cdn, other user", true, "other", "", http.StatusOK, false},
+		{"signed-cdn, anonymous", true, "", "", http.StatusOK, false},
+		{"signed-cdn, bad JWT", true, "bad", "", http.StatusOK, false},
+		{"invalid ID", false, "owner", "not-a-uuid", http.StatusBadRequest, false},
+		{"missing video", false, "owner", uuid.NewString(), http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			if tt.signed {
+				cfg.cdnSigning = &cdnSigning{
+					signer:  cfsign.NewSigner("KTEST", key),
+					baseURL: "https://d111.cloudfront.net",
+					expiry:  time.Hour,
+				}
+				cfg.videoURLResolver = cfg.cdnSigning
+			}
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, owner)
+			video.VideoKey = aws.String("landscape/a.mp4")
+			if err := cfg.db.UpdateVideo(video); err != nil {
+				t.Fatal(err)
+			}
+			token := map[string]string{"owner": ownerToken, "other": otherToken, "bad": "not-a-jwt"}[tt.caller]
+			videoID := tt.videoID
+			if videoID == "" {
+				videoID = video.ID.String()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/"+videoID, nil)
+			rec := serve("GET /api/videos/{videoID}", cfg.handlerVideoGet, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			var got database.Video
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != video.ID || got.VideoURL == nil {
+				t.Fatalf("video = %+v", got)
+			}
+			if signedURL := strings.Contains(*got.VideoURL, "Signature="); signedURL != tt.wantSignatures {
+				t.Errorf("video URL %q signed = %v; want %v", *got.VideoURL, signedURL, tt.wantSignatures)
+			}
+			if cookies := len(rec.Result().Cookies()) > 0; cookies != tt.wantSignatures {
+				t.Errorf("cookies set = %v; want %v", cookies, tt.wantSignatures)
+			}
+		})
+	}
+}
+
+func TestHandlerVideosRetrievePages(t *testing.T) {
+	cfg := newTestAPI(t)
+	owner, token := createTestUser(t, cfg, "owner@example.com")
+	other, _ := createTestUser(t, cfg, "other@example.com")
+	for range 3 {
+		createTestVideo(t, cfg, owner)
+	}
+	createTestVideo(t, cfg, other)
+
+	list := func(query url.Values) ([]database.Video, string) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/api/videos?"+query.Encode(), nil)
+		rec := serve("GET /api/videos", cfg.handlerVideosRetrieve, req, token)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list %v: status = %d: %s", query, rec.Code, rec.Body)
+		}
+		var videos []database.Video
+		if err := json.Unmarshal(rec.Body.Bytes(), &videos); err != nil {
+			t.Fatalf("list %v: body isn't a JSON array: %v", query, err)
+		}
+		return videos, rec.Header().Get("X-Next-Cursor")
+	}
+
+	// without a limit or cursor, every video comes back as before paging
+	all, next := list(url.Values{})
+	if len(all) != 3 || next != "" {
+		t.Fatalf("unpaged list = %d videos, cursor %q; want 3 and none", len(all), next)
+	}
+
+	first, next := list(url.Values{"limit": {"2"}})
+	if len(first) != 2 || next == "" {
+		t.Fatalf("first page = %d videos, cursor %q", len(first), next)
+	}
+	second, next := list(url.Values{"limit": {"2"}, "cursor": {next}})
+	if len(second) != 1 || next != "" {
+		t.Fatalf("second page = %d videos, cursor %q", len(second), next)
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, v := range append(first, second...) {
+		if v.UserID != owner.ID || seen[v.ID] {
+			t.Errorf("listed video %s of user %s twice or not the owner's", v.ID, v.UserID)
+		}
+		seen[v.ID] = true
+	}
+
+	// more than a page's worth is still listed in full
+	for range maxVideoPageSize {
+		createTestVideo(t, cfg, owner)
+	}
+	if all, next := list(url.Values{}); len(all) != maxVideoPageSize+3 || next != "" {
+		t.Errorf("unpaged list = %d videos, cursor %q; want %d and none", len(all), next, maxVideoPageSize+3)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/videos?sort=views", nil)
+	if rec := serve("GET /api/videos", cfg.handlerVideosRetrieve, req, token); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown sort: status = %d; want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandlerVideoMetaDelete(t *testing.T) {
+	tests := []struct {
+		name   string
+		caller string
+		// videoID is the video to delete; empty deletes the owner's
+		videoID    string
+		wantStatus int
+	}{
+		{"owner", "owner", "", http.StatusNoContent},
+		{"no JWT", "", "", http.StatusUnauthorized},
+		{"bad JWT", "bad", "", http.StatusUnauthorized},
+		{"other user", "other", "", http.StatusForbidden},
+		{"invalid ID", "owner", "not-a-uuid", http.StatusBadRequest},
+		{"missing video", "owner", uuid.NewString(), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, owner)
+			video.VideoKey = aws.String("landscape/a.mp4")
+			if err := cfg.db.UpdateVideo(video); err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.videoStore.Put(context.Background(), "landscape/a.mp4", bytes.NewReader(nil), "video/mp4"); err != nil {
+				t.Fatal(err)
+			}
+			token := map[string]string{"owner": ownerToken, "other": otherToken, "bad": "not-a-jwt"}[tt.caller]
+			videoID := tt.videoID
+			if videoID == "" {
+				videoID = video.ID.String()
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/api/videos/"+videoID, nil)
+			rec := serve("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+
+			deleted := rec.Code == http.StatusNoContent
+			if got, _ := cfg.db.GetVideo(video.ID); (got.ID == uuid.Nil) != deleted {
+				t.Errorf("video deleted = %v; want %v", got.ID == uuid.Nil, deleted)
+			}
+			want := []string{}
+			if deleted {
+				want = []string{"landscape/a.mp4"}
+			}
+			if got := pendingDeletionKeys(t, cfg.db); !equalStrings(got, want) {
+				t.Errorf("pending deletions = %v; want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// BlobStore is the storage backend for uploaded assets. Keys are
+// slash-separated paths relative to the root of the store.
+type BlobStore interface {
+	Put(ctx context.Context, key string, body io.Reader, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	URL(key string) string
+}
+
+// Presigner is implemented by stores that can hand out time-limited URLs to
+// private objects.
+type Presigner interface {
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// PresignedUpload describes a request a client can make to upload an object
+// straight to the store. For POST uploads Fields must be sent as form fields
+// before the file.
+type PresignedUpload struct {
+	Method string            `json:"method"`
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// UploadPresigner is implemented by stores that accept direct uploads from
+// clients, bypassing the API server.
+type UploadPresigner interface {
+	PresignUpload(ctx context.Context, key, contentType string, maxSize int64, expiry time.Duration) (PresignedUpload, error)
+}
+
+func joinURL(baseURL, key string) string {
+	if baseURL == "" {
+		return "/" + key
+	}
+	if baseURL[len(baseURL)-1] == '/' {
+		return baseURL + key
+	}
+	return baseURL + "/" + key
+}
@@ -0,0 +1,119 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+// Memory keeps objects in process memory. It is meant for tests and for
+// running the API locally without a bucket; it also serves its own objects
+// over HTTP so the URLs it hands out resolve.
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	baseURL string
+}
+
+func NewMemory(baseURL string) *Memory {
+	return &Memory{
+		objects: map[string]memoryObject{},
+		baseURL: baseURL,
+	}
+}
+
+func (m *Memory) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memoryObject{
+		data:        data,
+		contentType: contentType,
+		modified:    time.Now().UTC(),
+	}
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *Memory) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	obj, ok := m.objects[key]
+	if !ok {
+		return ObjectInfo{}, ErrNotFound
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         int64(len(obj.data)),
+		ContentType:  obj.contentType,
+		LastModified: obj.modified,
+	}, nil
+}
+
+func (m *Memory) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	objects := []ObjectInfo{}
+	for key, obj := range m.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         int64(len(obj.data)),
+			ContentType:  obj.contentType,
+			LastModified: obj.modified,
+		})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (m *Memory) URL(key string) string {
+	return joinURL(m.baseURL, key)
+}
+
+func (m *Memory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	m.mu.RLock()
+	obj, ok := m.objects[key]
+	m.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if obj.contentType != "" {
+		w.Header().Set("Content-Type", obj.contentType)
+	}
+	http.ServeContent(w, r, key, obj.modified, bytes.NewReader(obj.data))
+}
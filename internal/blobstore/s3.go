@@ -0,0 +1,163 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type S3 struct {
+	client    *s3.Client
+	presigner *s3.PresignClient
+	bucket    string
+	region    string
+	baseURL   string
+	multipart MultipartConfig
+}
+
+// NewS3 returns a store backed by an S3 bucket. URLs are built from baseURL
+// (e.g. a CloudFront distribution); if it is empty, the bucket's public
+// virtual-hosted URL is used instead.
+func NewS3(client *s3.Client, bucket, region, baseURL string, multipart MultipartConfig) *S3 {
+	return &S3{
+		client:    client,
+		presigner: s3.NewPresignClient(client),
+		bucket:    bucket,
+		region:    region,
+		baseURL:   baseURL,
+		multipart: multipart,
+	}
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, contentType string) error {
+	if readerAt, base, size, ok := multipartBody(body); ok && s.multipart.Threshold > 0 && size >= s.multipart.Threshold {
+		return s.putMultipart(ctx, key, readerAt, base, size, contentType)
+	}
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isS3NotFound(err) {
+			return ObjectInfo{}, ErrNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := []ObjectInfo{}
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (s *S3) URL(key string) string {
+	if s.baseURL == "" {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, key)
+	}
+	return joinURL(s.baseURL, key)
+}
+
+func (s *S3) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	req, err := s.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		return "", err
+	}
+	return req.URL, nil
+}
+
+// PresignUpload returns a presigned POST policy restricting the upload to key,
+// contentType and at most maxSize bytes.
+func (s *S3) PresignUpload(ctx context.Context, key, contentType string, maxSize int64, expiry time.Duration) (PresignedUpload, error) {
+	req, err := s.presigner.PresignPostObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, func(o *s3.PresignPostOptions) {
+		o.Expires = expiry
+		o.Conditions = []interface{}{
+			[]interface{}{"content-length-range", 1, maxSize},
+			map[string]string{"Content-Type": contentType},
+		}
+	})
+	if err != nil {
+		return PresignedUpload{}, err
+	}
+	fields := map[string]string{"Content-Type": contentType}
+	for k, v := range req.Values {
+		fields[k] = v
+	}
+	return PresignedUpload{
+		Method: "POST",
+		URL:    req.URL,
+		Fields: fields,
+	}, nil
+}
+
+func isS3NotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	return errors.As(err, &noSuchKey) || errors.As(err, &notFound)
+}
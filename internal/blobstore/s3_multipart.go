@@ -0,0 +1,217 @@
+package blobstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	minPartSize = 5 << 20 // S3 rejects smaller parts (except the last one)
+	maxParts    = 10000
+)
+
+// MultipartConfig controls when and how S3 puts are split into a multipart
+// upload. Bodies of at least Threshold bytes that support io.ReaderAt are
+// uploaded in PartSize chunks, Concurrency at a time, each retried up to
+// MaxRetries times.
+type MultipartConfig struct {
+	Threshold   int64
+	PartSize    int64
+	Concurrency int
+	MaxRetries  int
+}
+
+var DefaultMultipartConfig = MultipartConfig{
+	Threshold:   100 << 20,
+	PartSize:    16 << 20,
+	Concurrency: 4,
+	MaxRetries:  3,
+}
+
+// multipartBody reports whether body can be uploaded in parallel parts and
+// returns its remaining size and the offset it is currently at.
+func multipartBody(body io.Reader) (io.ReaderAt, int64, int64, bool) {
+	readerAt, ok := body.(io.ReaderAt)
+	if !ok {
+		return nil, 0, 0, false
+	}
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return nil, 0, 0, false
+	}
+	base, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, 0, false
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, 0, false
+	}
+	if _, err := seeker.Seek(base, io.SeekStart); err != nil {
+		return nil, 0, 0, false
+	}
+	return readerAt, base, end - base, true
+}
+
+// partLayout splits size bytes into parts of about partSize, raised to what
+// S3 accepts: at least minPartSize each and at most maxParts of them.
+func partLayout(size, partSize int64) (int64, int) {
+	partSize = max(partSize, minPartSize)
+	if size/partSize >= maxParts {
+		partSize = size/maxParts + 1
+	}
+	return partSize, int((size + partSize - 1) / partSize)
+}
+
+func (s *S3) putMultipart(ctx context.Context, key string, body io.ReaderAt, base, size int64, contentType string) error {
+	partSize, numParts := partLayout(size, s.multipart.PartSize)
+
+	created, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("couldn't create multipart upload: %w", err)
+	}
+	uploadID := created.UploadId
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	parts := make([]types.CompletedPart, numParts)
+	partNumbers := make(chan int)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var uploadErr error
+
+	for i := 0; i < max(s.multipart.Concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range partNumbers {
+				offset := int64(n) * partSize
+				length := min(partSize, size-offset)
+				etag, err := s.uploadPart(ctx, key, uploadID, int32(n+1), io.NewSectionReader(body, base+offset, length))
+				if err != nil {
+					errOnce.Do(func() {
+						uploadErr = fmt.Errorf("couldn't upload part %d: %w", n+1, err)
+						cancel()
+					})
+					continue
+				}
+				parts[n] = types.CompletedPart{
+					ETag:       etag,
+					PartNumber: aws.Int32(int32(n + 1)),
+				}
+			}
+		}()
+	}
+
+feed:
+	for n := 0; n < numParts; n++ {
+		select {
+		case partNumbers <- n:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(partNumbers)
+	wg.Wait()
+
+	if uploadErr == nil {
+		uploadErr = ctx.Err()
+	}
+	if uploadErr == nil {
+		_, uploadErr = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(s.bucket),
+			Key:             aws.String(key),
+			UploadId:        uploadID,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if uploadErr != nil {
+		s.abortMultipart(key, uploadID)
+		return uploadErr
+	}
+	return nil
+}
+
+func (s *S3) uploadPart(ctx context.Context, key string, uploadID *string, partNumber int32, part *io.SectionReader) (*string, error) {
+	var err error
+	for attempt := 0; attempt <= s.multipart.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(1<<(attempt-1)) * 500 * time.Millisecond):
+			}
+		}
+		if _, err = part.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+
+		var out *s3.UploadPartOutput
+		out, err = s.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(s.bucket),
+			Key:           aws.String(key),
+			UploadId:      uploadID,
+			PartNumber:    aws.Int32(partNumber),
+			Body:          part,
+			ContentLength: aws.Int64(part.Size()),
+		})
+		if err == nil {
+			return out.ETag, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func (s *S3) abortMultipart(key string, uploadID *string) {
+	// the request context may already be cancelled, cleanup must still happen
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+	if err != nil {
+		log.Printf("couldn't abort multipart upload of %q: %v", key, err)
+	}
+}
+
+// AbortStaleMultipartUploads aborts incomplete multipart uploads started more
+// than olderThan ago, e.g. by a process that crashed mid-upload.
+func (s *S3) AbortStaleMultipartUploads(ctx context.Context, olderThan time.Duration) error {
+	cutoff := time.Now().Add(-olderThan)
+	input := &s3.ListMultipartUploadsInput{Bucket: aws.String(s.bucket)}
+	for {
+		out, err := s.client.ListMultipartUploads(ctx, input)
+		if err != nil {
+			return err
+		}
+		for _, upload := range out.Uploads {
+			if aws.ToTime(upload.Initiated).After(cutoff) {
+				continue
+			}
+			s.abortMultipart(aws.ToString(upload.Key), upload.UploadId)
+		}
+		if !aws.ToBool(out.IsTruncated) {
+			return nil
+		}
+		input.KeyMarker = out.NextKeyMarker
+		input.UploadIdMarker = out.NextUploadIdMarker
+	}
+}
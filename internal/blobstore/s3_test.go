@@ -0,0 +1,590 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// fakeS3 is a minimal path-style S3 endpoint holding a single private bucket.
+// Requests must carry a signature, in a header or a presigned query, but it
+// isn't verified.
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	uploads map[string]*fakeMultipartUpload
+	nextID  int
+	// partFailures is how many more times each part number's upload fails
+	partFailures map[int]int
+	partAttempts map[int]int
+	aborted      []string
+}
+
+type fakeMultipartUpload struct {
+	key         string
+	contentType string
+	parts       map[int][]byte
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+	modified    time.Time
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{
+		bucket:       bucket,
+		objects:      map[string]fakeObject{},
+		uploads:      map[string]*fakeMultipartUpload{},
+		partFailures: map[int]int{},
+		partAttempts: map[int]int{},
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != f.bucket {
+		s3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if r.Method == http.MethodPost && key == "" {
+		f.postObject(w, r)
+		return
+	}
+	if r.Header.Get("Authorization") == "" && r.URL.Query().Get("X-Amz-Signature") == "" {
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.createMultipartUpload(w, r, key)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		f.uploadPart(w, r)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.completeMultipartUpload(w, r, key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(f.uploads, query.Get("uploadId"))
+		f.aborted = append(f.aborted, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && key == "":
+		f.listObjects(w, r.URL.Query().Get("prefix"))
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			s3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = fakeObject{data, r.Header.Get("Content-Type"), time.Now().UTC()}
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		obj, ok := f.objects[key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", fmt.Sprint(len(obj.data)))
+		w.Header().Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) createMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	f.nextID++
+	id := fmt.Sprintf("upload-%d", f.nextID)
+	f.uploads[id] = &fakeMultipartUpload{key: key, contentType: r.Header.Get("Content-Type"), parts: map[int][]byte{}}
+	result := struct {
+		XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+		Bucket   string
+		Key      string
+		UploadId string
+	}{Bucket: f.bucket, Key: key, UploadId: id}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) uploadPart(w http.ResponseWriter, r *http.Request) {
+	upload, ok := f.uploads[r.URL.Query().Get("uploadId")]
+	if !ok {
+		s3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || n < 1 || n > maxParts {
+		s3Error(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		s3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	f.partAttempts[n]++
+	if f.partFailures[n] > 0 {
+		f.partFailures[n]--
+		s3Error(w, http.StatusInternalServerError, "InternalError")
+		return
+	}
+	upload.parts[n] = data
+	w.Header().Set("ETag", fakeETag(n, data))
+}
+
+func fakeETag(partNumber int, data []byte) string {
+	return fmt.Sprintf(`"%d-%d"`, partNumber, len(data))
+}
+
+// completeMultipartUpload assembles the listed parts, enforcing S3's rules:
+// parts in ascending order, matching ETags and all but the last at least
+// minPartSize.
+func (f *fakeS3) completeMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	id := r.URL.Query().Get("uploadId")
+	upload, ok := f.uploads[id]
+	if !ok || upload.key != key {
+		s3Error(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	var req struct {
+		Parts []struct {
+			ETag       string
+			PartNumber int
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Parts) == 0 {
+		s3Error(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	var data []byte
+	for i, part := range req.Parts {
+		body, ok := upload.parts[part.PartNumber]
+		if !ok || part.ETag != fakeETag(part.PartNumber, body) {
+			s3Error(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+			s3Error(w, http.StatusBadRequest, "InvalidPartOrder")
+			return
+		}
+		if i < len(req.Parts)-1 && len(body) < minPartSize {
+			s3Error(w, http.StatusBadRequest, "EntityTooSmall")
+			return
+		}
+		data = append(data, body...)
+	}
+	delete(f.uploads, id)
+	f.objects[key] = fakeObject{data, upload.contentType, time.Now().UTC()}
+
+	result := struct {
+		XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+		Bucket  string
+		Key     string
+		ETag    string
+	}{Bucket: f.bucket, Key: key, ETag: `"complete"`}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// postObject handles browser-style form uploads made with a presigned POST
+// policy.
+func (f *fakeS3) postObject(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(10 << 20); err != nil {
+		s3Error(w, http.StatusBadRequest, "MalformedPOSTRequest")
+		return
+	}
+	if formValueFold(r, "policy") == "" || formValueFold(r, "x-amz-signature") == "" {
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		s3Error(w, http.StatusBadRequest, "MalformedPOSTRequest")
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		s3Error(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[formValueFold(r, "key")] = fakeObject{data, formValueFold(r, "content-type"), time.Now().UTC()}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// formValueFold looks a form field up case-insensitively, as S3 does.
+func formValueFold(r *http.Request, name string) string {
+	for field, values := range r.MultipartForm.Value {
+		if strings.EqualFold(field, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
+
+func (f *fakeS3) listObjects(w http.ResponseWriter, prefix string) {
+	type content struct {
+		Key          string
+		LastModified string
+		Size         int
+	}
+	result := struct {
+		XMLName     xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []content
+	}{Name: f.bucket, Prefix: prefix, MaxKeys: 1000}
+	for key, obj := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, content{key, obj.modified.Format("2006-01-02T15:04:05.000Z"), len(obj.data)})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool {
+		return result.Contents[i].Key < result.Contents[j].Key
+	})
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func s3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// newTestS3 returns a store on the fake endpoint, or on the S3-compatible
+// service at TEST_S3_ENDPOINT (e.g. MinIO) when it is set. That bucket,
+// TEST_S3_BUCKET, must exist and be private; credentials come from
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func newTestS3(t *testing.T) (*S3, string) {
+	t.Helper()
+	endpoint, bucket := os.Getenv("TEST_S3_ENDPOINT"), os.Getenv("TEST_S3_BUCKET")
+	creds := aws.Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+	}
+	if endpoint == "" {
+		bucket = "tubely-test"
+		server := httptest.NewServer(newFakeS3(bucket))
+		t.Cleanup(server.Close)
+		endpoint = server.URL
+		creds = aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}
+	}
+
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(endpoint),
+		UsePathStyle: true,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return creds, nil
+		}),
+		// the fake doesn't decode aws-chunked bodies
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+	})
+	// a unique prefix keeps runs against a shared bucket apart
+	prefix := fmt.Sprintf("test-%d/", time.Now().UnixNano())
+	return NewS3(client, bucket, "us-east-1", endpoint+"/"+bucket, DefaultMultipartConfig), prefix
+}
+
+func TestS3(t *testing.T) {
+	ctx := context.Background()
+	store, prefix := newTestS3(t)
+	key := prefix + "landscape/a.mp4"
+	body := []byte("not really a video")
+
+	if err := store.Put(ctx, key, bytes.NewReader(body), "video/mp4"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	t.Cleanup(func() {
+		objects, _ := store.List(ctx, prefix)
+		for _, obj := range objects {
+			store.Delete(ctx, obj.Key)
+		}
+	})
+
+	rc, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(got, body) {
+		t.Errorf("Get = %q, %v; want %q", got, err, body)
+	}
+
+	info, err := store.Stat(ctx, key)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Key != key || info.Size != int64(len(body)) || info.ContentType != "video/mp4" || info.LastModified.IsZero() {
+		t.Errorf("Stat = %+v", info)
+	}
+
+	if err := store.Put(ctx, prefix+"landscape/a/hls/master.m3u8", strings.NewReader("#EXTM3U"), "application/vnd.apple.mpegurl"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put(ctx, prefix+"portrait/b.mp4", strings.NewReader("b"), "video/mp4"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	objects, err := store.List(ctx, prefix+"landscape/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	keys := []string{}
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	sort.Strings(keys)
+	if want := []string{prefix + "landscape/a.mp4", prefix + "landscape/a/hls/master.m3u8"}; strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("List = %v; want %v", keys, want)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v; want ErrNotFound", err)
+	}
+	if _, err := store.Stat(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat after Delete = %v; want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Errorf("Delete of a missing key = %v; want nil", err)
+	}
+}
+
+func TestS3PresignGet(t *testing.T) {
+	ctx := context.Background()
+	store, prefix := newTestS3(t)
+	key := prefix + "private.mp4"
+	if err := store.Put(ctx, key, strings.NewReader("secret"), "video/mp4"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	t.Cleanup(func() { store.Delete(ctx, key) })
+
+	resp, err := http.Get(store.URL(key))
+	if err != nil {
+		t.Fatalf("GET unsigned URL: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unsigned GET = %d; want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	signed, err := store.PresignGet(ctx, key, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("PresignGet: %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("PresignGet returned %q: %v", signed, err)
+	}
+	if q := u.Query(); q.Get("X-Amz-Expires") != "300" || q.Get("X-Amz-Signature") == "" {
+		t.Errorf("presigned query = %v; want a signature expiring in 300s", q)
+	}
+
+	resp, err = http.Get(signed)
+	if err != nil {
+		t.Fatalf("GET presigned URL: %v", err)
+	}
+	defer resp.Body.Close()
+	got, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(got) != "secret" {
+		t.Errorf("presigned GET = %d %q; want 200 %q", resp.StatusCode, got, "secret")
+	}
+}
+
+func TestS3PresignUpload(t *testing.T) {
+	ctx := context.Background()
+	store, prefix := newTestS3(t)
+	key := prefix + "direct.mp4"
+
+	upload, err := store.PresignUpload(ctx, key, "video/mp4", 1<<20, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("PresignUpload: %v", err)
+	}
+	if upload.Method != http.MethodPost || upload.Fields["key"] != key || upload.Fields["Content-Type"] != "video/mp4" || upload.Fields["policy"] == "" {
+		t.Fatalf("PresignUpload = %+v", upload)
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	for name, value := range upload.Fields {
+		mw.WriteField(name, value)
+	}
+	part, _ := mw.CreateFormFile("file", "direct.mp4")
+	part.Write([]byte("uploaded"))
+	mw.Close()
+
+	resp, err := http.Post(upload.URL, mw.FormDataContentType(), &form)
+	if err != nil {
+		t.Fatalf("POST upload: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		t.Fatalf("POST upload = %d", resp.StatusCode)
+	}
+	t.Cleanup(func() { store.Delete(ctx, key) })
+
+	info, err := store.Stat(ctx, key)
+	if err != nil || info.Size != int64(len("uploaded")) || info.ContentType != "video/mp4" {
+		t.Errorf("Stat of the uploaded object = %+v, %v", info, err)
+	}
+}
+
+// newFakeS3Store returns a store on a fake endpoint the test can inject
+// failures into. The SDK's own retries are off, so the store's are what's
+// tested.
+func newFakeS3Store(t *testing.T, multipart MultipartConfig) (*S3, *fakeS3) {
+	t.Helper()
+	fake := newFakeS3("tubely-test")
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		Retryer:                    aws.NopRetryer{},
+	})
+	return NewS3(client, fake.bucket, "us-east-1", server.URL+"/"+fake.bucket, multipart), fake
+}
+
+func TestPartLayout(t *testing.T) {
+	tests := []struct {
+		name         string
+		size         int64
+		partSize     int64
+		wantPartSize int64
+		wantParts    int
+	}{
+		{"configured size", 100 << 20, 16 << 20, 16 << 20, 7},
+		{"exact multiple", 32 << 20, 16 << 20, 16 << 20, 2},
+		{"single part", 16 << 20, 16 << 20, 16 << 20, 1},
+		{"raised to the S3 minimum", 11 << 20, 1 << 20, minPartSize, 3},
+		{"unset part size", 11 << 20, 0, minPartSize, 3},
+		{"raised to fit the part limit", 1 << 40, 16 << 20, 1<<40/maxParts + 1, maxParts},
+		{"exactly the part limit", maxParts * (16 << 20), 16 << 20, 16<<20 + 1, maxParts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partSize, parts := partLayout(tt.size, tt.partSize)
+			if partSize != tt.wantPartSize || parts != tt.wantParts {
+				t.Errorf("partLayout(%d, %d) = %d, %d; want %d, %d", tt.size, tt.partSize, partSize, parts, tt.wantPartSize, tt.wantParts)
+			}
+			if parts > maxParts || int64(parts)*partSize < tt.size || int64(parts-1)*partSize >= tt.size {
+				t.Errorf("%d parts of %d bytes don't cover %d bytes", parts, partSize, tt.size)
+			}
+		})
+	}
+}
+
+func TestS3PutMultipart(t *testing.T) {
+	// three parts once the part size is raised to 5MB
+	body := make([]byte, 2*minPartSize+1234)
+	for i := range body {
+		body[i] = byte(i % 251)
+	}
+	tests := []struct {
+		name         string
+		threshold    int64
+		maxRetries   int
+		failures     map[int]int
+		wantErr      bool
+		wantUploads  int
+		wantAttempts map[int]int
+	}{
+		{"below the threshold", int64(len(body)) + 1, 3, nil, false, 0, map[int]int{}},
+		{"all parts succeed", 1, 3, nil, false, 1, map[int]int{1: 1, 2: 1, 3: 1}},
+		{"a part fails once", 1, 3, map[int]int{2: 1}, false, 1, map[int]int{1: 1, 2: 2, 3: 1}},
+		{"a part keeps failing", 1, 1, map[int]int{2: 100}, true, 1, map[int]int{2: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			store, fake := newFakeS3Store(t, MultipartConfig{
+				Threshold:   tt.threshold,
+				PartSize:    1,
+				Concurrency: 2,
+				MaxRetries:  tt.maxRetries,
+			})
+			for n, count := range tt.failures {
+				fake.partFailures[n] = count
+			}
+
+			err := store.Put(ctx, "landscape/big.mp4", bytes.NewReader(body), "video/mp4")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Put = %v; want error %v", err, tt.wantErr)
+			}
+
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if fake.nextID != tt.wantUploads {
+				t.Errorf("%d multipart uploads created; want %d", fake.nextID, tt.wantUploads)
+			}
+			for n, want := range tt.wantAttempts {
+				if got := fake.partAttempts[n]; got != want {
+					t.Errorf("part %d uploaded %d times; want %d", n, got, want)
+				}
+			}
+			if len(fake.uploads) != 0 {
+				t.Errorf("%d multipart uploads left incomplete", len(fake.uploads))
+			}
+
+			obj, stored := fake.objects["landscape/big.mp4"]
+			if tt.wantErr {
+				if len(fake.aborted) != 1 {
+					t.Errorf("aborted uploads = %v; want the failed one", fake.aborted)
+				}
+				if stored {
+					t.Errorf("object stored despite the failed upload")
+				}
+				return
+			}
+			if len(fake.aborted) != 0 {
+				t.Errorf("aborted uploads = %v; want none", fake.aborted)
+			}
+			if !stored || !bytes.Equal(obj.data, body) || obj.contentType != "video/mp4" {
+				t.Errorf("stored object = %d bytes of %q; want the %d bytes put", len(obj.data), obj.contentType, len(body))
+			}
+		})
+	}
+}
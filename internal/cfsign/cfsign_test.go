@@ -0,0 +1,172 @@
+package cfsign
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = func() *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// decode reverses CloudFront's base64 alphabet, rejecting the characters it
+// replaces.
+func decode(t *testing.T, s string) []byte {
+	t.Helper()
+	if strings.ContainsAny(s, "+=/") {
+		t.Fatalf("%q isn't in CloudFront's base64 alphabet", s)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(s))
+	if err != nil {
+		t.Fatalf("decoding %q: %v", s, err)
+	}
+	return b
+}
+
+func verify(t *testing.T, policy []byte, sig string) {
+	t.Helper()
+	hash := sha1.Sum(policy)
+	if err := rsa.VerifyPKCS1v15(&testKey.PublicKey, crypto.SHA1, hash[:], decode(t, sig)); err != nil {
+		t.Errorf("signature doesn't verify against %s: %v", policy, err)
+	}
+}
+
+func TestSignURLCanned(t *testing.T) {
+	signer := NewSigner("KTEST", testKey)
+	rawURL := "https://d111.cloudfront.net/landscape/a.mp4?v=1&w=2"
+	expires := time.Unix(1900000000, 0)
+
+	signed, err := signer.SignURL(rawURL, Policy{Expires: expires})
+	if err != nil {
+		t.Fatalf("SignURL: %v", err)
+	}
+	if !strings.HasPrefix(signed, rawURL+"&") {
+		t.Fatalf("SignURL = %q; want the original URL kept", signed)
+	}
+	u, _ := url.Parse(signed)
+	q := u.Query()
+	if q.Get("Policy") != "" || q.Get("Expires") != "1900000000" || q.Get("Key-Pair-Id") != "KTEST" {
+		t.Fatalf("canned query = %v", q)
+	}
+
+	// CloudFront rebuilds a canned policy from the URL without the signature
+	// parameters
+	policy := fmt.Sprintf(`{"Statement":[{"Resource":"%s","Condition":{"DateLessThan":{"AWS:EpochTime":1900000000}}}]}`, rawURL)
+	verify(t, []byte(policy), q.Get("Signature"))
+}
+
+func TestSignURLCustom(t *testing.T) {
+	signer := NewSigner("KTEST", testKey)
+	tests := []struct {
+		name   string
+		policy Policy
+	}{
+		{"ip range", Policy{IPRange: "203.0.113.7/32"}},
+		{"wildcard resource", Policy{Resource: "https://d111.cloudfront.net/landscape/a/*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawURL := "https://d111.cloudfront.net/landscape/a/hls/master.m3u8"
+			tt.policy.Expires = time.Unix(1900000000, 0)
+			signed, err := signer.SignURL(rawURL, tt.policy)
+			if err != nil {
+				t.Fatalf("SignURL: %v", err)
+			}
+			u, _ := url.Parse(signed)
+			q := u.Query()
+			if q.Get("Expires") != "" || q.Get("Key-Pair-Id") != "KTEST" {
+				t.Fatalf("custom query = %v", q)
+			}
+
+			policy := decode(t, q.Get("Policy"))
+			verify(t, policy, q.Get("Signature"))
+
+			var doc policyDocument
+			if err := json.Unmarshal(policy, &doc); err != nil || len(doc.Statement) != 1 {
+				t.Fatalf("policy %s: %v", policy, err)
+			}
+			st := doc.Statement[0]
+			wantResource := tt.policy.Resource
+			if wantResource == "" {
+				wantResource = rawURL
+			}
+			if st.Resource != wantResource || st.Condition.DateLessThan.EpochTime != 1900000000 {
+				t.Errorf("policy statement = %+v", st)
+			}
+			if tt.policy.IPRange != "" && (st.Condition.IPAddress == nil || st.Condition.IPAddress.SourceIP != tt.policy.IPRange) {
+				t.Errorf("policy IP condition = %+v; want %s", st.Condition.IPAddress, tt.policy.IPRange)
+			}
+		})
+	}
+}
+
+func TestSignedCookies(t *testing.T) {
+	signer := NewSigner("KTEST", testKey)
+	p := Policy{Resource: "https://d111.cloudfront.net/landscape/a*", Expires: time.Unix(1900000000, 0)}
+
+	cookies, err := signer.SignedCookies(p, "example.com", "/")
+	if err != nil {
+		t.Fatalf("SignedCookies: %v", err)
+	}
+	values := map[string]string{}
+	for _, c := range cookies {
+		if !c.Secure || !c.HttpOnly || c.Domain != "example.com" || !c.Expires.Equal(p.Expires) {
+			t.Errorf("cookie %s = %+v", c.Name, c)
+		}
+		values[c.Name] = c.Value
+	}
+	if values["CloudFront-Key-Pair-Id"] != "KTEST" {
+		t.Errorf("CloudFront-Key-Pair-Id = %q", values["CloudFront-Key-Pair-Id"])
+	}
+	verify(t, decode(t, values["CloudFront-Policy"]), values["CloudFront-Signature"])
+}
+
+func TestSignRejectsIncompletePolicy(t *testing.T) {
+	signer := NewSigner("KTEST", testKey)
+	if _, err := signer.SignURL("https://d111.cloudfront.net/a.mp4", Policy{}); err == nil {
+		t.Errorf("SignURL without an expiry succeeded")
+	}
+	if _, err := signer.SignedCookies(Policy{Expires: time.Now()}, "", "/"); err == nil {
+		t.Errorf("SignedCookies without a resource succeeded")
+	}
+}
+
+func TestParsePrivateKey(t *testing.T) {
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		block *pem.Block
+	}{
+		{"PKCS#1", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(testKey)}},
+		{"PKCS#8", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParsePrivateKey(pem.EncodeToMemory(tt.block))
+			if err != nil || !key.Equal(testKey) {
+				t.Errorf("ParsePrivateKey = %v, %v", key, err)
+			}
+		})
+	}
+	if _, err := ParsePrivateKey([]byte("not a key")); err == nil {
+		t.Errorf("ParsePrivateKey of garbage succeeded")
+	}
+}
@@ -3,87 +3,82 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// sqliteBusyTimeout is how long, in milliseconds, a SQLite statement waits for
+// another connection's lock.
+const sqliteBusyTimeout = 5000
+
 type Client struct {
-	db *sql.DB
+	db conn
 }
 
-func NewClient(pathToDB string) (Client, error) {
-	db, err := sql.Open("sqlite3", pathToDB)
-	if err != nil {
-		return Client{}, err
+// Open connects to the database without touching its schema. dsn is either a
+// postgres:// URL or a SQLite file path; on SQLite, foreign keys are enforced
+// on every connection, and writers wait for a locked database instead of
+// failing right away, since workers write concurrently.
+func Open(dsn string) (Client, error) {
+	d := dialectFor(dsn)
+	if d == dialectSQLite {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "_foreign_keys=1&_busy_timeout=" + strconv.Itoa(sqliteBusyTimeout)
 	}
-	c := Client{db}
-	err = c.autoMigrate()
+	db, err := sql.Open(d.driverName(), dsn)
 	if err != nil {
 		return Client{}, err
 	}
-	return c, nil
-
+	return Client{conn{db, d}}, nil
 }
 
-func (c *Client) autoMigrate() error {
-	userTable := `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		password TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL
-	);
-	`
-	_, err := c.db.Exec(userTable)
+// NewClient opens the database and applies any pending migrations.
+func NewClient(dsn string) (Client, error) {
+	c, err := Open(dsn)
 	if err != nil {
-		return err
+		return Client{}, err
 	}
-	refreshTokenTable := `
-	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		token TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		revoked_at TIMESTAMP,
-		user_id TEXT NOT NULL,
-		expires_at TIMESTAMP NOT NULL,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(refreshTokenTable)
-	if err != nil {
-		return err
+	if _, err := c.MigrateUp(); err != nil {
+		c.Close()
+		return Client{}, err
 	}
+	return c, nil
+}
 
-	videoTable := `
-	CREATE TABLE IF NOT EXISTS videos (
-		id TEXT PRIMARY KEY,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		title TEXT NOT NULL,
-		description TEXT,
-		thumbnail_url TEXT,
-		video_url TEXT TEXT,
-		user_id INTEGER,
-		FOREIGN KEY(user_id) REFERENCES users(id)
-	);
-	`
-	_, err = c.db.Exec(videoTable)
-	if err != nil {
-		return err
-	}
-	return nil
+func (c Client) Close() error {
+	return c.db.Close()
 }
 
 func (c Client) Reset() error {
+	if _, err := c.db.Exec("DELETE FROM jobs"); err != nil {
+		return fmt.Errorf("failed to reset table jobs: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM uploads"); err != nil {
+		return fmt.Errorf("failed to reset table uploads: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM thumbnail_candidates"); err != nil {
+		return fmt.Errorf("failed to reset table thumbnail_candidates: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM video_media_info"); err != nil {
+		return fmt.Errorf("failed to reset table video_media_info: %w", err)
+	}
+	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
+		return fmt.Errorf("failed to reset table videos: %w", err)
+	}
 	if _, err := c.db.Exec("DELETE FROM refresh_tokens"); err != nil {
 		return fmt.Errorf("failed to reset table refresh_tokens: %w", err)
 	}
 	if _, err := c.db.Exec("DELETE FROM users"); err != nil {
 		return fmt.Errorf("failed to reset table users: %w", err)
 	}
-	if _, err := c.db.Exec("DELETE FROM videos"); err != nil {
-		return fmt.Errorf("failed to reset table videos: %w", err)
+	if _, err := c.db.Exec("DELETE FROM pending_deletions"); err != nil {
+		return fmt.Errorf("failed to reset table pending_deletions: %w", err)
 	}
 	return nil
 }
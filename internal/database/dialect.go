@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite"
+	dialectPostgres dialect = "postgres"
+)
+
+// dialectFor picks the backend from a connection string: postgres:// and
+// postgresql:// URLs are PostgreSQL, anything else is a SQLite file path.
+func dialectFor(dsn string) dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return dialectPostgres
+	}
+	return dialectSQLite
+}
+
+func (d dialect) driverName() string {
+	if d == dialectPostgres {
+		return "postgres"
+	}
+	return "sqlite3"
+}
+
+// timeArg is t as a query argument comparable with stored timestamps. SQLite
+// keeps CURRENT_TIMESTAMP as "YYYY-MM-DD HH:MM:SS" text and compares it as
+// text, so times are passed in that form.
+func (d dialect) timeArg(t time.Time) any {
+	if d == dialectSQLite {
+		return t.UTC().Format(time.DateTime)
+	}
+	return t
+}
+
+// binaryCollation makes column sort by bytes, as SQLite does by default,
+// rather than by PostgreSQL's locale.
+func (d dialect) binaryCollation(column string) string {
+	if d == dialectPostgres {
+		return column + ` COLLATE "C"`
+	}
+	return column
+}
+
+// rebind rewrites the ? placeholders queries are written with into the
+// dialect's own. Quoted strings are left alone.
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// conn is a *sql.DB whose queries are rebound to its dialect, so the rest of
+// the package writes portable SQL with ? placeholders.
+type conn struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (c conn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c conn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c conn) QueryRow(query string, args ...any) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c conn) Begin() (dialectTx, error) {
+	t, err := c.DB.Begin()
+	if err != nil {
+		return dialectTx{}, err
+	}
+	return dialectTx{t, c.dialect}, nil
+}
+
+// BeginOn starts a transaction on a connection pinned with DB.Conn.
+func (c conn) BeginOn(ctx context.Context, pinned *sql.Conn) (dialectTx, error) {
+	t, err := pinned.BeginTx(ctx, nil)
+	if err != nil {
+		return dialectTx{}, err
+	}
+	return dialectTx{t, c.dialect}, nil
+}
+
+// dialectTx is the transaction counterpart of conn.
+type dialectTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t dialectTx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t dialectTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t dialectTx) QueryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
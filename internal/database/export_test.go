@@ -0,0 +1,40 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BackdateVideo moves a video's timestamps d into the past, for storetest
+// checks that need them to differ without waiting on the clock.
+func (c Client) BackdateVideo(id uuid.UUID, d time.Duration) error {
+	video, err := c.GetVideo(id)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		return fmt.Errorf("video %s not found", id)
+	}
+	_, err = c.db.Exec(
+		`UPDATE videos SET created_at = ?, updated_at = ? WHERE id = ?`,
+		video.CreatedAt.Add(-d).UTC(),
+		video.UpdatedAt.Add(-d).UTC(),
+		id,
+	)
+	return err
+}
+
+func (s *MemoryStore) BackdateVideo(id uuid.UUID, d time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	video, ok := s.videos[id]
+	if !ok {
+		return fmt.Errorf("video %s not found", id)
+	}
+	video.CreatedAt = video.CreatedAt.Add(-d)
+	video.UpdatedAt = video.UpdatedAt.Add(-d)
+	s.videos[id] = video
+	return nil
+}
@@ -0,0 +1,260 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+type Job struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	RunAt     time.Time `json:"run_at"`
+	LastError *string   `json:"last_error"`
+	CreateJobParams
+}
+
+type CreateJobParams struct {
+	VideoID     uuid.UUID `json:"video_id"`
+	Kind        string    `json:"kind"`
+	Payload     string    `json:"-"`
+	MaxAttempts int       `json:"max_attempts"`
+}
+
+func (c Client) CreateJob(params CreateJobParams) (Job, error) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		kind,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at
+	) VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, now, now, params.VideoID, params.Kind, params.Payload, JobStatusQueued, params.MaxAttempts, now)
+	if err != nil {
+		return Job{}, err
+	}
+	return c.GetJob(id)
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		kind,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at,
+		last_error
+`
+
+func scanJob(row interface{ Scan(...any) error }) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.VideoID,
+		&job.Kind,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+	)
+	return job, err
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = ?`
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// ClaimNextJob marks the oldest due queued job as running and returns it. It
+// returns a zero Job when nothing is due.
+func (c Client) ClaimNextJob(now time.Time) (Job, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Job{}, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + jobColumns + `
+	FROM jobs
+	WHERE status = ? AND run_at <= ?
+	ORDER BY run_at ASC
+	LIMIT 1
+	`
+	job, err := scanJob(tx.QueryRow(query, JobStatusQueued, now.UTC()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+
+	res, err := tx.Exec(`
+	UPDATE jobs
+	SET
+		status = ?,
+		attempts = attempts + 1,
+		updated_at = ?
+	WHERE id = ? AND status = ?
+	`, JobStatusRunning, now.UTC(), job.ID, JobStatusQueued)
+	if err != nil {
+		return Job{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		// another worker got there first
+		return Job{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Job{}, err
+	}
+
+	job.Status = JobStatusRunning
+	job.Attempts++
+	return job, nil
+}
+
+func (c Client) CompleteJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = NULL,
+		updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobStatusSucceeded, time.Now().UTC(), id)
+	return err
+}
+
+// FailJob records a failed attempt. The job is queued again for retryAt, or
+// marked failed for good when retryAt is nil.
+func (c Client) FailJob(id uuid.UUID, errMsg string, retryAt *time.Time) error {
+	status := JobStatusFailed
+	runAt := time.Now().UTC()
+	if retryAt != nil {
+		status = JobStatusQueued
+		runAt = retryAt.UTC()
+	}
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = ?,
+		run_at = ?,
+		updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, errMsg, runAt, time.Now().UTC(), id)
+	return err
+}
+
+// errJobInterrupted is recorded on jobs a crash interrupted at their last
+// attempt.
+const errJobInterrupted = "interrupted by a restart"
+
+// RequeueRunningJobs puts jobs left running by a crashed process back in the
+// queue. The interrupted attempt counts, so jobs that were at their last
+// attempt are marked failed instead and returned, rather than crashing the
+// process forever. It must only be called before any worker starts.
+func (c Client) RequeueRunningJobs() (int64, []Job, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT `+jobColumns+`
+	FROM jobs
+	WHERE status = ? AND attempts >= max_attempts
+	`, JobStatusRunning)
+	if err != nil {
+		return 0, nil, err
+	}
+	failed := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		failed = append(failed, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	now := time.Now().UTC()
+	_, err = tx.Exec(`
+	UPDATE jobs
+	SET
+		status = ?,
+		last_error = ?,
+		updated_at = ?
+	WHERE status = ? AND attempts >= max_attempts
+	`, JobStatusFailed, errJobInterrupted, now, JobStatusRunning)
+	if err != nil {
+		return 0, nil, err
+	}
+	res, err := tx.Exec(`
+	UPDATE jobs
+	SET
+		status = ?,
+		run_at = ?,
+		updated_at = ?
+	WHERE status = ?
+	`, JobStatusQueued, now, now, JobStatusRunning)
+	if err != nil {
+		return 0, nil, err
+	}
+	requeued, err := res.RowsAffected()
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, nil, err
+	}
+
+	for i := range failed {
+		errMsg := errJobInterrupted
+		failed[i].Status = JobStatusFailed
+		failed[i].LastError = &errMsg
+		failed[i].UpdatedAt = now
+	}
+	return requeued, failed, nil
+}
@@ -0,0 +1,142 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MediaInfo is what ffprobe reports about a video's file. Width and
+// Height are the coded dimensions; Rotation (clockwise degrees) says how the
+// picture is turned for display.
+type MediaInfo struct {
+	VideoID         uuid.UUID `json:"-"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Container       string    `json:"container"`
+	VideoCodec      string    `json:"video_codec"`
+	VideoProfile    string    `json:"video_profile"`
+	Width           int       `json:"width"`
+	Height          int       `json:"height"`
+	// SampleAspectRatio is the pixel shape as "num:den", "" if unknown
+	SampleAspectRatio string  `json:"sample_aspect_ratio"`
+	FrameRate         float64 `json:"frame_rate"`
+	BitRate           int64   `json:"bit_rate"`
+	AudioCodec        string  `json:"audio_codec"`
+	AudioChannels     int     `json:"audio_channels"`
+	AudioSampleRate   int     `json:"audio_sample_rate"`
+	Rotation          int     `json:"rotation"`
+}
+
+func (c Client) SaveMediaInfo(info MediaInfo) error {
+	query := `
+	INSERT INTO video_media_info (
+		video_id,
+		updated_at,
+		duration_seconds,
+		container,
+		video_codec,
+		video_profile,
+		width,
+		height,
+		sample_aspect_ratio,
+		frame_rate,
+		bit_rate,
+		audio_codec,
+		audio_channels,
+		audio_sample_rate,
+		rotation
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	ON CONFLICT(video_id) DO UPDATE SET
+		updated_at = excluded.updated_at,
+		duration_seconds = excluded.duration_seconds,
+		container = excluded.container,
+		video_codec = excluded.video_codec,
+		video_profile = excluded.video_profile,
+		width = excluded.width,
+		height = excluded.height,
+		sample_aspect_ratio = excluded.sample_aspect_ratio,
+		frame_rate = excluded.frame_rate,
+		bit_rate = excluded.bit_rate,
+		audio_codec = excluded.audio_codec,
+		audio_channels = excluded.audio_channels,
+		audio_sample_rate = excluded.audio_sample_rate,
+		rotation = excluded.rotation
+	`
+	_, err := c.db.Exec(
+		query,
+		info.VideoID,
+		time.Now().UTC(),
+		info.DurationSeconds,
+		info.Container,
+		info.VideoCodec,
+		info.VideoProfile,
+		info.Width,
+		info.Height,
+		info.SampleAspectRatio,
+		info.FrameRate,
+		info.BitRate,
+		info.AudioCodec,
+		info.AudioChannels,
+		info.AudioSampleRate,
+		info.Rotation,
+	)
+	return err
+}
+
+// GetMediaInfo returns nil if the video hasn't been probed.
+func (c Client) GetMediaInfo(videoID uuid.UUID) (*MediaInfo, error) {
+	query := `
+	SELECT
+		video_id,
+		updated_at,
+		duration_seconds,
+		container,
+		video_codec,
+		video_profile,
+		width,
+		height,
+		sample_aspect_ratio,
+		frame_rate,
+		bit_rate,
+		audio_codec,
+		audio_channels,
+		audio_sample_rate,
+		rotation
+	FROM video_media_info
+	WHERE video_id = ?
+	`
+
+	var info MediaInfo
+	err := c.db.QueryRow(query, videoID).Scan(
+		&info.VideoID,
+		&info.UpdatedAt,
+		&info.DurationSeconds,
+		&info.Container,
+		&info.VideoCodec,
+		&info.VideoProfile,
+		&info.Width,
+		&info.Height,
+		&info.SampleAspectRatio,
+		&info.FrameRate,
+		&info.BitRate,
+		&info.AudioCodec,
+		&info.AudioChannels,
+		&info.AudioSampleRate,
+		&info.Rotation,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (c Client) DeleteMediaInfo(videoID uuid.UUID) error {
+	_, err := c.db.Exec(`DELETE FROM video_media_info WHERE video_id = ?`, videoID)
+	return err
+}
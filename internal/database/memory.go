@@ -0,0 +1,817 @@
+package database
+
+import (
+	"cmp"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	errMemoryForeignKey = errors.New("foreign key constraint failed")
+	errMemoryUnique     = errors.New("unique constraint failed")
+)
+
+// MemoryStore is a Store that keeps everything in memory, for tests. It
+// follows the constraints of the SQL schema: rows must reference existing
+// parents, emails and tokens are unique, and deletes cascade.
+type MemoryStore struct {
+	mu sync.Mutex
+
+	users            map[uuid.UUID]User
+	refreshTokens    map[string]RefreshToken
+	videos           map[uuid.UUID]Video
+	mediaInfo        map[uuid.UUID]MediaInfo
+	candidates       map[uuid.UUID]ThumbnailCandidate
+	uploads          map[uuid.UUID]Upload
+	jobs             map[uuid.UUID]Job
+	pendingDeletions map[uuid.UUID]PendingDeletion
+	// seq orders rows created within the same instant, like insertion
+	// order would
+	seq      int
+	sequence map[any]int
+}
+
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{}
+	s.reset()
+	return s
+}
+
+func (s *MemoryStore) reset() {
+	s.users = map[uuid.UUID]User{}
+	s.refreshTokens = map[string]RefreshToken{}
+	s.videos = map[uuid.UUID]Video{}
+	s.mediaInfo = map[uuid.UUID]MediaInfo{}
+	s.candidates = map[uuid.UUID]ThumbnailCandidate{}
+	s.uploads = map[uuid.UUID]Upload{}
+	s.jobs = map[uuid.UUID]Job{}
+	s.pendingDeletions = map[uuid.UUID]PendingDeletion{}
+	s.sequence = map[any]int{}
+}
+
+func (s *MemoryStore) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reset()
+	return nil
+}
+
+func (s *MemoryStore) track(key any) {
+	s.seq++
+	s.sequence[key] = s.seq
+}
+
+// Users
+
+func (s *MemoryStore) GetUsers() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := []User{}
+	for _, user := range s.users {
+		// only the columns the SQL query selects
+		users = append(users, User{ID: user.ID, CreateUserParams: CreateUserParams{Email: user.Email}})
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return s.sequence[users[i].ID] < s.sequence[users[j].ID]
+	})
+	return users, nil
+}
+
+func (s *MemoryStore) GetUser(id uuid.UUID) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) GetUserByEmail(email string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+	return User{}, nil
+}
+
+func (s *MemoryStore) GetUserByRefreshToken(token string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, ok := s.refreshTokens[token]
+	if !ok {
+		return nil, nil
+	}
+	user, ok := s.users[rt.UserID]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+func (s *MemoryStore) CreateUser(params CreateUserParams) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Email == params.Email {
+			return nil, errMemoryUnique
+		}
+	}
+	now := time.Now().UTC()
+	user := User{
+		ID:               uuid.New(),
+		CreatedAt:        now,
+		UpdatedAt:        now,
+		CreateUserParams: params,
+	}
+	s.users[user.ID] = user
+	s.track(user.ID)
+	return &user, nil
+}
+
+func (s *MemoryStore) DeleteUser(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return nil
+	}
+	delete(s.users, id)
+	for token, rt := range s.refreshTokens {
+		if rt.UserID == id {
+			delete(s.refreshTokens, token)
+		}
+	}
+	for videoID, video := range s.videos {
+		if video.UserID == id {
+			s.deleteVideo(videoID)
+		}
+	}
+	for uploadID, upload := range s.uploads {
+		if upload.UserID == id {
+			delete(s.uploads, uploadID)
+		}
+	}
+	return nil
+}
+
+// Refresh tokens
+
+func (s *MemoryStore) CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[params.UserID]; !ok {
+		return RefreshToken{}, errMemoryForeignKey
+	}
+	if _, ok := s.refreshTokens[params.Token]; ok {
+		return RefreshToken{}, errMemoryUnique
+	}
+	now := time.Now().UTC()
+	rt := RefreshToken{
+		CreateRefreshTokenParams: params,
+		CreatedAt:                now,
+		UpdatedAt:                now,
+	}
+	s.refreshTokens[rt.Token] = rt
+	return rt, nil
+}
+
+func (s *MemoryStore) GetRefreshToken(token string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.refreshTokens[token], nil
+}
+
+func (s *MemoryStore) RevokeRefreshToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rt, ok := s.refreshTokens[token]
+	if !ok {
+		return nil
+	}
+	now := time.Now().UTC()
+	rt.RevokedAt = &now
+	s.refreshTokens[token] = rt
+	return nil
+}
+
+func (s *MemoryStore) DeleteRefreshToken(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.refreshTokens, token)
+	return nil
+}
+
+// Videos
+
+// persistedVideo keeps only the fields a videos row stores; URLs and other
+// derived fields are resolved by the caller.
+func persistedVideo(v Video) Video {
+	return Video{
+		ID:                 v.ID,
+		CreatedAt:          v.CreatedAt,
+		UpdatedAt:          v.UpdatedAt,
+		ThumbnailURL:       v.ThumbnailURL,
+		VideoURL:           v.VideoURL,
+		ThumbnailKey:       v.ThumbnailKey,
+		VideoKey:           v.VideoKey,
+		ThumbnailGenerated: v.ThumbnailGenerated,
+		ThumbnailWidths:    v.ThumbnailWidths,
+		ThumbnailFormats:   v.ThumbnailFormats,
+		OriginalKey:        v.OriginalKey,
+		HLSKey:             v.HLSKey,
+		DASHKey:            v.DASHKey,
+		SpriteVTTKey:       v.SpriteVTTKey,
+		SpriteSheetCount:   v.SpriteSheetCount,
+		ProcessingStatus:   v.ProcessingStatus,
+		CreateVideoParams:  v.CreateVideoParams,
+	}
+}
+
+func (s *MemoryStore) ListVideos(params ListVideosParams) (VideoPage, error) {
+	if err := params.validate(); err != nil {
+		return VideoPage{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type entry struct {
+		video    Video
+		duration float64
+	}
+	present := func(key, url *string) bool {
+		return key != nil || url != nil
+	}
+	entries := []entry{}
+	for _, video := range s.videos {
+		if video.UserID != params.UserID {
+			continue
+		}
+		if params.HasVideo != nil && present(video.VideoKey, video.VideoURL) != *params.HasVideo {
+			continue
+		}
+		if params.HasThumbnail != nil && present(video.ThumbnailKey, video.ThumbnailURL) != *params.HasThumbnail {
+			continue
+		}
+		if params.CreatedAfter != nil && video.CreatedAt.Before(*params.CreatedAfter) {
+			continue
+		}
+		if params.CreatedBefore != nil && !video.CreatedAt.Before(*params.CreatedBefore) {
+			continue
+		}
+		entries = append(entries, entry{video, s.mediaInfo[video.ID].DurationSeconds})
+	}
+
+	// compare orders two positions by the listing's sort key, then ID
+	compare := func(a, b VideoCursor) int {
+		c := 0
+		switch params.sort() {
+		case VideoSortCreated, VideoSortUpdated:
+			c = a.Time.Compare(b.Time)
+		case VideoSortTitle:
+			c = strings.Compare(a.Title, b.Title)
+		case VideoSortDuration:
+			c = cmp.Compare(a.Duration, b.Duration)
+		}
+		if c == 0 {
+			c = strings.Compare(a.ID.String(), b.ID.String())
+		}
+		if !params.Ascending {
+			c = -c
+		}
+		return c
+	}
+	cursor := func(e entry) VideoCursor {
+		return params.cursorFor(e.video, e.duration)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return compare(cursor(entries[i]), cursor(entries[j])) < 0
+	})
+
+	page := VideoPage{Videos: []Video{}}
+	var last entry
+	for _, e := range entries {
+		if params.After != nil && compare(cursor(e), *params.After) <= 0 {
+			continue
+		}
+		if len(page.Videos) == params.Limit {
+			next := cursor(last)
+			page.Next = &next
+			break
+		}
+		page.Videos = append(page.Videos, e.video)
+		last = e
+	}
+	return page, nil
+}
+
+func (s *MemoryStore) GetVideo(id uuid.UUID) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.videos[id], nil
+}
+
+func (s *MemoryStore) CreateVideo(params CreateVideoParams) (Video, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[params.UserID]; !ok {
+		return Video{}, errMemoryForeignKey
+	}
+	now := time.Now().UTC()
+	video := Video{
+		ID:                uuid.New(),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		CreateVideoParams: params,
+	}
+	s.videos[video.ID] = video
+	s.track(video.ID)
+	return video, nil
+}
+
+func (s *MemoryStore) UpdateVideo(video Video) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.videos[video.ID]
+	if !ok {
+		return nil
+	}
+	if _, ok := s.users[video.UserID]; !ok {
+		return errMemoryForeignKey
+	}
+	updated := persistedVideo(video)
+	updated.UpdatedAt = time.Now().UTC()
+	// UpdateVideo doesn't write these columns
+	updated.CreatedAt = current.CreatedAt
+	updated.ThumbnailURL = current.ThumbnailURL
+	updated.VideoURL = current.VideoURL
+	s.videos[video.ID] = updated
+	return nil
+}
+
+func (s *MemoryStore) UpdateVideoProcessingStatus(id uuid.UUID, status string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	video, ok := s.videos[id]
+	if !ok {
+		return nil
+	}
+	video.ProcessingStatus = status
+	video.UpdatedAt = time.Now().UTC()
+	s.videos[id] = video
+	return nil
+}
+
+func (s *MemoryStore) SetThumbnailFormats(id uuid.UUID, thumbnailKey, formats string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	video, ok := s.videos[id]
+	if !ok || video.ThumbnailKey == nil || *video.ThumbnailKey != thumbnailKey {
+		return false, nil
+	}
+	video.ThumbnailFormats = formats
+	video.UpdatedAt = time.Now().UTC()
+	s.videos[id] = video
+	return true, nil
+}
+
+func (s *MemoryStore) DeleteVideo(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteVideo(id)
+	return nil
+}
+
+func (s *MemoryStore) DeleteVideoQueueingBlobs(id uuid.UUID, blobs []BlobRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteVideo(id)
+	for _, blob := range blobs {
+		s.createPendingDeletion(blob.Store, blob.Key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) deleteVideo(id uuid.UUID) {
+	delete(s.videos, id)
+	delete(s.mediaInfo, id)
+	for candidateID, candidate := range s.candidates {
+		if candidate.VideoID == id {
+			delete(s.candidates, candidateID)
+		}
+	}
+	for uploadID, upload := range s.uploads {
+		if upload.VideoID == id {
+			delete(s.uploads, uploadID)
+		}
+	}
+	for jobID, job := range s.jobs {
+		if job.VideoID == id {
+			delete(s.jobs, jobID)
+		}
+	}
+}
+
+func (s *MemoryStore) MigrateVideoURLsToKeys(videoKeyFromURL, thumbnailKeyFromURL func(string) (string, bool)) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	migrated := 0
+	for id, video := range s.videos {
+		legacy := (video.ThumbnailURL != nil && video.ThumbnailKey == nil) ||
+			(video.VideoURL != nil && video.VideoKey == nil)
+		if !legacy {
+			continue
+		}
+		changed := false
+		if video.VideoURL != nil {
+			if key, ok := videoKeyFromURL(*video.VideoURL); ok {
+				video.VideoKey = &key
+				video.VideoURL = nil
+				changed = true
+			}
+		}
+		if video.ThumbnailURL != nil {
+			if key, ok := thumbnailKeyFromURL(*video.ThumbnailURL); ok {
+				video.ThumbnailKey = &key
+				video.ThumbnailURL = nil
+				changed = true
+			}
+		}
+		if changed {
+			s.videos[id] = video
+			migrated++
+		}
+	}
+	return migrated, nil
+}
+
+// Media info
+
+func (s *MemoryStore) SaveMediaInfo(info MediaInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.videos[info.VideoID]; !ok {
+		return errMemoryForeignKey
+	}
+	info.UpdatedAt = time.Now().UTC()
+	s.mediaInfo[info.VideoID] = info
+	return nil
+}
+
+func (s *MemoryStore) GetMediaInfo(videoID uuid.UUID) (*MediaInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, ok := s.mediaInfo[videoID]
+	if !ok {
+		return nil, nil
+	}
+	return &info, nil
+}
+
+func (s *MemoryStore) DeleteMediaInfo(videoID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mediaInfo, videoID)
+	return nil
+}
+
+// Thumbnail candidates
+
+func (s *MemoryStore) ReplaceThumbnailCandidates(videoID uuid.UUID, candidates []ThumbnailCandidate) ([]ThumbnailCandidate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(candidates) > 0 {
+		if _, ok := s.videos[videoID]; !ok {
+			return nil, errMemoryForeignKey
+		}
+	}
+	old := s.thumbnailCandidates(videoID)
+	for _, candidate := range old {
+		delete(s.candidates, candidate.ID)
+	}
+
+	now := time.Now().UTC()
+	for _, candidate := range candidates {
+		stored := ThumbnailCandidate{
+			ID:        uuid.New(),
+			CreatedAt: now,
+			VideoID:   videoID,
+			Position:  candidate.Position,
+			Key:       candidate.Key,
+		}
+		s.candidates[stored.ID] = stored
+	}
+	return old, nil
+}
+
+func (s *MemoryStore) GetThumbnailCandidates(videoID uuid.UUID) ([]ThumbnailCandidate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.thumbnailCandidates(videoID), nil
+}
+
+func (s *MemoryStore) thumbnailCandidates(videoID uuid.UUID) []ThumbnailCandidate {
+	candidates := []ThumbnailCandidate{}
+	for _, candidate := range s.candidates {
+		if candidate.VideoID == videoID {
+			candidates = append(candidates, candidate)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].Position < candidates[j].Position
+	})
+	return candidates
+}
+
+func (s *MemoryStore) GetThumbnailCandidate(id uuid.UUID) (ThumbnailCandidate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.candidates[id], nil
+}
+
+func (s *MemoryStore) DeleteThumbnailCandidates(videoID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, candidate := range s.thumbnailCandidates(videoID) {
+		delete(s.candidates, candidate.ID)
+	}
+	return nil
+}
+
+// Uploads
+
+func (s *MemoryStore) CreateUpload(params CreateUploadParams) (Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.videos[params.VideoID]; !ok {
+		return Upload{}, errMemoryForeignKey
+	}
+	if _, ok := s.users[params.UserID]; !ok {
+		return Upload{}, errMemoryForeignKey
+	}
+	now := time.Now().UTC()
+	upload := Upload{
+		ID:                 uuid.New(),
+		CreatedAt:          now,
+		UpdatedAt:          now,
+		ExpiresAt:          now.Add(params.TTL),
+		CreateUploadParams: params,
+	}
+	// TTL isn't a column
+	upload.TTL = 0
+	s.uploads[upload.ID] = upload
+	s.track(upload.ID)
+	return upload, nil
+}
+
+func (s *MemoryStore) GetUpload(id uuid.UUID) (Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uploads[id], nil
+}
+
+func (s *MemoryStore) UpdateUploadOffset(id uuid.UUID, offset int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, ok := s.uploads[id]
+	if !ok {
+		return nil
+	}
+	upload.Offset = offset
+	upload.UpdatedAt = time.Now().UTC()
+	s.uploads[id] = upload
+	return nil
+}
+
+func (s *MemoryStore) GetExpiredUploads(now time.Time) ([]Upload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uploads := []Upload{}
+	for _, upload := range s.uploads {
+		if !upload.ExpiresAt.After(now) {
+			uploads = append(uploads, upload)
+		}
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		return s.sequence[uploads[i].ID] < s.sequence[uploads[j].ID]
+	})
+	return uploads, nil
+}
+
+func (s *MemoryStore) DeleteUpload(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.uploads, id)
+	return nil
+}
+
+// Jobs
+
+func (s *MemoryStore) CreateJob(params CreateJobParams) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.videos[params.VideoID]; !ok {
+		return Job{}, errMemoryForeignKey
+	}
+	now := time.Now().UTC()
+	job := Job{
+		ID:              uuid.New(),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		Status:          JobStatusQueued,
+		RunAt:           now,
+		CreateJobParams: params,
+	}
+	s.jobs[job.ID] = job
+	s.track(job.ID)
+	return job, nil
+}
+
+func (s *MemoryStore) GetJob(id uuid.UUID) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id], nil
+}
+
+func (s *MemoryStore) ClaimNextJob(now time.Time) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next Job
+	found := false
+	for _, job := range s.jobs {
+		if job.Status != JobStatusQueued || job.RunAt.After(now) {
+			continue
+		}
+		if !found || job.RunAt.Before(next.RunAt) ||
+			(job.RunAt.Equal(next.RunAt) && s.sequence[job.ID] < s.sequence[next.ID]) {
+			next = job
+			found = true
+		}
+	}
+	if !found {
+		return Job{}, nil
+	}
+
+	next.Status = JobStatusRunning
+	next.Attempts++
+	next.UpdatedAt = now.UTC()
+	s.jobs[next.ID] = next
+	return next, nil
+}
+
+func (s *MemoryStore) CompleteJob(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil
+	}
+	job.Status = JobStatusSucceeded
+	job.LastError = nil
+	job.UpdatedAt = time.Now().UTC()
+	s.jobs[id] = job
+	return nil
+}
+
+func (s *MemoryStore) FailJob(id uuid.UUID, errMsg string, retryAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil
+	}
+	now := time.Now().UTC()
+	job.Status = JobStatusFailed
+	job.RunAt = now
+	if retryAt != nil {
+		job.Status = JobStatusQueued
+		job.RunAt = retryAt.UTC()
+	}
+	job.LastError = &errMsg
+	job.UpdatedAt = now
+	s.jobs[id] = job
+	return nil
+}
+
+func (s *MemoryStore) RequeueRunningJobs() (int64, []Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	var n int64
+	failed := []Job{}
+	for id, job := range s.jobs {
+		if job.Status != JobStatusRunning {
+			continue
+		}
+		job.UpdatedAt = now
+		if job.Attempts >= job.MaxAttempts {
+			errMsg := errJobInterrupted
+			job.Status = JobStatusFailed
+			job.LastError = &errMsg
+			failed = append(failed, job)
+		} else {
+			job.Status = JobStatusQueued
+			job.RunAt = now
+			n++
+		}
+		s.jobs[id] = job
+	}
+	return n, failed, nil
+}
+
+// Pending deletions
+
+func (s *MemoryStore) CreatePendingDeletion(store, key string) (PendingDeletion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createPendingDeletion(store, key), nil
+}
+
+func (s *MemoryStore) createPendingDeletion(store, key string) PendingDeletion {
+	now := time.Now().UTC()
+	d := PendingDeletion{
+		ID:            uuid.New(),
+		CreatedAt:     now,
+		Store:         store,
+		Key:           key,
+		NextAttemptAt: now,
+	}
+	s.pendingDeletions[d.ID] = d
+	s.track(d.ID)
+	return d
+}
+
+func (s *MemoryStore) GetDuePendingDeletions(now time.Time, limit int) ([]PendingDeletion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deletions := []PendingDeletion{}
+	for _, d := range s.pendingDeletions {
+		if !d.NextAttemptAt.After(now) {
+			deletions = append(deletions, d)
+		}
+	}
+	sort.Slice(deletions, func(i, j int) bool {
+		if !deletions[i].NextAttemptAt.Equal(deletions[j].NextAttemptAt) {
+			return deletions[i].NextAttemptAt.Before(deletions[j].NextAttemptAt)
+		}
+		return s.sequence[deletions[i].ID] < s.sequence[deletions[j].ID]
+	})
+	if limit >= 0 && len(deletions) > limit {
+		deletions = deletions[:limit]
+	}
+	return deletions, nil
+}
+
+func (s *MemoryStore) MarkPendingDeletionFailed(id uuid.UUID, errMsg string, nextAttemptAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := s.pendingDeletions[id]
+	if !ok {
+		return nil
+	}
+	d.Attempts++
+	d.LastError = &errMsg
+	d.NextAttemptAt = nextAttemptAt.UTC()
+	s.pendingDeletions[id] = d
+	return nil
+}
+
+func (s *MemoryStore) DeletePendingDeletion(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pendingDeletions, id)
+	return nil
+}
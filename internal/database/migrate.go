@@ -0,0 +1,408 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Each dialect has its own migrations under migrations/<dialect>.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// foreignKeysVersion is the SQLite migration that turned on foreign key
+// enforcement. From there on every migration must leave the data consistent.
+const foreignKeysVersion = 3
+
+// legacyColumns are the columns autoMigrate added to existing SQLite tables
+// over time. Databases it created may lack some of them, so the baseline
+// migration adds whichever are missing.
+var legacyColumns = []struct {
+	table, column, definition string
+}{
+	{"videos", "thumbnail_key", "TEXT"},
+	{"videos", "video_key", "TEXT"},
+	{"videos", "original_key", "TEXT"},
+	{"videos", "hls_key", "TEXT"},
+	{"videos", "dash_key", "TEXT"},
+	{"videos", "sprite_vtt_key", "TEXT"},
+	{"videos", "sprite_sheet_count", "INTEGER NOT NULL DEFAULT 0"},
+	{"videos", "thumbnail_generated", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"videos", "thumbnail_widths", "TEXT NOT NULL DEFAULT ''"},
+	{"videos", "processing_status", "TEXT NOT NULL DEFAULT ''"},
+	{"video_media_info", "sample_aspect_ratio", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// orphanChecks are, per SQLite migration, the rows it would have to drop
+// that hold something it can't clean up itself: blobs only the app knows how
+// to list, or files staged on local disk. The migration refuses to run while
+// any exist, listing them, instead of losing track of what they point at.
+var orphanChecks = map[int][]struct {
+	rows, query string
+}{
+	2: {
+		{"videos without an existing owner", `SELECT id FROM videos WHERE user_id IS NULL OR user_id NOT IN (SELECT id FROM users)`},
+	},
+	3: {
+		{"uploads of a missing video or user", `SELECT id FROM uploads WHERE video_id NOT IN (SELECT id FROM videos) OR user_id NOT IN (SELECT id FROM users)`},
+		{"unfinished jobs of a missing video with a staged upload", `SELECT id FROM jobs WHERE video_id NOT IN (SELECT id FROM videos) AND status IN ('queued', 'running') AND payload LIKE '%"source_path"%'`},
+	},
+}
+
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is one known migration and when it was applied, if it was.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// loadMigrations reads the dialect's embedded NNNN_name.up.sql /
+// NNNN_name.down.sql pairs, ordered by version.
+func loadMigrations(d dialect) ([]migration, error) {
+	dir := "migrations/" + string(d)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+		prefix, label, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+
+		data, err := migrationFiles.ReadFile(dir + "/" + name)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+		if m.Name != label {
+			return nil, fmt.Errorf("migration %d has files named %s and %s", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be contiguous, missing %d", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+func (c Client) ensureMigrationTable() error {
+	timestampType := "TIMESTAMP"
+	if c.db.dialect == dialectPostgres {
+		timestampType = "TIMESTAMPTZ"
+	}
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at ` + timestampType + ` NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	`)
+	return err
+}
+
+// appliedMigrations maps applied versions to when they were applied.
+func (c Client) appliedMigrations() (map[int]time.Time, error) {
+	if err := c.ensureMigrationTable(); err != nil {
+		return nil, err
+	}
+	rows, err := c.db.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// MigrateUp applies every pending migration in order, each in its own
+// transaction. It returns the versions it applied.
+func (c Client) MigrateUp() ([]int, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []int{}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := c.runMigration(m, true); err != nil {
+			return done, fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the latest steps applied migrations, newest first. It
+// returns the versions it reverted.
+func (c Client) MigrateDown(steps int) ([]int, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []int{}
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := c.runMigration(m, false); err != nil {
+			return done, fmt.Errorf("reverting migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m.Version)
+	}
+	return done, nil
+}
+
+// MigrationStatus lists every known migration, oldest first.
+func (c Client) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if at, ok := applied[m.Version]; ok {
+			s.AppliedAt = &at
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+// runMigration applies (or reverts) m and records it in one transaction.
+// SQLite rebuilds tables to change their columns or constraints, which needs
+// foreign key enforcement off; it can only be switched outside a
+// transaction, so this pins a connection for the duration.
+func (c Client) runMigration(m migration, up bool) error {
+	sqlite := c.db.dialect == dialectSQLite
+
+	var tx dialectTx
+	if sqlite {
+		ctx := context.Background()
+		pinned, err := c.db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer pinned.Close()
+
+		if _, err := pinned.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return err
+		}
+		defer pinned.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+
+		if tx, err = c.db.BeginOn(ctx, pinned); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if tx, err = c.db.Begin(); err != nil {
+			return err
+		}
+	}
+	defer tx.Rollback()
+
+	resulting := m.Version
+	if up {
+		if sqlite {
+			if err := checkOrphans(tx, m.Version); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(m.Up); err != nil {
+			return err
+		}
+		if sqlite && m.Version == 1 {
+			for _, col := range legacyColumns {
+				if err := addColumnIfMissing(tx, col.table, col.column, col.definition); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.Version, m.Name); err != nil {
+			return err
+		}
+	} else {
+		resulting = m.Version - 1
+		if _, err := tx.Exec(m.Down); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.Version); err != nil {
+			return err
+		}
+	}
+
+	if sqlite && resulting >= foreignKeysVersion {
+		if err := checkForeignKeys(tx); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// checkForeignKeys fails if any row references a missing parent.
+func checkForeignKeys(q queryer) error {
+	rows, err := q.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var (
+			table  string
+			rowID  sql.NullInt64
+			parent string
+			fkID   int
+		)
+		if err := rows.Scan(&table, &rowID, &parent, &fkID); err != nil {
+			return err
+		}
+		return fmt.Errorf("foreign key violation: %s row %d references a missing %s", table, rowID.Int64, parent)
+	}
+	return rows.Err()
+}
+
+// checkOrphans fails, listing them, if any rows the migration version would
+// drop are caught by orphanChecks.
+func checkOrphans(q queryer, version int) error {
+	for _, check := range orphanChecks[version] {
+		ids, err := queryStrings(q, check.query)
+		if err != nil {
+			return err
+		}
+		if len(ids) > 0 {
+			return fmt.Errorf("found %d %s (%s); reassign or delete them and migrate again", len(ids), check.rows, strings.Join(ids, ", "))
+		}
+	}
+	return nil
+}
+
+func queryStrings(q queryer, query string) ([]string, error) {
+	rows, err := q.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+type execQueryer interface {
+	queryer
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func addColumnIfMissing(db execQueryer, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
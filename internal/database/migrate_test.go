@@ -0,0 +1,172 @@
+package database_test
+
+import (
+	"database/sql"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+func TestMigrations(t *testing.T) {
+	tests := []struct {
+		name string
+		dsn  func(t *testing.T) string
+	}{
+		{"sqlite", sqliteDSN},
+		{"postgres", postgresDSN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := database.Open(tt.dsn(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			applied, err := c.MigrateUp()
+			if err != nil {
+				t.Fatalf("MigrateUp: %v", err)
+			}
+			status, err := c.MigrationStatus()
+			if err != nil {
+				t.Fatalf("MigrationStatus: %v", err)
+			}
+			if len(applied) == 0 || len(applied) != len(status) {
+				t.Fatalf("applied %v of %d migrations", applied, len(status))
+			}
+			for _, s := range status {
+				if s.AppliedAt == nil {
+					t.Errorf("migration %d_%s isn't recorded as applied", s.Version, s.Name)
+				}
+			}
+			if again, err := c.MigrateUp(); err != nil || len(again) != 0 {
+				t.Errorf("second MigrateUp = %v, %v; want nothing to do", again, err)
+			}
+
+			reverted, err := c.MigrateDown(len(applied))
+			if err != nil {
+				t.Fatalf("MigrateDown: %v", err)
+			}
+			want := slices.Clone(applied)
+			slices.Reverse(want)
+			if !slices.Equal(reverted, want) {
+				t.Errorf("MigrateDown reverted %v; want %v", reverted, want)
+			}
+
+			// the down migrations leave a schema the up migrations apply to
+			reapplied, err := c.MigrateUp()
+			if err != nil {
+				t.Fatalf("MigrateUp after reverting: %v", err)
+			}
+			if !slices.Equal(reapplied, applied) {
+				t.Errorf("MigrateUp after reverting applied %v; want %v", reapplied, applied)
+			}
+		})
+	}
+}
+
+// TestSQLiteMigrationOrphans seeds a database at the baseline schema with
+// rows the foreign key migrations can't keep: those holding stored files
+// stop the migration, the others have their objects queued for deletion.
+func TestSQLiteMigrationOrphans(t *testing.T) {
+	tests := []struct {
+		name          string
+		seed          string
+		stopsAt       int
+		wantErr       string
+		wantDeletions []string
+	}{
+		{
+			name:    "video without an owner",
+			seed:    `INSERT INTO videos (id, title, user_id, video_key) VALUES ('orphan-video', 'title', 'missing-user', 'landscape/a.mp4')`,
+			stopsAt: 2,
+			wantErr: "1 videos without an existing owner (orphan-video)",
+		},
+		{
+			name:    "upload of a missing video",
+			seed:    `INSERT INTO uploads (id, expires_at, video_id, user_id, length) VALUES ('orphan-upload', CURRENT_TIMESTAMP, 'missing-video', 'missing-user', 10)`,
+			stopsAt: 3,
+			wantErr: "1 uploads of a missing video or user (orphan-upload)",
+		},
+		{
+			name:    "queued job with a staged upload",
+			seed:    `INSERT INTO jobs (id, video_id, kind, payload, status, run_at) VALUES ('orphan-job', 'missing-video', 'process_video', '{"source_path":"/tmp/staged"}', 'queued', CURRENT_TIMESTAMP)`,
+			stopsAt: 3,
+			wantErr: "1 unfinished jobs of a missing video with a staged upload (orphan-job)",
+		},
+		{
+			name: "child rows with stored objects",
+			seed: `
+			INSERT INTO thumbnail_candidates (id, video_id, position, key) VALUES ('candidate', 'missing-video', 0, 'candidates/a.jpg');
+			INSERT INTO jobs (id, video_id, kind, payload, status, run_at) VALUES ('failed-job', 'missing-video', 'process_video', '{"source_key":"uploads/a.mp4"}', 'failed', CURRENT_TIMESTAMP);
+			INSERT INTO jobs (id, video_id, kind, payload, status, run_at) VALUES ('done-job', 'missing-video', 'process_video', '{"source_path":"/tmp/staged"}', 'succeeded', CURRENT_TIMESTAMP);
+			INSERT INTO refresh_tokens (token, user_id, expires_at) VALUES ('token', 'missing-user', CURRENT_TIMESTAMP);
+			`,
+			wantDeletions: []string{"thumbnail:candidates/a.jpg", "video:uploads/a.mp4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := sqliteDSN(t)
+			c, err := database.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			applied, err := c.MigrateUp()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := c.MigrateDown(len(applied) - 1); err != nil {
+				t.Fatal(err)
+			}
+
+			raw, err := sql.Open("sqlite3", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer raw.Close()
+			if _, err := raw.Exec(tt.seed); err != nil {
+				t.Fatalf("seeding: %v", err)
+			}
+
+			_, err = c.MigrateUp()
+			if tt.stopsAt != 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("MigrateUp error = %v; want it to mention %q", err, tt.wantErr)
+				}
+				status, err := c.MigrationStatus()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if s := status[tt.stopsAt-1]; s.AppliedAt != nil {
+					t.Errorf("migration %d_%s was applied", s.Version, s.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MigrateUp: %v", err)
+			}
+
+			deletions, err := c.GetDuePendingDeletions(time.Now().Add(time.Second), 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, d := range deletions {
+				if d.ID == uuid.Nil {
+					t.Errorf("pending deletion of %s has no id", d.Key)
+				}
+				got = append(got, d.Store+":"+d.Key)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.wantDeletions) {
+				t.Errorf("pending deletions = %v; want %v", got, tt.wantDeletions)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+CREATE TABLE users (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMPTZ,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE videos (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	thumbnail_key TEXT,
+	video_key TEXT,
+	original_key TEXT,
+	hls_key TEXT,
+	dash_key TEXT,
+	sprite_vtt_key TEXT,
+	sprite_sheet_count INTEGER NOT NULL DEFAULT 0,
+	thumbnail_generated BOOLEAN NOT NULL DEFAULT FALSE,
+	thumbnail_widths TEXT NOT NULL DEFAULT '',
+	processing_status TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX videos_user_id ON videos(user_id);
+
+CREATE TABLE pending_deletions (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	store TEXT NOT NULL,
+	key TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	next_attempt_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE uploads (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMPTZ NOT NULL,
+	video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	length BIGINT NOT NULL,
+	upload_offset BIGINT NOT NULL DEFAULT 0,
+	metadata TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE jobs (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	kind TEXT NOT NULL,
+	payload TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL DEFAULT 1,
+	run_at TIMESTAMPTZ NOT NULL,
+	last_error TEXT
+);
+CREATE INDEX jobs_status_run_at ON jobs(status, run_at);
+
+CREATE TABLE thumbnail_candidates (
+	id UUID PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	video_id UUID NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	position INTEGER NOT NULL,
+	key TEXT NOT NULL
+);
+CREATE INDEX thumbnail_candidates_video_id ON thumbnail_candidates(video_id);
+
+CREATE TABLE video_media_info (
+	video_id UUID PRIMARY KEY REFERENCES videos(id) ON DELETE CASCADE,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	duration_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+	container TEXT NOT NULL DEFAULT '',
+	video_codec TEXT NOT NULL DEFAULT '',
+	video_profile TEXT NOT NULL DEFAULT '',
+	width INTEGER NOT NULL DEFAULT 0,
+	height INTEGER NOT NULL DEFAULT 0,
+	sample_aspect_ratio TEXT NOT NULL DEFAULT '',
+	frame_rate DOUBLE PRECISION NOT NULL DEFAULT 0,
+	bit_rate BIGINT NOT NULL DEFAULT 0,
+	audio_codec TEXT NOT NULL DEFAULT '',
+	audio_channels INTEGER NOT NULL DEFAULT 0,
+	audio_sample_rate INTEGER NOT NULL DEFAULT 0,
+	rotation INTEGER NOT NULL DEFAULT 0
+);
@@ -0,0 +1,2 @@
+-- Serves the default listing order of GET /api/videos.
+CREATE INDEX videos_user_id_created_at ON videos(user_id, created_at, id);
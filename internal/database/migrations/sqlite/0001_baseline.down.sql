@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS video_media_info;
+DROP TABLE IF EXISTS thumbnail_candidates;
+DROP TABLE IF EXISTS jobs;
+DROP TABLE IF EXISTS uploads;
+DROP TABLE IF EXISTS pending_deletions;
+DROP TABLE IF EXISTS videos;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,112 @@
+-- The schema as autoMigrate left it. Tables are created only if missing so
+-- databases from before versioned migrations are adopted as they are; the
+-- columns autoMigrate added later are filled in by legacyColumns.
+
+CREATE TABLE IF NOT EXISTS users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT TEXT,
+	user_id INTEGER,
+	thumbnail_key TEXT,
+	video_key TEXT,
+	original_key TEXT,
+	hls_key TEXT,
+	dash_key TEXT,
+	sprite_vtt_key TEXT,
+	sprite_sheet_count INTEGER NOT NULL DEFAULT 0,
+	thumbnail_generated BOOLEAN NOT NULL DEFAULT FALSE,
+	thumbnail_widths TEXT NOT NULL DEFAULT '',
+	processing_status TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS pending_deletions (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	store TEXT NOT NULL,
+	key TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	next_attempt_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS uploads (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	length INTEGER NOT NULL,
+	upload_offset INTEGER NOT NULL DEFAULT 0,
+	metadata TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(video_id) REFERENCES videos(id),
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+CREATE TABLE IF NOT EXISTS jobs (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	payload TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL DEFAULT 1,
+	run_at TIMESTAMP NOT NULL,
+	last_error TEXT,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
+CREATE INDEX IF NOT EXISTS jobs_status_run_at ON jobs(status, run_at);
+
+CREATE TABLE IF NOT EXISTS thumbnail_candidates (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	key TEXT NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
+CREATE INDEX IF NOT EXISTS thumbnail_candidates_video_id ON thumbnail_candidates(video_id);
+
+CREATE TABLE IF NOT EXISTS video_media_info (
+	video_id TEXT PRIMARY KEY,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	duration_seconds REAL NOT NULL DEFAULT 0,
+	container TEXT NOT NULL DEFAULT '',
+	video_codec TEXT NOT NULL DEFAULT '',
+	video_profile TEXT NOT NULL DEFAULT '',
+	width INTEGER NOT NULL DEFAULT 0,
+	height INTEGER NOT NULL DEFAULT 0,
+	sample_aspect_ratio TEXT NOT NULL DEFAULT '',
+	frame_rate REAL NOT NULL DEFAULT 0,
+	bit_rate INTEGER NOT NULL DEFAULT 0,
+	audio_codec TEXT NOT NULL DEFAULT '',
+	audio_channels INTEGER NOT NULL DEFAULT 0,
+	audio_sample_rate INTEGER NOT NULL DEFAULT 0,
+	rotation INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(video_id) REFERENCES videos(id)
+);
@@ -0,0 +1,28 @@
+CREATE TABLE videos_old (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT TEXT,
+	user_id INTEGER,
+	thumbnail_key TEXT,
+	video_key TEXT,
+	original_key TEXT,
+	hls_key TEXT,
+	dash_key TEXT,
+	sprite_vtt_key TEXT,
+	sprite_sheet_count INTEGER NOT NULL DEFAULT 0,
+	thumbnail_generated BOOLEAN NOT NULL DEFAULT FALSE,
+	thumbnail_widths TEXT NOT NULL DEFAULT '',
+	processing_status TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO videos_old (id, created_at, updated_at, title, description, thumbnail_url, video_url, user_id, thumbnail_key, video_key, original_key, hls_key, dash_key, sprite_vtt_key, sprite_sheet_count, thumbnail_generated, thumbnail_widths, processing_status)
+SELECT id, created_at, updated_at, title, description, thumbnail_url, video_url, user_id, thumbnail_key, video_key, original_key, hls_key, dash_key, sprite_vtt_key, sprite_sheet_count, thumbnail_generated, thumbnail_widths, processing_status
+FROM videos;
+
+DROP TABLE videos;
+ALTER TABLE videos_old RENAME TO videos;
@@ -0,0 +1,35 @@
+-- videos.user_id was declared INTEGER (it holds user UUIDs) and nullable, and
+-- video_url had a doubled type. SQLite can't alter column types, so the
+-- table is rebuilt. Videos without an existing owner would fail the foreign
+-- key check; the migration refuses to run while there are any (see
+-- orphanChecks), since their stored files would be left behind.
+
+CREATE TABLE videos_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT,
+	user_id TEXT NOT NULL,
+	thumbnail_key TEXT,
+	video_key TEXT,
+	original_key TEXT,
+	hls_key TEXT,
+	dash_key TEXT,
+	sprite_vtt_key TEXT,
+	sprite_sheet_count INTEGER NOT NULL DEFAULT 0,
+	thumbnail_generated BOOLEAN NOT NULL DEFAULT FALSE,
+	thumbnail_widths TEXT NOT NULL DEFAULT '',
+	processing_status TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO videos_new (id, created_at, updated_at, title, description, thumbnail_url, video_url, user_id, thumbnail_key, video_key, original_key, hls_key, dash_key, sprite_vtt_key, sprite_sheet_count, thumbnail_generated, thumbnail_widths, processing_status)
+SELECT id, created_at, updated_at, title, description, thumbnail_url, video_url, CAST(user_id AS TEXT), thumbnail_key, video_key, original_key, hls_key, dash_key, sprite_vtt_key, sprite_sheet_count, thumbnail_generated, thumbnail_widths, processing_status
+FROM videos;
+
+DROP TABLE videos;
+ALTER TABLE videos_new RENAME TO videos;
+CREATE INDEX videos_user_id ON videos(user_id);
@@ -0,0 +1,141 @@
+-- Foreign keys are enforced from here on, so rows pointing at deleted videos
+-- or users (nothing cleaned them up before) are removed, and dependent rows
+-- now go with their video or user. Stored objects those rows reference are
+-- queued in pending_deletions first; uploads and unfinished jobs holding
+-- files staged on disk stop the migration instead (see orphanChecks).
+
+DELETE FROM refresh_tokens WHERE user_id NOT IN (SELECT id FROM users);
+CREATE TABLE refresh_tokens_new (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP,
+	user_id TEXT NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+INSERT INTO refresh_tokens_new (token, created_at, updated_at, revoked_at, user_id, expires_at)
+SELECT token, created_at, updated_at, revoked_at, user_id, expires_at
+FROM refresh_tokens;
+DROP TABLE refresh_tokens;
+ALTER TABLE refresh_tokens_new RENAME TO refresh_tokens;
+
+DELETE FROM uploads WHERE video_id NOT IN (SELECT id FROM videos) OR user_id NOT IN (SELECT id FROM users);
+CREATE TABLE uploads_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	video_id TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	length INTEGER NOT NULL,
+	upload_offset INTEGER NOT NULL DEFAULT 0,
+	metadata TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE,
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+INSERT INTO uploads_new (id, created_at, updated_at, expires_at, video_id, user_id, length, upload_offset, metadata)
+SELECT id, created_at, updated_at, expires_at, video_id, user_id, length, upload_offset, metadata
+FROM uploads;
+DROP TABLE uploads;
+ALTER TABLE uploads_new RENAME TO uploads;
+
+INSERT INTO pending_deletions (id, created_at, store, key, attempts, next_attempt_at)
+SELECT lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6))), CURRENT_TIMESTAMP, 'video', json_extract(payload, '$.source_key'), 0, CURRENT_TIMESTAMP
+FROM jobs
+WHERE video_id NOT IN (SELECT id FROM videos)
+	AND json_valid(payload)
+	AND json_extract(payload, '$.source_key') IS NOT NULL;
+DELETE FROM jobs WHERE video_id NOT IN (SELECT id FROM videos);
+CREATE TABLE jobs_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	payload TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL DEFAULT 1,
+	run_at TIMESTAMP NOT NULL,
+	last_error TEXT,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+INSERT INTO jobs_new (id, created_at, updated_at, video_id, kind, payload, status, attempts, max_attempts, run_at, last_error)
+SELECT id, created_at, updated_at, video_id, kind, payload, status, attempts, max_attempts, run_at, last_error
+FROM jobs;
+DROP TABLE jobs;
+ALTER TABLE jobs_new RENAME TO jobs;
+CREATE INDEX jobs_status_run_at ON jobs(status, run_at);
+
+INSERT INTO pending_deletions (id, created_at, store, key, attempts, next_attempt_at)
+SELECT lower(hex(randomblob(4)) || '-' || hex(randomblob(2)) || '-4' || substr(hex(randomblob(2)), 2) || '-' || substr('89ab', 1 + abs(random()) % 4, 1) || substr(hex(randomblob(2)), 2) || '-' || hex(randomblob(6))), CURRENT_TIMESTAMP, 'thumbnail', key, 0, CURRENT_TIMESTAMP
+FROM thumbnail_candidates
+WHERE video_id NOT IN (SELECT id FROM videos);
+DELETE FROM thumbnail_candidates WHERE video_id NOT IN (SELECT id FROM videos);
+CREATE TABLE thumbnail_candidates_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL,
+	position INTEGER NOT NULL,
+	key TEXT NOT NULL,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+INSERT INTO thumbnail_candidates_new (id, created_at, video_id, position, key)
+SELECT id, created_at, video_id, position, key
+FROM thumbnail_candidates;
+DROP TABLE thumbnail_candidates;
+ALTER TABLE thumbnail_candidates_new RENAME TO thumbnail_candidates;
+CREATE INDEX thumbnail_candidates_video_id ON thumbnail_candidates(video_id);
+
+DELETE FROM video_media_info WHERE video_id NOT IN (SELECT id FROM videos);
+CREATE TABLE video_media_info_new (
+	video_id TEXT PRIMARY KEY,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	duration_seconds REAL NOT NULL DEFAULT 0,
+	container TEXT NOT NULL DEFAULT '',
+	video_codec TEXT NOT NULL DEFAULT '',
+	video_profile TEXT NOT NULL DEFAULT '',
+	width INTEGER NOT NULL DEFAULT 0,
+	height INTEGER NOT NULL DEFAULT 0,
+	sample_aspect_ratio TEXT NOT NULL DEFAULT '',
+	frame_rate REAL NOT NULL DEFAULT 0,
+	bit_rate INTEGER NOT NULL DEFAULT 0,
+	audio_codec TEXT NOT NULL DEFAULT '',
+	audio_channels INTEGER NOT NULL DEFAULT 0,
+	audio_sample_rate INTEGER NOT NULL DEFAULT 0,
+	rotation INTEGER NOT NULL DEFAULT 0,
+	FOREIGN KEY(video_id) REFERENCES videos(id) ON DELETE CASCADE
+);
+INSERT INTO video_media_info_new (video_id, updated_at, duration_seconds, container, video_codec, video_profile, width, height, sample_aspect_ratio, frame_rate, bit_rate, audio_codec, audio_channels, audio_sample_rate, rotation)
+SELECT video_id, updated_at, duration_seconds, container, video_codec, video_profile, width, height, sample_aspect_ratio, frame_rate, bit_rate, audio_codec, audio_channels, audio_sample_rate, rotation
+FROM video_media_info;
+DROP TABLE video_media_info;
+ALTER TABLE video_media_info_new RENAME TO video_media_info;
+CREATE TABLE videos_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	video_url TEXT,
+	user_id TEXT NOT NULL,
+	thumbnail_key TEXT,
+	video_key TEXT,
+	original_key TEXT,
+	hls_key TEXT,
+	dash_key TEXT,
+	sprite_vtt_key TEXT,
+	sprite_sheet_count INTEGER NOT NULL DEFAULT 0,
+	thumbnail_generated BOOLEAN NOT NULL DEFAULT FALSE,
+	thumbnail_widths TEXT NOT NULL DEFAULT '',
+	processing_status TEXT NOT NULL DEFAULT '',
+	FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+INSERT INTO videos_new (id, created_at, updated_at, title, description, thumbnail_url, video_url, user_id, thumbnail_key, video_key, original_key, hls_key, dash_key, sprite_vtt_key, sprite_sheet_count, thumbnail_generated, thumbnail_widths, processing_status)
+SELECT id, created_at, updated_at, title, description, thumbnail_url, video_url, user_id, thumbnail_key, video_key, original_key, hls_key, dash_key, sprite_vtt_key, sprite_sheet_count, thumbnail_generated, thumbnail_widths, processing_status
+FROM videos;
+DROP TABLE videos;
+ALTER TABLE videos_new RENAME TO videos;
+CREATE INDEX videos_user_id ON videos(user_id);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS videos_user_id_created_at;
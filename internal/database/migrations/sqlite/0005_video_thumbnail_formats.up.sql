@@ -0,0 +1,3 @@
+-- The modern formats (webp, avif) an uploaded thumbnail's siblings were
+-- produced in, comma separated.
+ALTER TABLE videos ADD COLUMN thumbnail_formats TEXT NOT NULL DEFAULT '';
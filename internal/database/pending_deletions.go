@@ -0,0 +1,115 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PendingDeletion struct {
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Store         string    `json:"store"`
+	Key           string    `json:"key"`
+	Attempts      int       `json:"attempts"`
+	LastError     *string   `json:"last_error"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+}
+
+// BlobRef names a stored object: the blob store it is in and its key.
+type BlobRef struct {
+	Store string
+	Key   string
+}
+
+func (c Client) CreatePendingDeletion(store, key string) (PendingDeletion, error) {
+	return insertPendingDeletion(c.db, store, key)
+}
+
+func insertPendingDeletion(db execQueryer, store, key string) (PendingDeletion, error) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO pending_deletions (
+		id,
+		created_at,
+		store,
+		key,
+		attempts,
+		next_attempt_at
+	) VALUES (?, ?, ?, ?, 0, ?)
+	`
+	_, err := db.Exec(query, id, now, store, key, now)
+	if err != nil {
+		return PendingDeletion{}, err
+	}
+	return PendingDeletion{
+		ID:            id,
+		CreatedAt:     now,
+		Store:         store,
+		Key:           key,
+		NextAttemptAt: now,
+	}, nil
+}
+
+func (c Client) GetDuePendingDeletions(now time.Time, limit int) ([]PendingDeletion, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		store,
+		key,
+		attempts,
+		last_error,
+		next_attempt_at
+	FROM pending_deletions
+	WHERE next_attempt_at <= ?
+	ORDER BY next_attempt_at ASC
+	LIMIT ?
+	`
+	rows, err := c.db.Query(query, now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deletions := []PendingDeletion{}
+	for rows.Next() {
+		var d PendingDeletion
+		if err := rows.Scan(
+			&d.ID,
+			&d.CreatedAt,
+			&d.Store,
+			&d.Key,
+			&d.Attempts,
+			&d.LastError,
+			&d.NextAttemptAt,
+		); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
+
+func (c Client) MarkPendingDeletionFailed(id uuid.UUID, errMsg string, nextAttemptAt time.Time) error {
+	query := `
+	UPDATE pending_deletions
+	SET
+		attempts = attempts + 1,
+		last_error = ?,
+		next_attempt_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, errMsg, nextAttemptAt.UTC(), id)
+	return err
+}
+
+func (c Client) DeletePendingDeletion(id uuid.UUID) error {
+	query := `
+	DELETE FROM pending_deletions
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
@@ -0,0 +1,96 @@
+package database
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The interfaces below are what the rest of the app depends on. Client
+// implements them on SQLite or PostgreSQL and MemoryStore in memory; both
+// must pass the storetest conformance suite.
+//
+// Lookups of a single row report a missing row as a zero value (or nil for
+// pointer results), not as an error.
+
+type UserStore interface {
+	GetUsers() ([]User, error)
+	GetUser(id uuid.UUID) (*User, error)
+	GetUserByEmail(email string) (User, error)
+	GetUserByRefreshToken(token string) (*User, error)
+	CreateUser(params CreateUserParams) (*User, error)
+	DeleteUser(id uuid.UUID) error
+}
+
+type RefreshTokenStore interface {
+	CreateRefreshToken(params CreateRefreshTokenParams) (RefreshToken, error)
+	GetRefreshToken(token string) (RefreshToken, error)
+	RevokeRefreshToken(token string) error
+	DeleteRefreshToken(token string) error
+}
+
+// VideoStore holds videos and what is recorded about them: media info and
+// generated thumbnail candidates.
+type VideoStore interface {
+	ListVideos(params ListVideosParams) (VideoPage, error)
+	GetVideo(id uuid.UUID) (Video, error)
+	CreateVideo(params CreateVideoParams) (Video, error)
+	UpdateVideo(video Video) error
+	UpdateVideoProcessingStatus(id uuid.UUID, status string) error
+	SetThumbnailFormats(id uuid.UUID, thumbnailKey, formats string) (bool, error)
+	DeleteVideo(id uuid.UUID) error
+	DeleteVideoQueueingBlobs(id uuid.UUID, blobs []BlobRef) error
+	MigrateVideoURLsToKeys(videoKeyFromURL, thumbnailKeyFromURL func(string) (string, bool)) (int, error)
+
+	SaveMediaInfo(info MediaInfo) error
+	GetMediaInfo(videoID uuid.UUID) (*MediaInfo, error)
+	DeleteMediaInfo(videoID uuid.UUID) error
+
+	ReplaceThumbnailCandidates(videoID uuid.UUID, candidates []ThumbnailCandidate) ([]ThumbnailCandidate, error)
+	GetThumbnailCandidates(videoID uuid.UUID) ([]ThumbnailCandidate, error)
+	GetThumbnailCandidate(id uuid.UUID) (ThumbnailCandidate, error)
+	DeleteThumbnailCandidates(videoID uuid.UUID) error
+}
+
+type UploadStore interface {
+	CreateUpload(params CreateUploadParams) (Upload, error)
+	GetUpload(id uuid.UUID) (Upload, error)
+	UpdateUploadOffset(id uuid.UUID, offset int64) error
+	GetExpiredUploads(now time.Time) ([]Upload, error)
+	DeleteUpload(id uuid.UUID) error
+}
+
+type JobStore interface {
+	CreateJob(params CreateJobParams) (Job, error)
+	GetJob(id uuid.UUID) (Job, error)
+	ClaimNextJob(now time.Time) (Job, error)
+	CompleteJob(id uuid.UUID) error
+	FailJob(id uuid.UUID, errMsg string, retryAt *time.Time) error
+	RequeueRunningJobs() (int64, []Job, error)
+}
+
+type PendingDeletionStore interface {
+	CreatePendingDeletion(store, key string) (PendingDeletion, error)
+	GetDuePendingDeletions(now time.Time, limit int) ([]PendingDeletion, error)
+	MarkPendingDeletionFailed(id uuid.UUID, errMsg string, nextAttemptAt time.Time) error
+	DeletePendingDeletion(id uuid.UUID) error
+}
+
+// Store is everything the app persists. Deleting a user or video also
+// deletes what belongs to it.
+type Store interface {
+	UserStore
+	RefreshTokenStore
+	VideoStore
+	UploadStore
+	JobStore
+	PendingDeletionStore
+
+	// Reset deletes all data
+	Reset() error
+}
+
+var (
+	_ Store = Client{}
+	_ Store = (*MemoryStore)(nil)
+)
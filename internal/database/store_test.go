@@ -0,0 +1,85 @@
+package database_test
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database/storetest"
+	"github.com/google/uuid"
+)
+
+// sqliteDSN is a fresh SQLite database file for t.
+func sqliteDSN(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "tubely.db")
+}
+
+// postgresDSN is a fresh schema in the database at TEST_POSTGRES_URL, dropped
+// when t ends. Tests needing it are skipped when the variable isn't set.
+func postgresDSN(t *testing.T) string {
+	t.Helper()
+	base := os.Getenv("TEST_POSTGRES_URL")
+	if base == "" {
+		t.Skip("TEST_POSTGRES_URL isn't set")
+	}
+	db, err := sql.Open("postgres", base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	schema := "test_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := db.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		db, err := sql.Open("postgres", base)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer db.Close()
+		if _, err := db.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+			t.Errorf("dropping schema: %v", err)
+		}
+	})
+
+	sep := "?"
+	if strings.Contains(base, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%ssearch_path=%s", base, sep, schema)
+}
+
+func newClient(t *testing.T, dsn string) database.Client {
+	t.Helper()
+	c, err := database.NewClient(dsn)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) database.Store {
+		return database.NewMemoryStore()
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) database.Store {
+		return newClient(t, sqliteDSN(t))
+	})
+}
+
+func TestPostgresStore(t *testing.T) {
+	postgresDSN(t) // skips without a server
+	storetest.Run(t, func(t *testing.T) database.Store {
+		return newClient(t, postgresDSN(t))
+	})
+}
@@ -0,0 +1,760 @@
+// Package storetest is the conformance suite for database.Store
+// implementations. A backend's tests call Run with a constructor for empty
+// stores:
+//
+//	func TestMemoryStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) database.Store {
+//			return database.NewMemoryStore()
+//		})
+//	}
+package storetest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// Run checks the behavior every Store must share. newStore is called once
+// per subtest and must return an empty store implementing Backdater.
+func Run(t *testing.T, newStore func(t *testing.T) database.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s database.Store)
+	}{
+		{"Users", testUsers},
+		{"RefreshTokens", testRefreshTokens},
+		{"Videos", testVideos},
+		{"VideoOrder", testVideoOrder},
+		{"VideoPagination", testVideoPagination},
+		{"VideoForeignKeys", testVideoForeignKeys},
+		{"MigrateVideoURLsToKeys", testMigrateVideoURLsToKeys},
+		{"MediaInfo", testMediaInfo},
+		{"ThumbnailCandidates", testThumbnailCandidates},
+		{"Uploads", testUploads},
+		{"Jobs", testJobs},
+		{"PendingDeletions", testPendingDeletions},
+		{"DeleteVideoCascades", testDeleteVideoCascades},
+		{"DeleteVideoQueueingBlobs", testDeleteVideoQueueingBlobs},
+		{"DeleteUserCascades", testDeleteUserCascades},
+		{"Reset", testReset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+// Backdater moves a video's timestamps d into the past, so checks on them
+// don't have to wait for a clock that may only count seconds.
+type Backdater interface {
+	BackdateVideo(id uuid.UUID, d time.Duration) error
+}
+
+func backdateVideo(t *testing.T, s database.Store, id uuid.UUID) {
+	t.Helper()
+	b, ok := s.(Backdater)
+	if !ok {
+		t.Fatalf("%T doesn't implement Backdater", s)
+	}
+	if err := b.BackdateVideo(id, time.Minute); err != nil {
+		t.Fatalf("BackdateVideo: %v", err)
+	}
+}
+
+func mustUser(t *testing.T, s database.Store, email string) *database.User {
+	t.Helper()
+	user, err := s.CreateUser(database.CreateUserParams{Email: email, Password: "hash"})
+	if err != nil {
+		t.Fatalf("CreateUser(%q): %v", email, err)
+	}
+	return user
+}
+
+func mustVideo(t *testing.T, s database.Store, userID uuid.UUID, title string) database.Video {
+	t.Helper()
+	video, err := s.CreateVideo(database.CreateVideoParams{Title: title, Description: "d", UserID: userID})
+	if err != nil {
+		t.Fatalf("CreateVideo(%q): %v", title, err)
+	}
+	return video
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+func testUsers(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	if user.ID == uuid.Nil || user.Email != "a@example.com" || user.Password != "hash" {
+		t.Fatalf("CreateUser returned %+v", user)
+	}
+	if user.CreatedAt.IsZero() {
+		t.Errorf("CreateUser didn't set CreatedAt")
+	}
+
+	if _, err := s.CreateUser(database.CreateUserParams{Email: "a@example.com", Password: "x"}); err == nil {
+		t.Errorf("CreateUser with a duplicate email succeeded")
+	}
+
+	got, err := s.GetUser(user.ID)
+	if err != nil || got == nil || got.ID != user.ID || got.Email != user.Email {
+		t.Errorf("GetUser = %+v, %v", got, err)
+	}
+	byEmail, err := s.GetUserByEmail("a@example.com")
+	if err != nil || byEmail.ID != user.ID || byEmail.Password != "hash" {
+		t.Errorf("GetUserByEmail = %+v, %v", byEmail, err)
+	}
+
+	missing, err := s.GetUser(uuid.New())
+	if err != nil || missing != nil {
+		t.Errorf("GetUser of a missing user = %+v, %v; want nil, nil", missing, err)
+	}
+	missingByEmail, err := s.GetUserByEmail("nobody@example.com")
+	if err != nil || missingByEmail.ID != uuid.Nil {
+		t.Errorf("GetUserByEmail of a missing user = %+v, %v; want zero, nil", missingByEmail, err)
+	}
+
+	mustUser(t, s, "b@example.com")
+	users, err := s.GetUsers()
+	if err != nil || len(users) != 2 {
+		t.Fatalf("GetUsers = %d users, %v; want 2", len(users), err)
+	}
+
+	if err := s.DeleteUser(user.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if got, _ := s.GetUser(user.ID); got != nil {
+		t.Errorf("user still exists after DeleteUser")
+	}
+}
+
+func testRefreshTokens(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	rt, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "tok", UserID: user.ID, ExpiresAt: expires})
+	if err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+	if rt.Token != "tok" || rt.UserID != user.ID || !rt.ExpiresAt.Equal(expires) || rt.RevokedAt != nil {
+		t.Errorf("CreateRefreshToken returned %+v", rt)
+	}
+	if _, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "other", UserID: uuid.New(), ExpiresAt: expires}); err == nil {
+		t.Errorf("CreateRefreshToken for a missing user succeeded")
+	}
+
+	owner, err := s.GetUserByRefreshToken("tok")
+	if err != nil || owner == nil || owner.ID != user.ID {
+		t.Errorf("GetUserByRefreshToken = %+v, %v", owner, err)
+	}
+	if owner, err := s.GetUserByRefreshToken("missing"); err != nil || owner != nil {
+		t.Errorf("GetUserByRefreshToken of a missing token = %+v, %v; want nil, nil", owner, err)
+	}
+
+	if err := s.RevokeRefreshToken("tok"); err != nil {
+		t.Fatalf("RevokeRefreshToken: %v", err)
+	}
+	rt, err = s.GetRefreshToken("tok")
+	if err != nil || rt.RevokedAt == nil {
+		t.Errorf("GetRefreshToken after revoking = %+v, %v; want RevokedAt set", rt, err)
+	}
+
+	if err := s.DeleteRefreshToken("tok"); err != nil {
+		t.Fatalf("DeleteRefreshToken: %v", err)
+	}
+	if rt, err := s.GetRefreshToken("tok"); err != nil || rt.Token != "" {
+		t.Errorf("GetRefreshToken after deleting = %+v, %v; want zero, nil", rt, err)
+	}
+}
+
+func testVideos(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	video := mustVideo(t, s, user.ID, "first")
+	if video.ID == uuid.Nil || video.Title != "first" || video.Description != "d" || video.UserID != user.ID {
+		t.Fatalf("CreateVideo returned %+v", video)
+	}
+	if video.ProcessingStatus != "" || video.VideoKey != nil || video.ThumbnailKey != nil {
+		t.Errorf("new video isn't empty: %+v", video)
+	}
+
+	if got, err := s.GetVideo(uuid.New()); err != nil || got.ID != uuid.Nil {
+		t.Errorf("GetVideo of a missing video = %+v, %v; want zero, nil", got, err)
+	}
+
+	video.Title = "renamed"
+	video.VideoKey = ptr("landscape/abc.mp4")
+	video.ThumbnailKey = ptr("thumbnails/abc/640w.png")
+	video.ThumbnailGenerated = true
+	video.ThumbnailWidths = "320,640"
+	video.ThumbnailFormats = "webp"
+	video.OriginalKey = ptr("landscape/abc/original.mov")
+	video.HLSKey = ptr("landscape/abc/hls/master.m3u8")
+	video.DASHKey = ptr("landscape/abc/dash/manifest.mpd")
+	video.SpriteVTTKey = ptr("landscape/abc/sprites/sprites.vtt")
+	video.SpriteSheetCount = 3
+	video.ProcessingStatus = database.VideoStatusReady
+	backdateVideo(t, s, video.ID)
+	backdated, err := s.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if err := s.UpdateVideo(video); err != nil {
+		t.Fatalf("UpdateVideo: %v", err)
+	}
+
+	got, err := s.GetVideo(video.ID)
+	if err != nil {
+		t.Fatalf("GetVideo: %v", err)
+	}
+	if !got.UpdatedAt.After(backdated.UpdatedAt) || !got.CreatedAt.Equal(backdated.CreatedAt) {
+		t.Errorf("after UpdateVideo, CreatedAt, UpdatedAt = %v, %v; want %v and a later time", got.CreatedAt, got.UpdatedAt, backdated.CreatedAt)
+	}
+	checks := []struct {
+		field     string
+		got, want any
+	}{
+		{"Title", got.Title, "renamed"},
+		{"VideoKey", deref(got.VideoKey), "landscape/abc.mp4"},
+		{"ThumbnailKey", deref(got.ThumbnailKey), "thumbnails/abc/640w.png"},
+		{"ThumbnailGenerated", got.ThumbnailGenerated, true},
+		{"ThumbnailWidths", got.ThumbnailWidths, "320,640"},
+		{"ThumbnailFormats", got.ThumbnailFormats, "webp"},
+		{"OriginalKey", deref(got.OriginalKey), "landscape/abc/original.mov"},
+		{"HLSKey", deref(got.HLSKey), "landscape/abc/hls/master.m3u8"},
+		{"DASHKey", deref(got.DASHKey), "landscape/abc/dash/manifest.mpd"},
+		{"SpriteVTTKey", deref(got.SpriteVTTKey), "landscape/abc/sprites/sprites.vtt"},
+		{"SpriteSheetCount", got.SpriteSheetCount, 3},
+		{"ProcessingStatus", got.ProcessingStatus, database.VideoStatusReady},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("after UpdateVideo, %s = %v; want %v", c.field, c.got, c.want)
+		}
+	}
+
+	if ok, err := s.SetThumbnailFormats(video.ID, "thumbnails/abc/640w.png", "webp,avif"); err != nil || !ok {
+		t.Fatalf("SetThumbnailFormats = %v, %v; want true", ok, err)
+	}
+	if ok, err := s.SetThumbnailFormats(video.ID, "thumbnails/replaced/640w.png", ""); err != nil || ok {
+		t.Fatalf("SetThumbnailFormats for a replaced thumbnail = %v, %v; want false", ok, err)
+	}
+	if got, _ := s.GetVideo(video.ID); got.ThumbnailFormats != "webp,avif" {
+		t.Errorf("ThumbnailFormats = %q; want %q", got.ThumbnailFormats, "webp,avif")
+	}
+
+	if err := s.UpdateVideoProcessingStatus(video.ID, database.VideoStatusFailed); err != nil {
+		t.Fatalf("UpdateVideoProcessingStatus: %v", err)
+	}
+	if got, _ := s.GetVideo(video.ID); got.ProcessingStatus != database.VideoStatusFailed {
+		t.Errorf("ProcessingStatus = %q; want %q", got.ProcessingStatus, database.VideoStatusFailed)
+	}
+
+	if err := s.DeleteVideo(video.ID); err != nil {
+		t.Fatalf("DeleteVideo: %v", err)
+	}
+	if got, _ := s.GetVideo(video.ID); got.ID != uuid.Nil {
+		t.Errorf("video still exists after DeleteVideo")
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return "<nil>"
+	}
+	return *s
+}
+
+func testVideoOrder(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	other := mustUser(t, s, "b@example.com")
+	old := mustVideo(t, s, user.ID, "old")
+	backdateVideo(t, s, old.ID)
+	mustVideo(t, s, user.ID, "new")
+	mustVideo(t, s, other.ID, "someone else's")
+
+	page, err := s.ListVideos(database.ListVideosParams{UserID: user.ID, Limit: 10})
+	if err != nil {
+		t.Fatalf("ListVideos: %v", err)
+	}
+	if got := titles(page.Videos); got != "new,old" || page.Next != nil {
+		t.Errorf("ListVideos = %s, next %v; want new,old and no next page", got, page.Next)
+	}
+
+	page, err = s.ListVideos(database.ListVideosParams{UserID: uuid.New(), Limit: 10})
+	if err != nil || page.Videos == nil || len(page.Videos) != 0 {
+		t.Errorf("ListVideos for a user without videos = %v, %v; want empty, nil", page.Videos, err)
+	}
+
+	if _, err := s.ListVideos(database.ListVideosParams{UserID: user.ID, Limit: 10, Sort: "views"}); !errors.Is(err, database.ErrInvalidListParams) {
+		t.Errorf("ListVideos with an unknown sort = %v; want ErrInvalidListParams", err)
+	}
+}
+
+func titles(videos []database.Video) string {
+	names := []string{}
+	for _, v := range videos {
+		names = append(names, v.Title)
+	}
+	return strings.Join(names, ",")
+}
+
+// listAll pages through a listing limit videos at a time.
+func listAll(t *testing.T, s database.Store, params database.ListVideosParams, limit int) []database.Video {
+	t.Helper()
+	params.Limit = limit
+	videos := []database.Video{}
+	for range 100 {
+		page, err := s.ListVideos(params)
+		if err != nil {
+			t.Fatalf("ListVideos: %v", err)
+		}
+		if len(page.Videos) > limit {
+			t.Fatalf("ListVideos returned %d videos; limit is %d", len(page.Videos), limit)
+		}
+		videos = append(videos, page.Videos...)
+		if page.Next == nil {
+			return videos
+		}
+		params.After = page.Next
+	}
+	t.Fatalf("ListVideos never reached the last page")
+	return nil
+}
+
+func testVideoPagination(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	durations := map[string]float64{"c": 30, "a": 10, "e": 10, "b": 0, "d": 20}
+	for _, title := range []string{"c", "a", "e", "b", "d"} {
+		video := mustVideo(t, s, user.ID, title)
+		if durations[title] > 0 {
+			if err := s.SaveMediaInfo(database.MediaInfo{VideoID: video.ID, DurationSeconds: durations[title]}); err != nil {
+				t.Fatalf("SaveMediaInfo: %v", err)
+			}
+		}
+		if title == "a" || title == "b" {
+			video.VideoKey = ptr("landscape/" + title + ".mp4")
+			if err := s.UpdateVideo(video); err != nil {
+				t.Fatalf("UpdateVideo: %v", err)
+			}
+		}
+	}
+
+	for _, sort := range []string{database.VideoSortCreated, database.VideoSortUpdated, database.VideoSortTitle, database.VideoSortDuration} {
+		for _, ascending := range []bool{true, false} {
+			params := database.ListVideosParams{UserID: user.ID, Sort: sort, Ascending: ascending}
+			want := titles(listAll(t, s, params, 10))
+			for _, limit := range []int{1, 2, 4} {
+				if got := titles(listAll(t, s, params, limit)); got != want {
+					t.Errorf("sort %s ascending=%v: pages of %d = %s; one page = %s", sort, ascending, limit, got, want)
+				}
+			}
+		}
+	}
+
+	byTitle := listAll(t, s, database.ListVideosParams{UserID: user.ID, Sort: database.VideoSortTitle, Ascending: true}, 2)
+	if got := titles(byTitle); got != "a,b,c,d,e" {
+		t.Errorf("ascending by title = %s; want a,b,c,d,e", got)
+	}
+	byDuration := titles(listAll(t, s, database.ListVideosParams{UserID: user.ID, Sort: database.VideoSortDuration}, 2))
+	if !strings.HasPrefix(byDuration, "c,d,") || !strings.HasSuffix(byDuration, ",b") {
+		t.Errorf("descending by duration = %s; want c,d first and b (no media info) last", byDuration)
+	}
+
+	yes, no := true, false
+	withVideo := listAll(t, s, database.ListVideosParams{UserID: user.ID, Sort: database.VideoSortTitle, Ascending: true, HasVideo: &yes}, 1)
+	if got := titles(withVideo); got != "a,b" {
+		t.Errorf("has video = %s; want a,b", got)
+	}
+	withoutVideo := listAll(t, s, database.ListVideosParams{UserID: user.ID, Sort: database.VideoSortTitle, Ascending: true, HasVideo: &no}, 1)
+	if got := titles(withoutVideo); got != "c,d,e" {
+		t.Errorf("has no video = %s; want c,d,e", got)
+	}
+	if got := listAll(t, s, database.ListVideosParams{UserID: user.ID, HasThumbnail: &yes}, 10); len(got) != 0 {
+		t.Errorf("has thumbnail = %s; want none", titles(got))
+	}
+
+	created := byTitle[0].CreatedAt
+	after := created.Add(-time.Hour)
+	before := created.Add(time.Hour)
+	if got := listAll(t, s, database.ListVideosParams{UserID: user.ID, CreatedAfter: &after, CreatedBefore: &before}, 10); len(got) != 5 {
+		t.Errorf("created within the hour around now = %d videos; want 5", len(got))
+	}
+	if got := listAll(t, s, database.ListVideosParams{UserID: user.ID, CreatedAfter: &before}, 10); len(got) != 0 {
+		t.Errorf("created after an hour from now = %d videos; want 0", len(got))
+	}
+	if got := listAll(t, s, database.ListVideosParams{UserID: user.ID, CreatedBefore: &after}, 10); len(got) != 0 {
+		t.Errorf("created before an hour ago = %d videos; want 0", len(got))
+	}
+}
+
+func testVideoForeignKeys(t *testing.T, s database.Store) {
+	if _, err := s.CreateVideo(database.CreateVideoParams{Title: "orphan", UserID: uuid.New()}); err == nil {
+		t.Errorf("CreateVideo for a missing user succeeded")
+	}
+	if err := s.SaveMediaInfo(database.MediaInfo{VideoID: uuid.New()}); err == nil {
+		t.Errorf("SaveMediaInfo for a missing video succeeded")
+	}
+	if _, err := s.CreateJob(database.CreateJobParams{VideoID: uuid.New(), Kind: "process", MaxAttempts: 1}); err == nil {
+		t.Errorf("CreateJob for a missing video succeeded")
+	}
+}
+
+func testMigrateVideoURLsToKeys(t *testing.T, s database.Store) {
+	// New videos never have URLs, so this only checks nothing is rewritten
+	user := mustUser(t, s, "a@example.com")
+	mustVideo(t, s, user.ID, "v")
+	n, err := s.MigrateVideoURLsToKeys(
+		func(string) (string, bool) { return "k", true },
+		func(string) (string, bool) { return "k", true },
+	)
+	if err != nil || n != 0 {
+		t.Errorf("MigrateVideoURLsToKeys = %d, %v; want 0, nil", n, err)
+	}
+}
+
+func testMediaInfo(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	video := mustVideo(t, s, user.ID, "v")
+
+	if info, err := s.GetMediaInfo(video.ID); err != nil || info != nil {
+		t.Errorf("GetMediaInfo before saving = %+v, %v; want nil, nil", info, err)
+	}
+
+	info := database.MediaInfo{
+		VideoID:           video.ID,
+		DurationSeconds:   12.5,
+		Container:         "mov,mp4,m4a,3gp,3g2,mj2",
+		VideoCodec:        "h264",
+		VideoProfile:      "High",
+		Width:             1920,
+		Height:            1080,
+		SampleAspectRatio: "1:1",
+		FrameRate:         29.97,
+		BitRate:           5_000_000,
+		AudioCodec:        "aac",
+		AudioChannels:     2,
+		AudioSampleRate:   48000,
+		Rotation:          90,
+	}
+	if err := s.SaveMediaInfo(info); err != nil {
+		t.Fatalf("SaveMediaInfo: %v", err)
+	}
+	info.Width = 1280
+	if err := s.SaveMediaInfo(info); err != nil {
+		t.Fatalf("SaveMediaInfo over existing info: %v", err)
+	}
+
+	got, err := s.GetMediaInfo(video.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetMediaInfo = %+v, %v", got, err)
+	}
+	got.UpdatedAt = time.Time{}
+	if *got != info {
+		t.Errorf("GetMediaInfo = %+v; want %+v", *got, info)
+	}
+
+	if err := s.DeleteMediaInfo(video.ID); err != nil {
+		t.Fatalf("DeleteMediaInfo: %v", err)
+	}
+	if got, _ := s.GetMediaInfo(video.ID); got != nil {
+		t.Errorf("media info still exists after DeleteMediaInfo")
+	}
+}
+
+func testThumbnailCandidates(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	video := mustVideo(t, s, user.ID, "v")
+
+	first := []database.ThumbnailCandidate{
+		{Position: 50, Key: "generated/b.jpg"},
+		{Position: 10, Key: "generated/a.jpg"},
+	}
+	old, err := s.ReplaceThumbnailCandidates(video.ID, first)
+	if err != nil || len(old) != 0 {
+		t.Fatalf("first ReplaceThumbnailCandidates = %v, %v; want none replaced", old, err)
+	}
+
+	candidates, err := s.GetThumbnailCandidates(video.ID)
+	if err != nil || len(candidates) != 2 {
+		t.Fatalf("GetThumbnailCandidates = %v, %v; want 2", candidates, err)
+	}
+	if candidates[0].Position != 10 || candidates[1].Position != 50 {
+		t.Errorf("candidates aren't ordered by position: %+v", candidates)
+	}
+	one, err := s.GetThumbnailCandidate(candidates[1].ID)
+	if err != nil || one.Key != "generated/b.jpg" || one.VideoID != video.ID {
+		t.Errorf("GetThumbnailCandidate = %+v, %v", one, err)
+	}
+
+	old, err = s.ReplaceThumbnailCandidates(video.ID, []database.ThumbnailCandidate{{Position: 25, Key: "generated/c.jpg"}})
+	if err != nil || len(old) != 2 {
+		t.Fatalf("second ReplaceThumbnailCandidates = %v, %v; want the 2 old ones", old, err)
+	}
+	if got, _ := s.GetThumbnailCandidate(candidates[0].ID); got.ID != uuid.Nil {
+		t.Errorf("replaced candidate still exists")
+	}
+
+	if err := s.DeleteThumbnailCandidates(video.ID); err != nil {
+		t.Fatalf("DeleteThumbnailCandidates: %v", err)
+	}
+	if candidates, _ := s.GetThumbnailCandidates(video.ID); len(candidates) != 0 {
+		t.Errorf("candidates remain after DeleteThumbnailCandidates: %v", candidates)
+	}
+}
+
+func testUploads(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	video := mustVideo(t, s, user.ID, "v")
+
+	upload, err := s.CreateUpload(database.CreateUploadParams{
+		VideoID:  video.ID,
+		UserID:   user.ID,
+		Length:   1000,
+		Metadata: "filetype dmlkZW8vbXA0",
+		TTL:      time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+	if upload.Offset != 0 || upload.Length != 1000 || upload.VideoID != video.ID || upload.UserID != user.ID {
+		t.Errorf("CreateUpload returned %+v", upload)
+	}
+	if d := upload.ExpiresAt.Sub(upload.CreatedAt); d < 59*time.Minute || d > 61*time.Minute {
+		t.Errorf("upload expires %v after creation; want 1h", d)
+	}
+
+	if err := s.UpdateUploadOffset(upload.ID, 400); err != nil {
+		t.Fatalf("UpdateUploadOffset: %v", err)
+	}
+	got, err := s.GetUpload(upload.ID)
+	if err != nil || got.Offset != 400 || got.Metadata != "filetype dmlkZW8vbXA0" {
+		t.Errorf("GetUpload = %+v, %v", got, err)
+	}
+
+	if expired, err := s.GetExpiredUploads(time.Now()); err != nil || len(expired) != 0 {
+		t.Errorf("GetExpiredUploads(now) = %v, %v; want none", expired, err)
+	}
+	expired, err := s.GetExpiredUploads(time.Now().Add(2 * time.Hour))
+	if err != nil || len(expired) != 1 || expired[0].ID != upload.ID {
+		t.Errorf("GetExpiredUploads(+2h) = %v, %v; want the upload", expired, err)
+	}
+
+	if err := s.DeleteUpload(upload.ID); err != nil {
+		t.Fatalf("DeleteUpload: %v", err)
+	}
+	if got, _ := s.GetUpload(upload.ID); got.ID != uuid.Nil {
+		t.Errorf("upload still exists after DeleteUpload")
+	}
+}
+
+func testJobs(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	video := mustVideo(t, s, user.ID, "v")
+
+	job, err := s.CreateJob(database.CreateJobParams{VideoID: video.ID, Kind: "process", Payload: "{}", MaxAttempts: 3})
+	if err != nil {
+		t.Fatalf("CreateJob: %v", err)
+	}
+	if job.Status != database.JobStatusQueued || job.Attempts != 0 || job.Payload != "{}" {
+		t.Errorf("CreateJob returned %+v", job)
+	}
+
+	if got, err := s.ClaimNextJob(time.Now().Add(-time.Hour)); err != nil || got.ID != uuid.Nil {
+		t.Errorf("ClaimNextJob before the job is due = %+v, %v; want zero", got, err)
+	}
+	claimed, err := s.ClaimNextJob(time.Now().Add(time.Second))
+	if err != nil || claimed.ID != job.ID || claimed.Status != database.JobStatusRunning || claimed.Attempts != 1 {
+		t.Fatalf("ClaimNextJob = %+v, %v", claimed, err)
+	}
+	if got, _ := s.ClaimNextJob(time.Now().Add(time.Second)); got.ID != uuid.Nil {
+		t.Errorf("a running job was claimed again")
+	}
+
+	retryAt := time.Now().Add(time.Minute)
+	if err := s.FailJob(job.ID, "boom", &retryAt); err != nil {
+		t.Fatalf("FailJob: %v", err)
+	}
+	got, _ := s.GetJob(job.ID)
+	if got.Status != database.JobStatusQueued || got.LastError == nil || *got.LastError != "boom" {
+		t.Errorf("after a retryable FailJob, job = %+v", got)
+	}
+	if got, _ := s.ClaimNextJob(time.Now()); got.ID != uuid.Nil {
+		t.Errorf("job was claimed before its retry time")
+	}
+	if _, err := s.ClaimNextJob(retryAt.Add(time.Second)); err != nil {
+		t.Fatalf("ClaimNextJob after retry time: %v", err)
+	}
+
+	if n, failed, err := s.RequeueRunningJobs(); err != nil || n != 1 || len(failed) != 0 {
+		t.Errorf("RequeueRunningJobs = %d, %v, %v; want 1 requeued", n, failed, err)
+	}
+	if _, err := s.ClaimNextJob(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("ClaimNextJob after requeue: %v", err)
+	}
+
+	// interrupted at its last attempt, the job fails instead of looping
+	n, failed, err := s.RequeueRunningJobs()
+	if err != nil || n != 0 || len(failed) != 1 || failed[0].ID != job.ID || failed[0].Status != database.JobStatusFailed {
+		t.Fatalf("RequeueRunningJobs at the last attempt = %d, %v, %v; want the job failed", n, failed, err)
+	}
+	got, _ = s.GetJob(job.ID)
+	if got.Status != database.JobStatusFailed || got.Attempts != 3 || got.LastError == nil {
+		t.Errorf("after requeueing at the last attempt, job = %+v", got)
+	}
+
+	if err := s.CompleteJob(job.ID); err != nil {
+		t.Fatalf("CompleteJob: %v", err)
+	}
+	got, _ = s.GetJob(job.ID)
+	if got.Status != database.JobStatusSucceeded || got.LastError != nil || got.Attempts != 3 {
+		t.Errorf("after CompleteJob, job = %+v", got)
+	}
+
+	if err := s.FailJob(job.ID, "fatal", nil); err != nil {
+		t.Fatalf("FailJob: %v", err)
+	}
+	if got, _ := s.GetJob(job.ID); got.Status != database.JobStatusFailed {
+		t.Errorf("after a final FailJob, status = %q", got.Status)
+	}
+}
+
+func testPendingDeletions(t *testing.T, s database.Store) {
+	first, err := s.CreatePendingDeletion("video", "a.mp4")
+	if err != nil {
+		t.Fatalf("CreatePendingDeletion: %v", err)
+	}
+	if _, err := s.CreatePendingDeletion("thumbnail", "b.png"); err != nil {
+		t.Fatalf("CreatePendingDeletion: %v", err)
+	}
+
+	due, err := s.GetDuePendingDeletions(time.Now().Add(time.Second), 10)
+	if err != nil || len(due) != 2 {
+		t.Fatalf("GetDuePendingDeletions = %v, %v; want 2", due, err)
+	}
+	if due, _ := s.GetDuePendingDeletions(time.Now().Add(time.Second), 1); len(due) != 1 {
+		t.Errorf("GetDuePendingDeletions ignored its limit: %v", due)
+	}
+
+	next := time.Now().Add(time.Hour)
+	if err := s.MarkPendingDeletionFailed(first.ID, "denied", next); err != nil {
+		t.Fatalf("MarkPendingDeletionFailed: %v", err)
+	}
+	due, _ = s.GetDuePendingDeletions(time.Now().Add(time.Second), 10)
+	if len(due) != 1 || due[0].Key != "b.png" {
+		t.Errorf("a failed deletion is due before its next attempt: %v", due)
+	}
+	due, _ = s.GetDuePendingDeletions(next.Add(time.Second), 10)
+	if len(due) != 2 || due[1].ID != first.ID || due[1].Attempts != 1 || due[1].LastError == nil {
+		t.Errorf("retried deletion = %+v; want last, with 1 attempt and an error", due)
+	}
+
+	if err := s.DeletePendingDeletion(first.ID); err != nil {
+		t.Fatalf("DeletePendingDeletion: %v", err)
+	}
+	if due, _ := s.GetDuePendingDeletions(next.Add(time.Second), 10); len(due) != 1 {
+		t.Errorf("deletion remains after DeletePendingDeletion: %v", due)
+	}
+}
+
+func testDeleteVideoCascades(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	video := mustVideo(t, s, user.ID, "v")
+
+	if err := s.SaveMediaInfo(database.MediaInfo{VideoID: video.ID, Width: 640, Height: 360}); err != nil {
+		t.Fatalf("SaveMediaInfo: %v", err)
+	}
+	if _, err := s.ReplaceThumbnailCandidates(video.ID, []database.ThumbnailCandidate{{Position: 10, Key: "k"}}); err != nil {
+		t.Fatalf("ReplaceThumbnailCandidates: %v", err)
+	}
+	job, err := s.CreateJob(database.CreateJobParams{VideoID: video.ID, Kind: "process", MaxAttempts: 1})
+	if err != nil {
+		t.Fatalf("CreateJob: %v", err)
+	}
+	upload, err := s.CreateUpload(database.CreateUploadParams{VideoID: video.ID, UserID: user.ID, Length: 1, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("CreateUpload: %v", err)
+	}
+
+	if err := s.DeleteVideo(video.ID); err != nil {
+		t.Fatalf("DeleteVideo: %v", err)
+	}
+	if info, _ := s.GetMediaInfo(video.ID); info != nil {
+		t.Errorf("media info survived DeleteVideo")
+	}
+	if candidates, _ := s.GetThumbnailCandidates(video.ID); len(candidates) != 0 {
+		t.Errorf("candidates survived DeleteVideo")
+	}
+	if got, _ := s.GetJob(job.ID); got.ID != uuid.Nil {
+		t.Errorf("job survived DeleteVideo")
+	}
+	if got, _ := s.GetUpload(upload.ID); got.ID != uuid.Nil {
+		t.Errorf("upload survived DeleteVideo")
+	}
+}
+
+func testDeleteVideoQueueingBlobs(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	video := mustVideo(t, s, user.ID, "v")
+	blobs := []database.BlobRef{{Store: "video", Key: "a.mp4"}, {Store: "thumbnail", Key: "a.png"}}
+
+	if err := s.DeleteVideoQueueingBlobs(video.ID, blobs); err != nil {
+		t.Fatalf("DeleteVideoQueueingBlobs: %v", err)
+	}
+	if got, _ := s.GetVideo(video.ID); got.ID != uuid.Nil {
+		t.Errorf("video survived DeleteVideoQueueingBlobs")
+	}
+	due, err := s.GetDuePendingDeletions(time.Now().Add(time.Second), 10)
+	if err != nil || len(due) != 2 {
+		t.Fatalf("GetDuePendingDeletions = %v, %v; want the 2 blobs", due, err)
+	}
+	for i, d := range due {
+		if d.Store != blobs[i].Store || d.Key != blobs[i].Key {
+			t.Errorf("pending deletion %d = %s %q; want %s %q", i, d.Store, d.Key, blobs[i].Store, blobs[i].Key)
+		}
+	}
+}
+
+func testDeleteUserCascades(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	video := mustVideo(t, s, user.ID, "v")
+	if _, err := s.CreateRefreshToken(database.CreateRefreshTokenParams{Token: "tok", UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("CreateRefreshToken: %v", err)
+	}
+
+	if err := s.DeleteUser(user.ID); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if got, _ := s.GetVideo(video.ID); got.ID != uuid.Nil {
+		t.Errorf("video survived DeleteUser")
+	}
+	if rt, _ := s.GetRefreshToken("tok"); rt.Token != "" {
+		t.Errorf("refresh token survived DeleteUser")
+	}
+}
+
+func testReset(t *testing.T, s database.Store) {
+	user := mustUser(t, s, "a@example.com")
+	mustVideo(t, s, user.ID, "v")
+	if _, err := s.CreatePendingDeletion("video", "k"); err != nil {
+		t.Fatalf("CreatePendingDeletion: %v", err)
+	}
+
+	if err := s.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if users, _ := s.GetUsers(); len(users) != 0 {
+		t.Errorf("users remain after Reset")
+	}
+	if page, _ := s.ListVideos(database.ListVideosParams{UserID: user.ID, Limit: 10}); len(page.Videos) != 0 {
+		t.Errorf("videos remain after Reset")
+	}
+	if due, _ := s.GetDuePendingDeletions(time.Now().Add(time.Second), 10); len(due) != 0 {
+		t.Errorf("pending deletions remain after Reset")
+	}
+}
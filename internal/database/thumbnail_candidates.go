@@ -0,0 +1,137 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ThumbnailCandidate is a frame extracted from a video that its owner can
+// pick as the thumbnail. Position is where in the video the frame was taken,
+// as a percentage of its duration.
+type ThumbnailCandidate struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	VideoID   uuid.UUID `json:"video_id"`
+	Position  int       `json:"position"`
+	Key       string    `json:"-"`
+}
+
+// ReplaceThumbnailCandidates swaps the candidates of a video for new ones and
+// returns the ones it removed, so their blobs can be deleted.
+func (c Client) ReplaceThumbnailCandidates(videoID uuid.UUID, candidates []ThumbnailCandidate) ([]ThumbnailCandidate, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := queryThumbnailCandidates(tx, videoID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM thumbnail_candidates WHERE video_id = ?`, videoID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO thumbnail_candidates (
+		id,
+		created_at,
+		video_id,
+		position,
+		key
+	) VALUES (?, ?, ?, ?, ?)
+	`
+	for _, candidate := range candidates {
+		if _, err := tx.Exec(query, uuid.New(), now, videoID, candidate.Position, candidate.Key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+func (c Client) GetThumbnailCandidates(videoID uuid.UUID) ([]ThumbnailCandidate, error) {
+	return queryThumbnailCandidates(c.db, videoID)
+}
+
+func (c Client) GetThumbnailCandidate(id uuid.UUID) (ThumbnailCandidate, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		video_id,
+		position,
+		key
+	FROM thumbnail_candidates
+	WHERE id = ?
+	`
+
+	var candidate ThumbnailCandidate
+	err := c.db.QueryRow(query, id).Scan(
+		&candidate.ID,
+		&candidate.CreatedAt,
+		&candidate.VideoID,
+		&candidate.Position,
+		&candidate.Key,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ThumbnailCandidate{}, nil
+		}
+		return ThumbnailCandidate{}, err
+	}
+	return candidate, nil
+}
+
+func (c Client) DeleteThumbnailCandidates(videoID uuid.UUID) error {
+	_, err := c.db.Exec(`DELETE FROM thumbnail_candidates WHERE video_id = ?`, videoID)
+	return err
+}
+
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func queryThumbnailCandidates(q queryer, videoID uuid.UUID) ([]ThumbnailCandidate, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		video_id,
+		position,
+		key
+	FROM thumbnail_candidates
+	WHERE video_id = ?
+	ORDER BY position
+	`
+
+	rows, err := q.Query(query, videoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []ThumbnailCandidate{}
+	for rows.Next() {
+		var candidate ThumbnailCandidate
+		if err := rows.Scan(
+			&candidate.ID,
+			&candidate.CreatedAt,
+			&candidate.VideoID,
+			&candidate.Position,
+			&candidate.Key,
+		); err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
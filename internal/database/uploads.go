@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upload is the state of a resumable upload. Offset is the number of bytes
+// received so far out of Length.
+type Upload struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Offset    int64     `json:"offset"`
+	CreateUploadParams
+}
+
+type CreateUploadParams struct {
+	VideoID  uuid.UUID     `json:"video_id"`
+	UserID   uuid.UUID     `json:"user_id"`
+	Length   int64         `json:"length"`
+	Metadata string        `json:"metadata"`
+	TTL      time.Duration `json:"-"`
+}
+
+func (c Client) CreateUpload(params CreateUploadParams) (Upload, error) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO uploads (
+		id,
+		created_at,
+		updated_at,
+		expires_at,
+		video_id,
+		user_id,
+		length,
+		upload_offset,
+		metadata
+	) VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?)
+	`
+	_, err := c.db.Exec(query, id, now, now, now.Add(params.TTL), params.VideoID, params.UserID, params.Length, params.Metadata)
+	if err != nil {
+		return Upload{}, err
+	}
+	return c.GetUpload(id)
+}
+
+func (c Client) GetUpload(id uuid.UUID) (Upload, error) {
+	query := `
+	SELECT
+		id,
+		created_at,
+		updated_at,
+		expires_at,
+		video_id,
+		user_id,
+		length,
+		upload_offset,
+		metadata
+	FROM uploads
+	WHERE id = ?
+	`
+	var upload Upload
+	err := c.db.QueryRow(query, id).Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&upload.ExpiresAt,
+		&upload.VideoID,
+		&upload.UserID,
+		&upload.Length,
+		&upload.Offset,
+		&upload.Metadata,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Upload{}, nil
+		}
+		return Upload{}, err
+	}
+	return upload, nil
+}
+
+func (c Client) UpdateUploadOffset(id uuid.UUID, offset int64) error {
+	query := `
+	UPDATE uploads
+	SET
+		upload_offset = ?,
+		updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, offset, time.Now().UTC(), id)
+	return err
+}
+
+func (c Client) GetExpiredUploads(now time.Time) ([]Upload, error) {
+	query := `
+	SELECT id
+	FROM uploads
+	WHERE expires_at <= ?
+	`
+	rows, err := c.db.Query(query, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	ids := []uuid.UUID{}
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	uploads := []Upload{}
+	for _, id := range ids {
+		upload, err := c.GetUpload(id)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+func (c Client) DeleteUpload(id uuid.UUID) error {
+	query := `
+	DELETE FROM uploads
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	VideoSortCreated  = "created"
+	VideoSortUpdated  = "updated"
+	VideoSortTitle    = "title"
+	VideoSortDuration = "duration"
+)
+
+var ErrInvalidListParams = errors.New("invalid list parameters")
+
+type ListVideosParams struct {
+	UserID uuid.UUID
+	// Sort is one of the VideoSort constants, VideoSortCreated if empty.
+	// Ties are broken by ID in the same direction.
+	Sort      string
+	Ascending bool
+	Limit     int
+	// HasVideo and HasThumbnail filter on whether a file was uploaded
+	HasVideo     *bool
+	HasThumbnail *bool
+	// CreatedAfter is inclusive, CreatedBefore exclusive
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	// After continues a listing from the last video of the previous page
+	After *VideoCursor
+}
+
+// VideoCursor is the position of a video in a listing: its sort key and ID.
+// Only the field of the listing's sort is set.
+type VideoCursor struct {
+	Time     time.Time `json:"t"`
+	Title    string    `json:"n,omitempty"`
+	Duration float64   `json:"d,omitempty"`
+	ID       uuid.UUID `json:"id"`
+}
+
+type VideoPage struct {
+	Videos []Video
+	// Next is nil on the last page
+	Next *VideoCursor
+}
+
+func (p ListVideosParams) sort() string {
+	if p.Sort == "" {
+		return VideoSortCreated
+	}
+	return p.Sort
+}
+
+func (p ListVideosParams) validate() error {
+	switch p.sort() {
+	case VideoSortCreated, VideoSortUpdated, VideoSortTitle, VideoSortDuration:
+	default:
+		return fmt.Errorf("%w: unknown sort %q", ErrInvalidListParams, p.Sort)
+	}
+	if p.Limit <= 0 {
+		return fmt.Errorf("%w: limit must be positive", ErrInvalidListParams)
+	}
+	return nil
+}
+
+// cursorFor is the cursor positioned at video, whose duration is given
+// separately since it isn't part of Video.
+func (p ListVideosParams) cursorFor(video Video, duration float64) VideoCursor {
+	cursor := VideoCursor{ID: video.ID}
+	switch p.sort() {
+	case VideoSortCreated:
+		cursor.Time = video.CreatedAt
+	case VideoSortUpdated:
+		cursor.Time = video.UpdatedAt
+	case VideoSortTitle:
+		cursor.Title = video.Title
+	case VideoSortDuration:
+		cursor.Duration = duration
+	}
+	return cursor
+}
@@ -3,17 +3,62 @@ package database
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+const (
+	VideoStatusQueued     = "queued"
+	VideoStatusProcessing = "processing"
+	VideoStatusReady      = "ready"
+	VideoStatusFailed     = "failed"
+)
+
 type Video struct {
 	ID           uuid.UUID `json:"id"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 	ThumbnailURL *string   `json:"thumbnail_url"`
 	VideoURL     *string   `json:"video_url"`
+	ThumbnailKey *string   `json:"-"`
+	VideoKey     *string   `json:"-"`
+	// ThumbnailGenerated is set while the thumbnail is one of the video's
+	// generated candidates rather than an uploaded image
+	ThumbnailGenerated bool `json:"-"`
+	// ThumbnailSrcset maps widths ("320w") of an uploaded thumbnail to URLs
+	ThumbnailSrcset map[string]string `json:"thumbnail_srcset,omitempty"`
+	// ThumbnailWidths lists the widths stored beside ThumbnailKey, comma
+	// separated
+	ThumbnailWidths string `json:"-"`
+	// ThumbnailSources maps the media types of the modern-format siblings
+	// of an uploaded thumbnail ("image/webp") to srcsets like
+	// ThumbnailSrcset's
+	ThumbnailSources map[string]map[string]string `json:"thumbnail_sources,omitempty"`
+	// ThumbnailFormats lists the formats the siblings were produced in,
+	// comma separated ("webp,avif")
+	ThumbnailFormats string `json:"-"`
+	// OriginalURL is the upload as received, kept when processing changed
+	// its container or codecs
+	OriginalURL *string `json:"original_url"`
+	OriginalKey *string `json:"-"`
+	// HLSURL is the master playlist of the HLS renditions, if any
+	HLSURL *string `json:"hls_url"`
+	HLSKey *string `json:"-"`
+	// DASHURL is the DASH manifest, if the deployment packages DASH
+	DASHURL *string `json:"dash_url"`
+	DASHKey *string `json:"-"`
+	// SpriteVTTURL maps time ranges to tiles of the SpriteURLs sheets
+	SpriteVTTURL     *string  `json:"sprite_vtt_url"`
+	SpriteURLs       []string `json:"sprite_urls"`
+	SpriteVTTKey     *string  `json:"-"`
+	SpriteSheetCount int      `json:"-"`
+	// MediaInfo is only loaded for single-video responses
+	MediaInfo *MediaInfo `json:"media_info,omitempty"`
+	// ProcessingStatus is empty until a video file is uploaded
+	ProcessingStatus string `json:"processing_status"`
 	CreateVideoParams
 }
 
@@ -23,9 +68,7 @@ type CreateVideoParams struct {
 	UserID      uuid.UUID `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
@@ -33,37 +76,153 @@ func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
 		description,
 		thumbnail_url,
 		video_url,
+		thumbnail_key,
+		thumbnail_generated,
+		thumbnail_widths,
+		thumbnail_formats,
+		video_key,
+		original_key,
+		hls_key,
+		dash_key,
+		sprite_vtt_key,
+		sprite_sheet_count,
+		processing_status,
 		user_id
+`
+
+func scanVideo(row interface{ Scan(...any) error }, extra ...any) (Video, error) {
+	var video Video
+	dest := []any{
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.VideoURL,
+		&video.ThumbnailKey,
+		&video.ThumbnailGenerated,
+		&video.ThumbnailWidths,
+		&video.ThumbnailFormats,
+		&video.VideoKey,
+		&video.OriginalKey,
+		&video.HLSKey,
+		&video.DASHKey,
+		&video.SpriteVTTKey,
+		&video.SpriteSheetCount,
+		&video.ProcessingStatus,
+		&video.UserID,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	return video, err
+}
+
+// ListVideos returns one page of a user's videos. Pages are keyset
+// paginated: params.After is the Next cursor of the previous page.
+func (c Client) ListVideos(params ListVideosParams) (VideoPage, error) {
+	if err := params.validate(); err != nil {
+		return VideoPage{}, err
+	}
+
+	where := []string{"user_id = ?"}
+	args := []any{params.UserID}
+	if params.HasVideo != nil {
+		where = append(where, presenceCondition("video_key", "video_url", *params.HasVideo))
+	}
+	if params.HasThumbnail != nil {
+		where = append(where, presenceCondition("thumbnail_key", "thumbnail_url", *params.HasThumbnail))
+	}
+	if params.CreatedAfter != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, c.db.dialect.timeArg(*params.CreatedAfter))
+	}
+	if params.CreatedBefore != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, c.db.dialect.timeArg(*params.CreatedBefore))
+	}
+
+	// the duration sort key isn't a videos column
+	durationExpr := `COALESCE((SELECT duration_seconds FROM video_media_info WHERE video_media_info.video_id = videos.id), 0)`
+	var key string
+	var after any
+	switch params.sort() {
+	case VideoSortCreated:
+		key = "created_at"
+		if params.After != nil {
+			after = c.db.dialect.timeArg(params.After.Time)
+		}
+	case VideoSortUpdated:
+		key = "updated_at"
+		if params.After != nil {
+			after = c.db.dialect.timeArg(params.After.Time)
+		}
+	case VideoSortTitle:
+		key = c.db.dialect.binaryCollation("title")
+		if params.After != nil {
+			after = params.After.Title
+		}
+	case VideoSortDuration:
+		key = durationExpr
+		if params.After != nil {
+			after = params.After.Duration
+		}
+	}
+
+	dir, cmp := "DESC", "<"
+	if params.Ascending {
+		dir, cmp = "ASC", ">"
+	}
+	if params.After != nil {
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", key, cmp, key, cmp))
+		args = append(args, after, after, params.After.ID)
+	}
+
+	query := `SELECT ` + videoColumns + `, ` + durationExpr + `
 	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY ` + key + ` ` + dir + `, id ` + dir + `
+	LIMIT ?
 	`
+	// one extra row tells whether there is a next page
+	args = append(args, params.Limit+1)
 
-	rows, err := c.db.Query(query, userID)
+	rows, err := c.db.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return VideoPage{}, err
 	}
 	defer rows.Close()
 
-	videos := []Video{}
+	page := VideoPage{Videos: []Video{}}
+	durations := []float64{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
-			return nil, err
+		var duration float64
+		video, err := scanVideo(rows, &duration)
+		if err != nil {
+			return VideoPage{}, err
 		}
-		videos = append(videos, video)
+		page.Videos = append(page.Videos, video)
+		durations = append(durations, duration)
+	}
+	if err := rows.Err(); err != nil {
+		return VideoPage{}, err
 	}
 
-	return videos, nil
+	if len(page.Videos) > params.Limit {
+		page.Videos = page.Videos[:params.Limit]
+		last := page.Videos[params.Limit-1]
+		next := params.cursorFor(last, durations[params.Limit-1])
+		page.Next = &next
+	}
+	return page, nil
+}
+
+// presenceCondition matches rows where the key or legacy URL column is set,
+// or where neither is.
+func presenceCondition(keyColumn, urlColumn string, present bool) string {
+	if present {
+		return fmt.Sprintf("(%s IS NOT NULL OR %s IS NOT NULL)", keyColumn, urlColumn)
+	}
+	return fmt.Sprintf("(%s IS NULL AND %s IS NULL)", keyColumn, urlColumn)
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -87,30 +246,8 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 }
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
-	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
-	FROM videos
-	WHERE id = ?
-	`
-
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	query := `SELECT ` + videoColumns + ` FROM videos WHERE id = ?`
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -127,9 +264,19 @@ func (c Client) UpdateVideo(video Video) error {
 	SET
 		title = ?,
 		description = ?,
-		thumbnail_url = ?,
-		video_url = ?,
-		user_id = ?
+		thumbnail_key = ?,
+		thumbnail_generated = ?,
+		thumbnail_widths = ?,
+		thumbnail_formats = ?,
+		video_key = ?,
+		original_key = ?,
+		hls_key = ?,
+		dash_key = ?,
+		sprite_vtt_key = ?,
+		sprite_sheet_count = ?,
+		processing_status = ?,
+		user_id = ?,
+		updated_at = CURRENT_TIMESTAMP
 	WHERE id = ?
 	`
 
@@ -137,14 +284,55 @@ func (c Client) UpdateVideo(video Video) error {
 		query,
 		video.Title,
 		video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
+		video.ThumbnailKey,
+		video.ThumbnailGenerated,
+		video.ThumbnailWidths,
+		video.ThumbnailFormats,
+		video.VideoKey,
+		video.OriginalKey,
+		video.HLSKey,
+		video.DASHKey,
+		video.SpriteVTTKey,
+		video.SpriteSheetCount,
+		video.ProcessingStatus,
 		video.UserID,
 		video.ID,
 	)
 	return err
 }
 
+func (c Client) UpdateVideoProcessingStatus(id uuid.UUID, status string) error {
+	query := `
+	UPDATE videos
+	SET
+		processing_status = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, status, id)
+	return err
+}
+
+// SetThumbnailFormats records the formats produced for the video's
+// thumbnail, provided it is still thumbnailKey. It reports whether it was.
+func (c Client) SetThumbnailFormats(id uuid.UUID, thumbnailKey, formats string) (bool, error) {
+	query := `
+	UPDATE videos
+	SET
+		thumbnail_formats = ?,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND thumbnail_key = ?
+	`
+	result, err := c.db.Exec(query, formats, id, thumbnailKey)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteVideo removes the video; its jobs, uploads, candidates and media info
+// go with it through ON DELETE CASCADE.
 func (c Client) DeleteVideo(id uuid.UUID) error {
 	query := `
 	DELETE FROM videos
@@ -153,3 +341,89 @@ func (c Client) DeleteVideo(id uuid.UUID) error {
 	_, err := c.db.Exec(query, id)
 	return err
 }
+
+// DeleteVideoQueueingBlobs deletes a video and records its blobs as pending
+// deletion in one transaction, so the blobs are only deleted once the video
+// is gone.
+func (c Client) DeleteVideoQueueingBlobs(id uuid.UUID, blobs []BlobRef) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM videos WHERE id = ?`, id); err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		if _, err := insertPendingDeletion(tx, blob.Store, blob.Key); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// MigrateVideoURLsToKeys rewrites rows that still store full delivery URLs
+// into storage keys. keyFromURL functions report false for URLs they can't
+// map; those rows are left untouched. It returns the number of rows rewritten.
+func (c Client) MigrateVideoURLsToKeys(videoKeyFromURL, thumbnailKeyFromURL func(string) (string, bool)) (int, error) {
+	query := `
+	SELECT id, thumbnail_url, video_url
+	FROM videos
+	WHERE (thumbnail_url IS NOT NULL AND thumbnail_key IS NULL)
+		OR (video_url IS NOT NULL AND video_key IS NULL)
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return 0, err
+	}
+	type legacyRow struct {
+		id           uuid.UUID
+		thumbnailURL *string
+		videoURL     *string
+	}
+	legacy := []legacyRow{}
+	for rows.Next() {
+		var row legacyRow
+		if err := rows.Scan(&row.id, &row.thumbnailURL, &row.videoURL); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		legacy = append(legacy, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	migrated := 0
+	for _, row := range legacy {
+		changed := false
+		if row.videoURL != nil {
+			if key, ok := videoKeyFromURL(*row.videoURL); ok {
+				if _, err := tx.Exec(`UPDATE videos SET video_key = ?, video_url = NULL WHERE id = ?`, key, row.id); err != nil {
+					return 0, err
+				}
+				changed = true
+			}
+		}
+		if row.thumbnailURL != nil {
+			if key, ok := thumbnailKeyFromURL(*row.thumbnailURL); ok {
+				if _, err := tx.Exec(`UPDATE videos SET thumbnail_key = ?, thumbnail_url = NULL WHERE id = ?`, key, row.id); err != nil {
+					return 0, err
+				}
+				changed = true
+			}
+		}
+		if changed {
+			migrated++
+		}
+	}
+	return migrated, tx.Commit()
+}
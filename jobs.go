@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/blobstore"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	jobKindProcessVideo     = "process_video"
+	jobKindThumbnailFormats = "thumbnail_formats"
+
+	jobPollInterval = 2 * time.Second
+	jobMaxAttempts  = 5
+	jobBaseBackoff  = 10 * time.Second
+)
+
+// processVideoPayload is what a process_video job needs to pick up a
+// received upload: a file in the staging directory, or an object clients
+// uploaded straight to the video store, which the worker fetches and checks.
+// MediaType is the detected type of a staged file and the declared type of
+// an object.
+type processVideoPayload struct {
+	SourcePath string `json:"source_path,omitempty"`
+	SourceKey  string `json:"source_key,omitempty"`
+	MediaType  string `json:"media_type"`
+}
+
+// jobQueue runs video processing in the background. Jobs are persisted in the
+// jobs table, so they survive restarts; a single dispatcher claims due jobs
+// and hands them to a fixed pool of workers.
+type jobQueue struct {
+	cfg         *apiConfig
+	concurrency int
+	wake        chan struct{}
+}
+
+func newJobQueue(cfg *apiConfig, concurrency int) *jobQueue {
+	return &jobQueue{
+		cfg:         cfg,
+		concurrency: max(concurrency, 1),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// enqueueVideoProcessing stages a received upload for background processing
+// and marks the video as queued.
+func (q *jobQueue) enqueueVideoProcessing(video database.Video, source processVideoPayload) (database.Job, error) {
+	payload, err := json.Marshal(source)
+	if err != nil {
+		return database.Job{}, err
+	}
+	job, err := q.cfg.db.CreateJob(database.CreateJobParams{
+		VideoID:     video.ID,
+		Kind:        jobKindProcessVideo,
+		Payload:     string(payload),
+		MaxAttempts: jobMaxAttempts,
+	})
+	if err != nil {
+		return database.Job{}, err
+	}
+	if err := q.cfg.db.UpdateVideoProcessingStatus(video.ID, database.VideoStatusQueued); err != nil {
+		return database.Job{}, err
+	}
+
+	q.cfg.events.publish(video.ID, videoEvent{Type: videoEventUploaded})
+
+	q.notify()
+	return job, nil
+}
+
+// notify wakes the dispatcher for a job that was just queued.
+func (q *jobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run recovers jobs interrupted by a crash, then dispatches jobs until ctx is
+// cancelled.
+func (q *jobQueue) run(ctx context.Context) error {
+	requeued, failed, err := q.cfg.db.RequeueRunningJobs()
+	if err != nil {
+		return fmt.Errorf("couldn't recover running jobs: %w", err)
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d jobs interrupted by a restart", requeued)
+	}
+	for _, job := range failed {
+		log.Printf("job %s: interrupted at its last attempt, giving up", job.ID)
+		if job.Kind == jobKindProcessVideo {
+			q.abandon(job)
+		}
+	}
+
+	jobs := make(chan database.Job)
+	for i := 0; i < q.concurrency; i++ {
+		go func() {
+			for job := range jobs {
+				q.runJob(ctx, job)
+			}
+		}()
+	}
+	defer close(jobs)
+
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for {
+		// drain everything that's due before waiting again
+		for {
+			job, err := q.cfg.db.ClaimNextJob(time.Now())
+			if err != nil {
+				log.Printf("Couldn't claim job: %v", err)
+				break
+			}
+			if job.ID == uuid.Nil {
+				break
+			}
+			select {
+			case jobs <- job:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-q.wake:
+		}
+	}
+}
+
+func (q *jobQueue) runJob(ctx context.Context, job database.Job) {
+	var err error
+	switch job.Kind {
+	case jobKindProcessVideo:
+		err = q.processVideo(ctx, job)
+	case jobKindThumbnailFormats:
+		err = q.cfg.storeThumbnailFormats(ctx, job)
+	default:
+		err = fmt.Errorf("%w: unknown job kind %q", errInvalidUpload, job.Kind)
+	}
+	if err == nil {
+		if err := q.cfg.db.CompleteJob(job.ID); err != nil {
+			log.Printf("job %s: couldn't mark complete: %v", job.ID, err)
+		}
+		return
+	}
+
+	if ctx.Err() != nil {
+		// shutting down: the job stays running and is requeued on the next
+		// start
+		log.Printf("job %s: interrupted by shutdown", job.ID)
+		return
+	}
+
+	log.Printf("job %s: attempt %d/%d failed: %v", job.ID, job.Attempts, job.MaxAttempts, err)
+	var retryAt *time.Time
+	if job.Attempts < job.MaxAttempts && !errors.Is(err, errInvalidUpload) {
+		t := time.Now().Add(jobBaseBackoff << (job.Attempts - 1))
+		retryAt = &t
+	}
+	if err := q.cfg.db.FailJob(job.ID, err.Error(), retryAt); err != nil {
+		log.Printf("job %s: couldn't record failure: %v", job.ID, err)
+	}
+	if job.Kind != jobKindProcessVideo {
+		return
+	}
+
+	q.cfg.events.publish(job.VideoID, videoEvent{
+		Type:      videoEventFailed,
+		Reason:    err.Error(),
+		WillRetry: retryAt != nil,
+	})
+
+	if retryAt == nil {
+		q.abandon(job)
+		return
+	}
+	if err := q.cfg.db.UpdateVideoProcessingStatus(job.VideoID, database.VideoStatusQueued); err != nil {
+		log.Printf("job %s: couldn't update video status: %v", job.ID, err)
+	}
+}
+
+// abandon cleans up after a job that won't run again: the video is marked
+// failed, and the upload and whatever its attempts stored are discarded.
+func (q *jobQueue) abandon(job database.Job) {
+	q.discardSource(job)
+	q.discardArtifacts(job)
+	if err := q.cfg.db.UpdateVideoProcessingStatus(job.VideoID, database.VideoStatusFailed); err != nil {
+		log.Printf("job %s: couldn't update video status: %v", job.ID, err)
+	}
+}
+
+func (q *jobQueue) processVideo(ctx context.Context, job database.Job) error {
+	var payload processVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("%w: bad payload: %v", errInvalidUpload, err)
+	}
+
+	video, err := q.cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		return fmt.Errorf("%w: video no longer exists", errInvalidUpload)
+	}
+	if err := q.cfg.db.UpdateVideoProcessingStatus(video.ID, database.VideoStatusProcessing); err != nil {
+		return err
+	}
+
+	// the pipeline checks the content against the declared type first
+	sourcePath := payload.SourcePath
+	if payload.SourceKey != "" {
+		sourcePath, err = q.fetchSource(ctx, payload.SourceKey)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(sourcePath)
+	}
+
+	if _, err := q.cfg.runVideoPipeline(ctx, job.ID, video, sourcePath, payload.MediaType); err != nil {
+		return err
+	}
+
+	if err := q.cfg.db.UpdateVideoProcessingStatus(video.ID, database.VideoStatusReady); err != nil {
+		return err
+	}
+	q.discardSource(job)
+	return nil
+}
+
+// discardArtifacts queues for deletion the files a failed job stored under
+// its keys, unless they were recorded on the video before it failed.
+func (q *jobQueue) discardArtifacts(job database.Job) {
+	// the worker's context may be cancelled already
+	ctx := context.Background()
+	video, err := q.cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		log.Printf("job %s: couldn't get video: %v", job.ID, err)
+		return
+	}
+	if video.VideoKey != nil && strings.HasSuffix(*video.VideoKey, "/"+job.ID.String()+".mp4") {
+		return
+	}
+
+	blobs := []database.BlobRef{}
+	for _, prefix := range aspectPrefixes() {
+		// both the video and its artifact prefix start with this
+		objects, err := q.cfg.videoStore.List(ctx, strings.TrimSuffix(jobVideoKey(prefix, job.ID), ".mp4"))
+		if err != nil {
+			log.Printf("job %s: couldn't list stored files: %v", job.ID, err)
+			return
+		}
+		for _, obj := range objects {
+			blobs = append(blobs, database.BlobRef{Store: deletionStoreVideo, Key: obj.Key})
+		}
+	}
+	thumbnails, err := q.cfg.thumbnailStore.List(ctx, jobThumbnailPrefix(job.ID))
+	if err != nil {
+		log.Printf("job %s: couldn't list stored thumbnails: %v", job.ID, err)
+		return
+	}
+	for _, obj := range thumbnails {
+		blobs = append(blobs, database.BlobRef{Store: deletionStoreThumbnail, Key: obj.Key})
+	}
+	if err := q.cfg.queueBlobDeletions(blobs); err != nil {
+		log.Printf("job %s: couldn't queue stored files for deletion: %v", job.ID, err)
+	}
+}
+
+// fetchSource downloads a directly uploaded object to the staging directory.
+// The caller removes the file.
+func (q *jobQueue) fetchSource(ctx context.Context, key string) (string, error) {
+	body, err := q.cfg.videoStore.Get(ctx, key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return "", fmt.Errorf("%w: uploaded object is gone", errInvalidUpload)
+	}
+	if err != nil {
+		return "", fmt.Errorf("couldn't fetch upload: %w", err)
+	}
+	defer body.Close()
+	return receiveVideo(q.cfg.stagingRoot, body)
+}
+
+// discardSource drops the upload of a job that won't run again: the staged
+// file is removed, and a directly uploaded object is queued for deletion.
+func (q *jobQueue) discardSource(job database.Job) {
+	var payload processVideoPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return
+	}
+	if payload.SourcePath != "" {
+		if err := os.Remove(payload.SourcePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("job %s: couldn't remove staged upload: %v", job.ID, err)
+		}
+	}
+	if payload.SourceKey != "" {
+		if _, err := q.cfg.db.CreatePendingDeletion(deletionStoreVideo, payload.SourceKey); err != nil {
+			log.Printf("job %s: couldn't schedule upload cleanup: %v", job.ID, err)
+		}
+	}
+}
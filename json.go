@@ -7,6 +7,12 @@ import (
 )
 
 func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
+	respondWithErrorCode(w, code, "", msg, err)
+}
+
+// respondWithErrorCode also sends a machine-readable errorCode with the
+// message.
+func respondWithErrorCode(w http.ResponseWriter, code int, errorCode, msg string, err error) {
 	if err != nil {
 		log.Println(err)
 	}
@@ -15,9 +21,11 @@ func respondWithError(w http.ResponseWriter, code int, msg string, err error) {
 	}
 	type errorResponse struct {
 		Error string `json:"error"`
+		Code  string `json:"code,omitempty"`
 	}
 	respondWithJSON(w, code, errorResponse{
 		Error: msg,
+		Code:  errorCode,
 	})
 }
 
@@ -2,39 +2,75 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/blobstore"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
-	db               database.Client
+	db               database.Store
 	jwtSecret        string
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
 	s3Bucket         string
 	s3Region         string
-	s3Client         *s3.Client
 	s3CfDistribution string
 	port             string
+	videoStore       blobstore.BlobStore
+	thumbnailStore   blobstore.BlobStore
+
+	videoURLResolver     urlResolver
+	thumbnailURLResolver urlResolver
+	cdnSigning           *cdnSigning
+
+	maxDirectUploadSize int64
+	acceptedVideoTypes  []string
+	thumbnailWidths     []int
+	thumbnailFormats    []string
+	tus                 *tusUploads
+	s3Multipart         blobstore.MultipartConfig
+	stagingRoot         string
+	jobs                *jobQueue
+	events              *videoEvents
+	hlsLadder           []rendition
+	dashPackaging       bool
+	dashLadder          []rendition
+	spriteInterval      time.Duration
 }
 
 func main() {
 	godotenv.Load(".env")
 
+	// DB_PATH is a SQLite file path or a postgres:// connection URL
 	pathToDB := os.Getenv("DB_PATH")
 	if pathToDB == "" {
 		log.Fatal("DB_URL must be set")
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(pathToDB, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
 	db, err := database.NewClient(pathToDB)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
@@ -60,31 +96,196 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
-	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
-		log.Fatal("S3_BUCKET environment variable is not set")
+	port := os.Getenv("PORT")
+	if port == "" {
+		log.Fatal("PORT environment variable is not set")
+	}
+
+	// VIDEO_STORE / THUMBNAIL_STORE pick the blob backend: "s3", "local" or "memory"
+	videoStoreKind := os.Getenv("VIDEO_STORE")
+	if videoStoreKind == "" {
+		videoStoreKind = "s3"
+	}
+	thumbnailStoreKind := os.Getenv("THUMBNAIL_STORE")
+	if thumbnailStoreKind == "" {
+		thumbnailStoreKind = "local"
 	}
 
-	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
-		log.Fatal("S3_REGION environment variable is not set")
+	// VIDEO_URL_MODE is "public" (CDN/bucket URLs), "presigned" (time-limited
+	// GET URLs for a private bucket, valid for PRESIGN_EXPIRY) or "signed-cdn"
+	// (CloudFront signed URLs and cookies, see newCDNSigning)
+	videoURLMode := os.Getenv("VIDEO_URL_MODE")
+	if videoURLMode == "" {
+		videoURLMode = "public"
+	}
+	presignExpiry := 15 * time.Minute
+	if v := os.Getenv("PRESIGN_EXPIRY"); v != "" {
+		presignExpiry, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid PRESIGN_EXPIRY: %v", err)
+		}
 	}
 
-	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
-		log.Fatal("S3_CF_DISTRO environment variable is not set")
+	// MAX_DIRECT_UPLOAD_SIZE caps direct-to-bucket uploads, in bytes (default 10GB)
+	maxDirectUploadSize := int64(10 << 30)
+	if v := os.Getenv("MAX_DIRECT_UPLOAD_SIZE"); v != "" {
+		maxDirectUploadSize, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Fatalf("Invalid MAX_DIRECT_UPLOAD_SIZE: %v", err)
+		}
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		log.Fatal("PORT environment variable is not set")
+	// ACCEPTED_VIDEO_TYPES lists the upload media types to accept; anything
+	// that isn't already an MP4 with H.264/HEVC and AAC/MP3 is normalized to one
+	acceptedVideoTypes := []string{"video/mp4", "video/quicktime", "video/webm", "video/x-matroska"}
+	if v := os.Getenv("ACCEPTED_VIDEO_TYPES"); v != "" {
+		acceptedVideoTypes = nil
+		for _, t := range strings.Split(v, ",") {
+			acceptedVideoTypes = append(acceptedVideoTypes, strings.TrimSpace(t))
+		}
 	}
 
-	s3Config, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+	// THUMBNAIL_WIDTHS lists the widths uploaded thumbnails are resized to
+	thumbnailWidths, err := parseThumbnailWidths(os.Getenv("THUMBNAIL_WIDTHS"))
 	if err != nil {
-		log.Fatal("Cannot create a s3 config: ", err)
+		log.Fatalf("Invalid THUMBNAIL_WIDTHS: %v", err)
+	}
+	// THUMBNAIL_FORMATS lists the extra formats thumbnails are produced in
+	// ("webp", "avif"; default "webp")
+	thumbnailFormats, err := parseThumbnailFormats(os.Getenv("THUMBNAIL_FORMATS"))
+	if err != nil {
+		log.Fatalf("Invalid THUMBNAIL_FORMATS: %v", err)
+	}
+
+	// DATA_ROOT holds files that must outlive a reboot until they're
+	// processed, unlike the system temp dir
+	dataRoot := os.Getenv("DATA_ROOT")
+	if dataRoot == "" {
+		dataRoot = "data"
+	}
+
+	// TUS_ROOT holds partial resumable uploads until they complete
+	tusRoot := os.Getenv("TUS_ROOT")
+	if tusRoot == "" {
+		tusRoot = filepath.Join(dataRoot, "tus")
+	}
+	tus, err := newTusUploads(tusRoot, maxDirectUploadSize, 24*time.Hour)
+	if err != nil {
+		log.Fatalf("Couldn't set up resumable uploads: %v", err)
+	}
+
+	// S3_MULTIPART_THRESHOLD / _PART_SIZE (bytes) and _CONCURRENCY tune
+	// multipart uploads of large objects
+	s3Multipart := blobstore.DefaultMultipartConfig
+	for name, dst := range map[string]*int64{
+		"S3_MULTIPART_THRESHOLD": &s3Multipart.Threshold,
+		"S3_MULTIPART_PART_SIZE": &s3Multipart.PartSize,
+	} {
+		if v := os.Getenv(name); v != "" {
+			*dst, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				log.Fatalf("Invalid %s: %v", name, err)
+			}
+		}
+	}
+	if v := os.Getenv("S3_MULTIPART_CONCURRENCY"); v != "" {
+		s3Multipart.Concurrency, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("Invalid S3_MULTIPART_CONCURRENCY: %v", err)
+		}
+	}
+
+	// STAGING_ROOT keeps received uploads until the job queue has processed
+	// them; PROCESSING_WORKERS is how many videos are processed at once
+	stagingRoot := os.Getenv("STAGING_ROOT")
+	if stagingRoot == "" {
+		stagingRoot = filepath.Join(dataRoot, "staging")
+	}
+	if err := os.MkdirAll(stagingRoot, 0755); err != nil {
+		log.Fatalf("Couldn't create staging directory: %v", err)
+	}
+	processingWorkers := 2
+	if v := os.Getenv("PROCESSING_WORKERS"); v != "" {
+		processingWorkers, err = strconv.Atoi(v)
+		if err != nil {
+			log.Fatalf("Invalid PROCESSING_WORKERS: %v", err)
+		}
+	}
+
+	// HLS_LADDER lists the HLS renditions as shortSide:videoKbps[:audioKbps],
+	// e.g. "1080:5000,720:2800,480:1400,360:800"; "off" disables HLS
+	hlsLadder, err := parseLadder(os.Getenv("HLS_LADDER"))
+	if err != nil {
+		log.Fatalf("Invalid HLS_LADDER: %v", err)
+	}
+
+	// DASH_PACKAGING=true also packages DASH, with renditions from DASH_LADDER
+	// (same format as HLS_LADDER)
+	dashPackaging := os.Getenv("DASH_PACKAGING") == "true"
+	dashLadder, err := parseLadder(os.Getenv("DASH_LADDER"))
+	if err != nil {
+		log.Fatalf("Invalid DASH_LADDER: %v", err)
+	}
+
+	// SPRITE_INTERVAL is how often a scrubbing preview tile is taken; 0
+	// disables sprite sheets
+	spriteInterval := 5 * time.Second
+	if v := os.Getenv("SPRITE_INTERVAL"); v != "" {
+		spriteInterval, err = time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("Invalid SPRITE_INTERVAL: %v", err)
+		}
+	}
+
+	var s3Bucket, s3Region, s3CfDistribution string
+	var s3Client *s3.Client
+	if videoStoreKind == "s3" || thumbnailStoreKind == "s3" {
+		s3Bucket = os.Getenv("S3_BUCKET")
+		if s3Bucket == "" {
+			log.Fatal("S3_BUCKET environment variable is not set")
+		}
+
+		s3Region = os.Getenv("S3_REGION")
+		if s3Region == "" {
+			log.Fatal("S3_REGION environment variable is not set")
+		}
+
+		s3CfDistribution = os.Getenv("S3_CF_DISTRO")
+		if s3CfDistribution == "" && videoURLMode != "presigned" {
+			log.Fatal("S3_CF_DISTRO environment variable is not set")
+		}
+
+		s3Config, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+		if err != nil {
+			log.Fatal("Cannot create a s3 config: ", err)
+		}
+		// S3_ENDPOINT points at an S3-compatible stand-in (e.g. MinIO) for local dev
+		s3Endpoint := os.Getenv("S3_ENDPOINT")
+		s3Client = s3.NewFromConfig(s3Config, func(o *s3.Options) {
+			if s3Endpoint != "" {
+				o.BaseEndpoint = aws.String(s3Endpoint)
+				o.UsePathStyle = true
+			}
+		})
+	}
+
+	memoryStore := blobstore.NewMemory(fmt.Sprintf("http://localhost:%s/blobs", port))
+	newBlobStore := func(kind string) blobstore.BlobStore {
+		switch kind {
+		case "s3":
+			return blobstore.NewS3(s3Client, s3Bucket, s3Region, s3CfDistribution, s3Multipart)
+		case "local":
+			store, err := blobstore.NewFilesystem(assetsRoot, fmt.Sprintf("http://localhost:%s/assets", port))
+			if err != nil {
+				log.Fatalf("Couldn't create local blob store: %v", err)
+			}
+			return store
+		case "memory":
+			return memoryStore
+		}
+		log.Fatalf("Unknown blob store %q", kind)
+		return nil
 	}
-	s3Client := s3.NewFromConfig(s3Config)
 
 	cfg := apiConfig{
 		db:               db,
@@ -94,9 +295,60 @@ func main() {
 		assetsRoot:       assetsRoot,
 		s3Bucket:         s3Bucket,
 		s3Region:         s3Region,
-		s3Client:         s3Client,
 		s3CfDistribution: s3CfDistribution,
 		port:             port,
+		videoStore:       newBlobStore(videoStoreKind),
+		thumbnailStore:   newBlobStore(thumbnailStoreKind),
+
+		maxDirectUploadSize: maxDirectUploadSize,
+		acceptedVideoTypes:  acceptedVideoTypes,
+		thumbnailWidths:     thumbnailWidths,
+		thumbnailFormats:    thumbnailFormats,
+		tus:                 tus,
+		s3Multipart:         s3Multipart,
+		stagingRoot:         stagingRoot,
+		events:              newVideoEvents(),
+		hlsLadder:           hlsLadder,
+		dashPackaging:       dashPackaging,
+		dashLadder:          dashLadder,
+		spriteInterval:      spriteInterval,
+	}
+	cfg.jobs = newJobQueue(&cfg, processingWorkers)
+	switch videoURLMode {
+	case "public":
+		cfg.videoURLResolver = storeURLResolver{cfg.videoStore}
+	case "presigned":
+		presigner, ok := cfg.videoStore.(blobstore.Presigner)
+		if !ok {
+			log.Fatalf("VIDEO_URL_MODE=presigned requires a video store that can presign URLs, got %q", videoStoreKind)
+		}
+		// playlists reference their segments by relative path, which a
+		// presigned URL for the master playlist doesn't cover
+		if len(hlsLadder) > 0 {
+			log.Fatal("VIDEO_URL_MODE=presigned can't serve HLS segments, set HLS_LADDER=off")
+		}
+		// likewise for the segment templates of a DASH manifest
+		if dashPackaging {
+			log.Fatal("VIDEO_URL_MODE=presigned can't serve DASH segments, unset DASH_PACKAGING")
+		}
+		cfg.videoURLResolver = presignedURLResolver{presigner: presigner, expiry: presignExpiry}
+	case "signed-cdn":
+		cfg.cdnSigning, err = newCDNSigning(s3CfDistribution)
+		if err != nil {
+			log.Fatalf("Couldn't configure CloudFront signing: %v", err)
+		}
+		cfg.videoURLResolver = cfg.cdnSigning
+	default:
+		log.Fatalf("Unknown VIDEO_URL_MODE %q", videoURLMode)
+	}
+	cfg.thumbnailURLResolver = storeURLResolver{cfg.thumbnailStore}
+
+	migrated, err := cfg.migrateVideoURLsToKeys()
+	if err != nil {
+		log.Fatalf("Couldn't migrate video URLs to keys: %v", err)
+	}
+	if migrated > 0 {
+		log.Printf("Migrated %d videos from stored URLs to object keys", migrated)
 	}
 
 	err = cfg.ensureAssetsDir()
@@ -104,12 +356,32 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	// ctx is cancelled on SIGINT or SIGTERM, which stops the background
+	// workers and kills any ffmpeg they're running
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go cfg.runDeletionRetrier(ctx, 5*time.Minute)
+	go cfg.runUploadExpirer(ctx, time.Hour)
+	go func() {
+		if err := cfg.jobs.run(ctx); err != nil {
+			log.Fatalf("Job queue stopped: %v", err)
+		}
+	}()
+	if store, ok := cfg.videoStore.(*blobstore.S3); ok {
+		go func() {
+			if err := store.AbortStaleMultipartUploads(context.Background(), 24*time.Hour); err != nil {
+				log.Printf("Couldn't abort stale multipart uploads: %v", err)
+			}
+		}()
+	}
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", noCacheMiddleware(assetsHandler))
+	mux.Handle("/assets/", noCacheMiddleware(http.HandlerFunc(cfg.handlerAssets)))
+	mux.Handle("/blobs/", noCacheMiddleware(http.StripPrefix("/blobs", memoryStore)))
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
@@ -120,17 +392,43 @@ func main() {
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/presign", cfg.handlerDirectUploadPresign)
+	mux.HandleFunc("POST /api/video_upload/{videoID}/complete", cfg.handlerDirectUploadComplete)
+	mux.HandleFunc("OPTIONS /api/tus/", cfg.handlerTusOptions)
+	mux.HandleFunc("POST /api/tus/{videoID}", cfg.handlerTusCreate)
+	mux.HandleFunc("HEAD /api/tus/uploads/{uploadID}", cfg.handlerTusHead)
+	mux.HandleFunc("PATCH /api/tus/uploads/{uploadID}", cfg.handlerTusPatch)
+	mux.HandleFunc("DELETE /api/tus/uploads/{uploadID}", cfg.handlerTusDelete)
+	mux.HandleFunc("GET /api/jobs/{jobID}", cfg.handlerJobGet)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/events", cfg.handlerVideoEvents)
+	mux.HandleFunc("GET /api/videos/{videoID}/sprites.vtt", cfg.handlerSpriteVTTGet)
+	mux.HandleFunc("GET /api/videos/{videoID}/thumbnails", cfg.handlerThumbnailCandidatesGet)
+	mux.HandleFunc("PUT /api/videos/{videoID}/thumbnail", cfg.handlerThumbnailSelect)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
 
 	srv := &http.Server{
 		Addr:    ":" + port,
-		Handler: mux,
+		Handler: clientIPMiddleware(mux),
 	}
 
 	log.Printf("Serving on: http://localhost:%s/app/\n", port)
-	log.Fatal(srv.ListenAndServe())
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down")
+	// event streams stay open until their client leaves, so don't wait on
+	// them forever
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Couldn't shut down cleanly: %v", err)
+	}
 }
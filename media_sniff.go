@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+)
+
+// Content sniffing: uploads are typed by what their bytes are, not by the
+// Content-Type the client declared. A declared type that disagrees with the
+// content is rejected.
+
+var (
+	errUnsupportedMediaType = fmt.Errorf("%w: unsupported media type", errInvalidUpload)
+	errMediaTypeMismatch    = fmt.Errorf("%w: content doesn't match the declared media type", errInvalidUpload)
+)
+
+// sniffLen is how much of a file is looked at to detect its type.
+const sniffLen = 4096
+
+// sniffImageType detects an image's media type from its magic bytes. Other
+// content comes back as whatever http.DetectContentType makes of it, e.g.
+// application/pdf.
+func sniffImageType(header []byte) string {
+	return http.DetectContentType(header)
+}
+
+// sniffVideoType detects the container of a video from its magic bytes, or
+// returns "" if it isn't one we know.
+func sniffVideoType(header []byte) string {
+	// ISO base media files start with an ftyp box; QuickTime's major brand is "qt  "
+	if len(header) >= 12 && string(header[4:8]) == "ftyp" {
+		if string(header[8:12]) == "qt  " {
+			return "video/quicktime"
+		}
+		return "video/mp4"
+	}
+	// Matroska and WebM share the EBML header and differ in its DocType
+	if bytes.HasPrefix(header, []byte{0x1A, 0x45, 0xDF, 0xA3}) {
+		if bytes.Contains(header, []byte("webm")) {
+			return "video/webm"
+		}
+		if bytes.Contains(header, []byte("matroska")) {
+			return "video/x-matroska"
+		}
+	}
+	return ""
+}
+
+// containerFamilies groups media types that are the same container format
+// under different names, which clients mix up routinely.
+var containerFamilies = map[string]string{
+	"video/mp4":        "isobmff",
+	"video/quicktime":  "isobmff",
+	"video/webm":       "matroska",
+	"video/x-matroska": "matroska",
+}
+
+func sameContainer(declared, detected string) bool {
+	if declared == detected {
+		return true
+	}
+	family, ok := containerFamilies[declared]
+	return ok && family == containerFamilies[detected]
+}
+
+// mediaTypeAliases maps nonstandard names clients declare to the registered
+// media type sniffing reports.
+var mediaTypeAliases = map[string]string{
+	"image/jpg":   "image/jpeg",
+	"image/pjpeg": "image/jpeg",
+	"image/x-png": "image/png",
+}
+
+// normalizeMediaType reduces a declared Content-Type to its lowercase media
+// type without parameters, with aliases resolved, so it compares with a
+// sniffed type. A value that doesn't parse is returned unchanged and won't
+// match anything.
+func normalizeMediaType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	if alias, ok := mediaTypeAliases[mediaType]; ok {
+		return alias
+	}
+	return mediaType
+}
+
+// readSniffHeader reads up to sniffLen bytes from r.
+func readSniffHeader(r io.Reader) ([]byte, error) {
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// checkVideoContent detects the type of the received video at path, checks it
+// against the declared type and the accepted types, and has ffprobe confirm
+// it holds a video stream. It returns the detected type.
+func checkVideoContent(ctx context.Context, path, declared string, accepted []string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	header, err := readSniffHeader(f)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	detected := sniffVideoType(header)
+	if detected == "" {
+		return "", fmt.Errorf("%w: unrecognized video container", errUnsupportedMediaType)
+	}
+	if declared != "" && !sameContainer(declared, detected) {
+		return "", fmt.Errorf("%w: declared %s, content is %s", errMediaTypeMismatch, declared, detected)
+	}
+	if err := validateVideo(detected, accepted); err != nil {
+		return "", err
+	}
+
+	if _, err := getVideoMediaInfo(ctx, path); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) || errors.Is(err, errNoVideoStream) {
+			return "", fmt.Errorf("%w: not a readable video: %v", errInvalidUpload, err)
+		}
+		return "", fmt.Errorf("couldn't probe video: %w", err)
+	}
+	return detected, nil
+}
+
+// mediaErrorCode is the error code API clients get for a rejected upload.
+func mediaErrorCode(err error) string {
+	switch {
+	case errors.Is(err, errMediaTypeMismatch):
+		return "media_type_mismatch"
+	case errors.Is(err, errUnsupportedMediaType):
+		return "unsupported_media_type"
+	default:
+		return "invalid_upload"
+	}
+}
+
+func respondWithMediaError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	if errors.Is(err, errMediaTypeMismatch) || errors.Is(err, errUnsupportedMediaType) {
+		status = http.StatusUnsupportedMediaType
+	}
+	respondWithErrorCode(w, status, mediaErrorCode(err), err.Error(), err)
+}
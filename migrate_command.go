@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+const migrateUsage = "usage: migrate up | down [N] | status"
+
+// runMigrateCommand handles `migrate up`, `migrate down [N]` (default 1)
+// and `migrate status` against the database at pathToDB.
+func runMigrateCommand(pathToDB string, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	db, err := database.Open(pathToDB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "up":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+		applied, err := db.MigrateUp()
+		for _, version := range applied {
+			fmt.Printf("applied %04d\n", version)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("already up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		} else if len(args) > 2 {
+			return errors.New(migrateUsage)
+		}
+		reverted, err := db.MigrateDown(steps)
+		for _, version := range reverted {
+			fmt.Printf("reverted %04d\n", version)
+		}
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Println("nothing to revert")
+		}
+	case "status":
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+		status, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, m := range status {
+			state := "pending"
+			if m.AppliedAt != nil {
+				state = "applied " + m.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, state)
+		}
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
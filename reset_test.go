@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerReset(t *testing.T) {
+	tests := []struct {
+		name       string
+		platform   string
+		wantStatus int
+	}{
+		{"dev", "dev", http.StatusOK},
+		{"production", "prod", http.StatusForbidden},
+		{"unset platform", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			cfg.platform = tt.platform
+			user, _ := createTestUser(t, cfg, "owner@example.com")
+			video := createTestVideo(t, cfg, user)
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/reset", nil)
+			rec := serve("POST /admin/reset", cfg.handlerReset, req, "")
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			got, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept := got.ID == video.ID; kept != (rec.Code != http.StatusOK) {
+				t.Errorf("video kept = %v after status %d", kept, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Thumbnails also get modern-format siblings (320w.png -> 320w.webp,
+// 320w.avif), produced on the job queue. The API lists them per format and
+// /assets/ serves whichever one the client accepts best.
+
+// thumbnailFormats are the formats thumbnail variants can be produced in,
+// best first, with their ffmpeg encoder arguments.
+var thumbnailFormats = []struct {
+	ext       string
+	mediaType string
+	args      []string
+}{
+	{".avif", "image/avif", []string{"-c:v", "libaom-av1", "-still-picture", "1", "-crf", "32", "-f", "avif"}},
+	{".webp", "image/webp", []string{"-c:v", "libwebp", "-quality", "80", "-f", "webp"}},
+}
+
+// parseThumbnailFormats parses a list such as "webp,avif".
+func parseThumbnailFormats(def string) ([]string, error) {
+	if strings.TrimSpace(def) == "" {
+		return []string{".webp"}, nil
+	}
+	exts := []string{}
+	for _, part := range strings.Split(def, ",") {
+		ext := "." + strings.TrimSpace(part)
+		if !isThumbnailFormat(ext) {
+			return nil, fmt.Errorf("unsupported format %q", part)
+		}
+		exts = append(exts, ext)
+	}
+	return exts, nil
+}
+
+func isThumbnailFormat(ext string) bool {
+	return thumbnailFormatMediaType(ext) != ""
+}
+
+// thumbnailFormatMediaType is the media type of a thumbnail format, or ""
+// if ext isn't one.
+func thumbnailFormatMediaType(ext string) string {
+	for _, f := range thumbnailFormats {
+		if f.ext == ext {
+			return f.mediaType
+		}
+	}
+	return ""
+}
+
+// encodeImageFormat converts the image at inputPath with ffmpeg.
+func encodeImageFormat(ctx context.Context, inputPath, outputPath string, args []string) error {
+	cmdArgs := append([]string{"-i", inputPath}, args...)
+	cmdArgs = append(cmdArgs, "-y", outputPath)
+	cmd := exec.CommandContext(ctx, "ffmpeg", cmdArgs...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error encoding %s: %s, %v", filepath.Ext(outputPath), stderr.String(), err)
+	}
+	return nil
+}
+
+// thumbnailFormatsPayload is what a thumbnail_formats job converts: the
+// stored width variants of an uploaded thumbnail.
+type thumbnailFormatsPayload struct {
+	ThumbnailKey string   `json:"thumbnail_key"`
+	Keys         []string `json:"keys"`
+}
+
+// enqueueThumbnailFormats queues producing the enabled modern-format
+// siblings of the thumbnail variants stored at keys. AVIF in particular takes
+// too long to encode within the upload request.
+func (q *jobQueue) enqueueThumbnailFormats(video database.Video, keys []string) (database.Job, error) {
+	payload, err := json.Marshal(thumbnailFormatsPayload{ThumbnailKey: *video.ThumbnailKey, Keys: keys})
+	if err != nil {
+		return database.Job{}, err
+	}
+	job, err := q.cfg.db.CreateJob(database.CreateJobParams{
+		VideoID:     video.ID,
+		Kind:        jobKindThumbnailFormats,
+		Payload:     string(payload),
+		MaxAttempts: jobMaxAttempts,
+	})
+	if err != nil {
+		return database.Job{}, err
+	}
+	q.notify()
+	return job, nil
+}
+
+// storeThumbnailFormats runs a thumbnail_formats job. The siblings are an
+// optimization, so a format ffmpeg can't produce is logged and skipped;
+// clients then get the original. Formats are recorded on the video once
+// every variant has them.
+func (cfg *apiConfig) storeThumbnailFormats(ctx context.Context, job database.Job) error {
+	var payload thumbnailFormatsPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		return fmt.Errorf("%w: bad payload: %v", errInvalidUpload, err)
+	}
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ThumbnailKey == nil || *video.ThumbnailKey != payload.ThumbnailKey {
+		// replaced, or the video deleted, before the job ran
+		return nil
+	}
+
+	dir, err := os.MkdirTemp(cfg.stagingRoot, "thumbnail-*")
+	if err != nil {
+		return fmt.Errorf("could not create thumbnail directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	produced := []string{}
+	stored := []database.BlobRef{}
+	for _, f := range thumbnailFormats {
+		if !slices.Contains(cfg.thumbnailFormats, f.ext) {
+			continue
+		}
+		ok := true
+		for _, key := range payload.Keys {
+			err := cfg.storeThumbnailFormat(ctx, dir, key, f.ext, f.mediaType, f.args)
+			if errors.Is(err, errEncodeImage) {
+				log.Printf("Couldn't produce %s of thumbnail %s: %v", f.ext, key, err)
+				ok = false
+				break
+			}
+			if err != nil {
+				return err
+			}
+			stored = append(stored, database.BlobRef{Store: deletionStoreThumbnail, Key: thumbnailFormatKey(key, f.ext)})
+		}
+		if ok {
+			produced = append(produced, strings.TrimPrefix(f.ext, "."))
+		}
+	}
+
+	recorded, err := cfg.db.SetThumbnailFormats(job.VideoID, payload.ThumbnailKey, strings.Join(produced, ","))
+	if err != nil {
+		return fmt.Errorf("couldn't record thumbnail formats: %w", err)
+	}
+	if !recorded {
+		// the thumbnail was replaced, or the video deleted, in the meantime
+		return cfg.queueBlobDeletions(stored)
+	}
+	return nil
+}
+
+var errEncodeImage = errors.New("couldn't encode image")
+
+// storeThumbnailFormat stores the ext sibling of the thumbnail variant at
+// key, staging files in dir.
+func (cfg *apiConfig) storeThumbnailFormat(ctx context.Context, dir, key, ext, mediaType string, args []string) error {
+	src, err := cfg.thumbnailStore.Get(ctx, key)
+	if err != nil {
+		return fmt.Errorf("couldn't fetch thumbnail %s: %w", key, err)
+	}
+	inputPath := filepath.Join(dir, "source"+path.Ext(key))
+	f, err := os.Create(inputPath)
+	if err == nil {
+		_, err = io.Copy(f, src)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	src.Close()
+	if err != nil {
+		return fmt.Errorf("couldn't stage thumbnail %s: %w", key, err)
+	}
+
+	outputPath := filepath.Join(dir, "out"+ext)
+	if err := encodeImageFormat(ctx, inputPath, outputPath, args); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %v", errEncodeImage, err)
+	}
+	out, err := os.Open(outputPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return cfg.thumbnailStore.Put(ctx, thumbnailFormatKey(key, ext), out, mediaType)
+}
+
+// thumbnailFormatKey is the key of the ext sibling of the thumbnail at key.
+func thumbnailFormatKey(key, ext string) string {
+	return strings.TrimSuffix(key, path.Ext(key)) + ext
+}
+
+// acceptedImageFormats returns the extensions of the thumbnail formats an
+// Accept header explicitly lists (wildcards don't count: plenty of clients
+// send */* without decoding AVIF), best first.
+func acceptedImageFormats(accept string) []string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			accepted[strings.ToLower(strings.TrimSpace(mediaType))] = true
+		}
+	}
+
+	exts := []string{}
+	for _, f := range thumbnailFormats {
+		if accepted[f.mediaType] {
+			exts = append(exts, f.ext)
+		}
+	}
+	return exts
+}
+
+// handlerAssets serves files of the local stores. JPEG and PNG requests get
+// the best modern-format sibling the client accepts, if one was produced.
+func (cfg *apiConfig) handlerAssets(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/assets/"))
+
+	candidates := []string{}
+	switch path.Ext(name) {
+	case ".jpg", ".jpeg", ".png":
+		w.Header().Add("Vary", "Accept")
+		for _, ext := range acceptedImageFormats(r.Header.Get("Accept")) {
+			candidates = append(candidates, thumbnailFormatKey(name, ext))
+		}
+	}
+	candidates = append(candidates, name)
+
+	for _, candidate := range candidates {
+		full := filepath.Join(cfg.assetsRoot, filepath.FromSlash(candidate))
+		if info, err := os.Stat(full); err == nil && !info.IsDir() {
+			http.ServeFile(w, r, full)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestResolveThumbnailSources(t *testing.T) {
+	cfg := newTestAPI(t)
+	user, _ := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, user)
+	video.ThumbnailKey = aws.String("thumbnails/abc/640w.png")
+	video.ThumbnailWidths = "320,640"
+	video.ThumbnailFormats = "webp,avif"
+
+	resolved, err := cfg.resolveVideoURLs(context.Background(), video)
+	if err != nil {
+		t.Fatalf("resolveVideoURLs: %v", err)
+	}
+	want := map[string]map[string]string{
+		"image/webp": {"320w": "http://localhost/blobs/thumbnails/abc/320w.webp", "640w": "http://localhost/blobs/thumbnails/abc/640w.webp"},
+		"image/avif": {"320w": "http://localhost/blobs/thumbnails/abc/320w.avif", "640w": "http://localhost/blobs/thumbnails/abc/640w.avif"},
+	}
+	for mediaType, srcset := range want {
+		for w, u := range srcset {
+			if got := resolved.ThumbnailSources[mediaType][w]; got != u {
+				t.Errorf("ThumbnailSources[%s][%s] = %q; want %q", mediaType, w, got, u)
+			}
+		}
+	}
+
+	video.ThumbnailFormats = ""
+	resolved, err = cfg.resolveVideoURLs(context.Background(), video)
+	if err != nil || resolved.ThumbnailSources != nil {
+		t.Errorf("without formats, ThumbnailSources = %v, %v; want none", resolved.ThumbnailSources, err)
+	}
+}
+
+// TestUploadThumbnailQueuesFormats checks the upload leaves the other
+// formats to the job queue.
+func TestUploadThumbnailQueuesFormats(t *testing.T) {
+	cfg := newTestAPI(t)
+	cfg.thumbnailFormats = []string{".webp", ".avif"}
+	user, token := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, user)
+
+	req := thumbnailUploadRequest(t, video.ID.String(), "image/jpeg", testJPEG(t))
+	rec := serve("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail, req, token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+
+	job, err := cfg.db.ClaimNextJob(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Kind != jobKindThumbnailFormats || job.VideoID != video.ID {
+		t.Fatalf("queued job = %+v; want a thumbnail_formats job", job)
+	}
+	var payload thumbnailFormatsPayload
+	if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := cfg.db.GetVideo(video.ID)
+	if payload.ThumbnailKey != aws.ToString(stored.ThumbnailKey) || len(payload.Keys) != len(cfg.thumbnailWidths) {
+		t.Errorf("payload = %+v; want the variants of %s", payload, aws.ToString(stored.ThumbnailKey))
+	}
+	objects, err := cfg.thumbnailStore.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != len(cfg.thumbnailWidths) {
+		t.Errorf("upload stored %v; want only the width variants", objects)
+	}
+}
+
+func storeTestThumbnail(t *testing.T, cfg *apiConfig, video *database.Video) []string {
+	t.Helper()
+	key := "thumbnails/abc/64w.jpg"
+	if err := cfg.thumbnailStore.Put(context.Background(), key, bytes.NewReader(testJPEG(t)), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	video.ThumbnailKey = aws.String(key)
+	video.ThumbnailWidths = "64"
+	if err := cfg.db.UpdateVideo(*video); err != nil {
+		t.Fatal(err)
+	}
+	return []string{key}
+}
+
+func TestStoreThumbnailFormats(t *testing.T) {
+	requireFFmpeg(t)
+	cfg := newTestAPI(t)
+	cfg.thumbnailFormats = []string{".webp"}
+	user, _ := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, user)
+	keys := storeTestThumbnail(t, cfg, &video)
+
+	job, err := cfg.jobs.enqueueThumbnailFormats(video, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.storeThumbnailFormats(context.Background(), job); err != nil {
+		t.Fatalf("storeThumbnailFormats: %v", err)
+	}
+	if _, err := cfg.thumbnailStore.Stat(context.Background(), "thumbnails/abc/64w.webp"); err != nil {
+		t.Errorf("webp sibling: %v", err)
+	}
+	if got, _ := cfg.db.GetVideo(video.ID); got.ThumbnailFormats != "webp" {
+		t.Errorf("ThumbnailFormats = %q; want webp", got.ThumbnailFormats)
+	}
+}
+
+func TestStoreThumbnailFormatsAfterReplacement(t *testing.T) {
+	cfg := newTestAPI(t)
+	cfg.thumbnailFormats = []string{".webp"}
+	user, _ := createTestUser(t, cfg, "owner@example.com")
+	video := createTestVideo(t, cfg, user)
+	keys := storeTestThumbnail(t, cfg, &video)
+
+	job, err := cfg.jobs.enqueueThumbnailFormats(video, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	video.ThumbnailKey = aws.String("thumbnails/def/64w.jpg")
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cfg.storeThumbnailFormats(context.Background(), job); err != nil {
+		t.Fatalf("storeThumbnailFormats: %v", err)
+	}
+	if _, err := cfg.thumbnailStore.Stat(context.Background(), "thumbnails/abc/64w.webp"); err == nil {
+		t.Errorf("produced a sibling for a replaced thumbnail")
+	}
+}
+
+func TestHandlerAssets(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		accept     string
+		wantStatus int
+		wantBody   string
+		wantVary   bool
+	}{
+		{"png for a webp client", "/assets/a/320w.png", "image/avif,image/webp,*/*", http.StatusOK, "webp", true},
+		{"png without an Accept header", "/assets/a/320w.png", "", http.StatusOK, "png", true},
+		{"png for an avif client without an avif sibling", "/assets/a/320w.png", "image/avif", http.StatusOK, "png", true},
+		{"png for a wildcard client", "/assets/a/320w.png", "image/*", http.StatusOK, "png", true},
+		{"png for a client refusing webp", "/assets/a/320w.png", "image/webp;q=0", http.StatusOK, "png", true},
+		{"jpeg with both siblings", "/assets/b/320w.jpg", "image/webp,image/avif", http.StatusOK, "avif", true},
+		{"video", "/assets/video.mp4", "image/webp", http.StatusOK, "mp4", false},
+		{"missing file", "/assets/c/320w.png", "image/webp", http.StatusNotFound, "", true},
+		{"directory", "/assets/a", "", http.StatusNotFound, "", false},
+		{"outside the root", "/assets/../secret.txt", "", http.StatusNotFound, "", false},
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"secret.txt":         "secret",
+		"assets/a/320w.png":  "png",
+		"assets/a/320w.webp": "webp",
+		"assets/b/320w.jpg":  "jpg",
+		"assets/b/320w.webp": "webp",
+		"assets/b/320w.avif": "avif",
+		"assets/video.mp4":   "mp4",
+	}
+	for name, content := range files {
+		full := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			cfg.assetsRoot = filepath.Join(dir, "assets")
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			cfg.handlerAssets(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if vary := rec.Header().Get("Vary") == "Accept"; vary != tt.wantVary {
+				t.Errorf("Vary: Accept = %v; want %v", vary, tt.wantVary)
+			}
+			if rec.Code == http.StatusOK && rec.Body.String() != tt.wantBody {
+				t.Errorf("served %q; want %q", rec.Body, tt.wantBody)
+			}
+		})
+	}
+}
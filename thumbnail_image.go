@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// Uploaded thumbnails are decoded and re-encoded at each configured width
+// rather than stored as sent. Re-encoding drops every metadata segment, EXIF
+// and GPS included, so the EXIF orientation is applied to the pixels first.
+
+const (
+	// maxThumbnailPixels rejects images that would take too much memory to
+	// decode (decompression bombs).
+	maxThumbnailPixels   = 50_000_000
+	thumbnailJPEGQuality = 85
+)
+
+var defaultThumbnailWidths = []int{160, 320, 640, 1280}
+
+// parseThumbnailWidths parses a comma-separated list of widths such as
+// "160,320,640".
+func parseThumbnailWidths(def string) ([]int, error) {
+	if strings.TrimSpace(def) == "" {
+		return defaultThumbnailWidths, nil
+	}
+	widths := []int{}
+	for _, part := range strings.Split(def, ",") {
+		w, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || w <= 0 {
+			return nil, fmt.Errorf("invalid width %q", part)
+		}
+		widths = append(widths, w)
+	}
+	slices.Sort(widths)
+	return slices.Compact(widths), nil
+}
+
+type thumbnailVariant struct {
+	width int
+	data  []byte
+}
+
+// processThumbnail decodes an uploaded JPEG or PNG and re-encodes it at every
+// width in widths that doesn't upscale it, smallest first. An image narrower
+// than every width is re-encoded at its own size.
+func processThumbnail(data []byte, mediaType string, widths []int) ([]thumbnailVariant, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: couldn't decode image: %v", errInvalidUpload, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("%w: image dimensions %dx%d are out of range", errInvalidUpload, cfg.Width, cfg.Height)
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: couldn't decode image: %v", errInvalidUpload, err)
+	}
+	img := toNRGBA(decoded)
+	if mediaType == "image/jpeg" {
+		img = applyOrientation(img, exifOrientation(data))
+	}
+
+	sourceWidth := img.Bounds().Dx()
+	targets := []int{}
+	for _, w := range widths {
+		if w <= sourceWidth {
+			targets = append(targets, w)
+		}
+	}
+	if len(targets) == 0 {
+		targets = append(targets, sourceWidth)
+	}
+
+	variants := make([]thumbnailVariant, 0, len(targets))
+	for _, w := range targets {
+		h := max(1, int(float64(img.Bounds().Dy())*float64(w)/float64(sourceWidth)+0.5))
+		resized := img
+		if w != sourceWidth {
+			resized = resizeBox(img, w, h)
+		}
+		var buf bytes.Buffer
+		if err := encodeThumbnail(&buf, resized, mediaType); err != nil {
+			return nil, err
+		}
+		variants = append(variants, thumbnailVariant{width: w, data: buf.Bytes()})
+	}
+	return variants, nil
+}
+
+func encodeThumbnail(w io.Writer, img image.Image, mediaType string) error {
+	switch mediaType {
+	case "image/jpeg":
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: thumbnailJPEGQuality})
+	case "image/png":
+		return (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(w, img)
+	}
+	return fmt.Errorf("%w %q", errUnsupportedMediaType, mediaType)
+}
+
+func toNRGBA(src image.Image) *image.NRGBA {
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	return dst
+}
+
+// resizeBox scales src to w x h by averaging the source pixels each
+// destination pixel covers, weighted by coverage and alpha. It's meant for
+// downscaling, where it doesn't alias like nearest-neighbour does.
+func resizeBox(src *image.NRGBA, w, h int) *image.NRGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	xScale := float64(sw) / float64(w)
+	yScale := float64(sh) / float64(h)
+
+	for dy := 0; dy < h; dy++ {
+		y0, y1 := float64(dy)*yScale, float64(dy+1)*yScale
+		for dx := 0; dx < w; dx++ {
+			x0, x1 := float64(dx)*xScale, float64(dx+1)*xScale
+
+			var r, g, b, a, weight float64
+			for sy := int(y0); sy < sh && float64(sy) < y1; sy++ {
+				wy := min(y1, float64(sy+1)) - max(y0, float64(sy))
+				for sx := int(x0); sx < sw && float64(sx) < x1; sx++ {
+					wx := min(x1, float64(sx+1)) - max(x0, float64(sx))
+					i := src.PixOffset(sx, sy)
+					pa := float64(src.Pix[i+3])
+					cw := wx * wy
+					r += float64(src.Pix[i]) * pa * cw
+					g += float64(src.Pix[i+1]) * pa * cw
+					b += float64(src.Pix[i+2]) * pa * cw
+					a += pa * cw
+					weight += cw
+				}
+			}
+
+			o := dst.PixOffset(dx, dy)
+			if a > 0 {
+				dst.Pix[o] = uint8(r/a + 0.5)
+				dst.Pix[o+1] = uint8(g/a + 0.5)
+				dst.Pix[o+2] = uint8(b/a + 0.5)
+			}
+			if weight > 0 {
+				dst.Pix[o+3] = uint8(a/weight + 0.5)
+			}
+		}
+	}
+	return dst
+}
+
+// exifOrientation returns the EXIF orientation (1-8) of a JPEG, or 1 if it
+// has none.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // image data starts, no EXIF before it
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if size < 2 || i+2+size > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// tiffOrientation reads the Orientation tag (0x0112) from IFD0 of a TIFF
+// structure.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:8]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd : ifd+2]))
+	for e := 0; e < entries; e++ {
+		off := ifd + 2 + e*12
+		if off+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[off:off+2]) == 0x0112 {
+			if o := int(order.Uint16(tiff[off+8 : off+10])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation turns an image stored with EXIF orientation o upright.
+func applyOrientation(src *image.NRGBA, o int) *image.NRGBA {
+	if o <= 1 || o > 8 {
+		return src
+	}
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := sw, sh
+	if o >= 5 { // orientations 5-8 swap the axes
+		dw, dh = sh, sw
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			var dx, dy int
+			switch o {
+			case 2: // mirrored
+				dx, dy = sw-1-x, y
+			case 3: // rotated 180
+				dx, dy = sw-1-x, sh-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, sh-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = sh-1-y, x
+			case 7: // transversed
+				dx, dy = sh-1-y, sw-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, sw-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+	return dst
+}
+
+// thumbnailWidths parses the widths recorded for a video's thumbnail.
+func thumbnailWidths(video database.Video) []int {
+	widths := []int{}
+	for _, part := range strings.Split(video.ThumbnailWidths, ",") {
+		if w, err := strconv.Atoi(part); err == nil {
+			widths = append(widths, w)
+		}
+	}
+	return widths
+}
+
+// thumbnailVariantKey is where the width variant of a thumbnail stored
+// under dir lives, e.g. "thumbnails/abc/320w.png".
+func thumbnailVariantKey(dir string, width int, ext string) string {
+	return path.Join(dir, fmt.Sprintf("%dw%s", width, ext))
+}
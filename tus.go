@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const tusVersion = "1.0.0"
+
+// tusUploads keeps the partial data of resumable uploads on local disk; their
+// offsets and ownership live in the uploads table.
+type tusUploads struct {
+	root    string
+	maxSize int64
+	ttl     time.Duration
+
+	mu    sync.Mutex
+	locks map[uuid.UUID]*uploadLock
+}
+
+// uploadLock is dropped from tusUploads.locks once nobody holds or waits for
+// it, so every request on an upload goes through the same mutex.
+type uploadLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newTusUploads(root string, maxSize int64, ttl time.Duration) (*tusUploads, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("couldn't create tus directory: %w", err)
+	}
+	return &tusUploads{
+		root:    root,
+		maxSize: maxSize,
+		ttl:     ttl,
+		locks:   map[uuid.UUID]*uploadLock{},
+	}, nil
+}
+
+func (t *tusUploads) dataPath(id uuid.UUID) string {
+	return filepath.Join(t.root, id.String())
+}
+
+// lock serialises PATCH/DELETE requests and expiry on the same upload.
+func (t *tusUploads) lock(id uuid.UUID) func() {
+	t.mu.Lock()
+	l, ok := t.locks[id]
+	if !ok {
+		l = &uploadLock{}
+		t.locks[id] = l
+	}
+	l.refs++
+	t.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		t.mu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(t.locks, id)
+		}
+		t.mu.Unlock()
+	}
+}
+
+// remove deletes the upload's data. The caller holds its lock.
+func (t *tusUploads) remove(id uuid.UUID) error {
+	err := os.Remove(t.dataPath(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// moveFile renames src to dst, copying when they're on different
+// filesystems.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// tusFileType is the media type a client declared in the upload metadata;
+// MP4 if it didn't.
+func tusFileType(metadata map[string]string) string {
+	if filetype, ok := metadata["filetype"]; ok && filetype != "" {
+		return filetype
+	}
+	return "video/mp4"
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated
+// "key base64value" pairs, where the value may be omitted.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata value for %q: %w", key, err)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func (cfg *apiConfig) cleanupExpiredUploads() error {
+	uploads, err := cfg.db.GetExpiredUploads(time.Now())
+	if err != nil {
+		return err
+	}
+	for _, upload := range uploads {
+		if err := cfg.expireUpload(upload.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expireUpload drops an expired upload once no request is writing to it. A
+// PATCH that was in flight may have completed it in the meantime.
+func (cfg *apiConfig) expireUpload(id uuid.UUID) error {
+	unlock := cfg.tus.lock(id)
+	defer unlock()
+
+	upload, err := cfg.db.GetUpload(id)
+	if err != nil {
+		return err
+	}
+	if upload.ID == uuid.Nil || time.Now().Before(upload.ExpiresAt) {
+		return nil
+	}
+	if err := cfg.db.DeleteUpload(id); err != nil {
+		return err
+	}
+	return cfg.tus.remove(id)
+}
+
+func (cfg *apiConfig) runUploadExpirer(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := cfg.cleanupExpiredUploads(); err != nil {
+			log.Printf("Couldn't clean up expired uploads: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/blobstore"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// urlResolver turns a stored object key into the URL handed to clients.
+// URLs are computed per response so changing the CDN domain or port
+// doesn't invalidate anything stored in the database.
+type urlResolver interface {
+	ResolveURL(ctx context.Context, key string) (string, error)
+}
+
+type storeURLResolver struct {
+	store blobstore.BlobStore
+}
+
+func (s storeURLResolver) ResolveURL(ctx context.Context, key string) (string, error) {
+	return s.store.URL(key), nil
+}
+
+// presignedURLResolver hands out time-limited GET URLs generated per request,
+// so objects can live in a private bucket.
+type presignedURLResolver struct {
+	presigner blobstore.Presigner
+	expiry    time.Duration
+}
+
+func (p presignedURLResolver) ResolveURL(ctx context.Context, key string) (string, error) {
+	return p.presigner.PresignGet(ctx, key, p.expiry)
+}
+
+// resolveVideoURLs fills in the delivery URLs of video from its stored keys.
+// Rows that predate keys keep whatever URL was stored.
+func (cfg *apiConfig) resolveVideoURLs(ctx context.Context, video database.Video) (database.Video, error) {
+	if video.VideoKey != nil {
+		u, err := cfg.videoURLResolver.ResolveURL(ctx, *video.VideoKey)
+		if err != nil {
+			return database.Video{}, err
+		}
+		video.VideoURL = &u
+	}
+	if video.OriginalKey != nil {
+		u, err := cfg.videoURLResolver.ResolveURL(ctx, *video.OriginalKey)
+		if err != nil {
+			return database.Video{}, err
+		}
+		video.OriginalURL = &u
+	}
+	if video.HLSKey != nil {
+		u, err := cfg.videoURLResolver.ResolveURL(ctx, *video.HLSKey)
+		if err != nil {
+			return database.Video{}, err
+		}
+		video.HLSURL = &u
+	}
+	if video.DASHKey != nil {
+		u, err := cfg.videoURLResolver.ResolveURL(ctx, *video.DASHKey)
+		if err != nil {
+			return database.Video{}, err
+		}
+		video.DASHURL = &u
+	}
+	if video.SpriteVTTKey != nil {
+		u, err := cfg.videoURLResolver.ResolveURL(ctx, *video.SpriteVTTKey)
+		if err != nil {
+			return database.Video{}, err
+		}
+		if _, presigned := cfg.videoURLResolver.(presignedURLResolver); presigned {
+			// the stored index references its sheets by relative path
+			u = spriteVTTPath(video.ID)
+		}
+		video.SpriteVTTURL = &u
+		video.SpriteURLs = []string{}
+		for _, key := range spriteSheetKeys(*video.SpriteVTTKey, video.SpriteSheetCount) {
+			u, err := cfg.videoURLResolver.ResolveURL(ctx, key)
+			if err != nil {
+				return database.Video{}, err
+			}
+			video.SpriteURLs = append(video.SpriteURLs, u)
+		}
+	}
+	if video.ThumbnailKey != nil {
+		u, err := cfg.thumbnailURLResolver.ResolveURL(ctx, *video.ThumbnailKey)
+		if err != nil {
+			return database.Video{}, err
+		}
+		video.ThumbnailURL = &u
+
+		if widths := thumbnailWidths(video); len(widths) > 0 {
+			video.ThumbnailSrcset = map[string]string{}
+			for _, w := range widths {
+				key := thumbnailVariantKey(path.Dir(*video.ThumbnailKey), w, path.Ext(*video.ThumbnailKey))
+				u, err := cfg.thumbnailURLResolver.ResolveURL(ctx, key)
+				if err != nil {
+					return database.Video{}, err
+				}
+				video.ThumbnailSrcset[fmt.Sprintf("%dw", w)] = u
+			}
+			for _, format := range strings.Split(video.ThumbnailFormats, ",") {
+				mediaType := thumbnailFormatMediaType("." + format)
+				if mediaType == "" {
+					continue
+				}
+				if video.ThumbnailSources == nil {
+					video.ThumbnailSources = map[string]map[string]string{}
+				}
+				srcset := map[string]string{}
+				for _, w := range widths {
+					key := thumbnailVariantKey(path.Dir(*video.ThumbnailKey), w, "."+format)
+					u, err := cfg.thumbnailURLResolver.ResolveURL(ctx, key)
+					if err != nil {
+						return database.Video{}, err
+					}
+					srcset[fmt.Sprintf("%dw", w)] = u
+				}
+				video.ThumbnailSources[mediaType] = srcset
+			}
+		}
+	}
+	return video, nil
+}
+
+func (cfg *apiConfig) resolveVideosURLs(ctx context.Context, videos []database.Video) ([]database.Video, error) {
+	resolved := make([]database.Video, 0, len(videos))
+	for _, video := range videos {
+		v, err := cfg.resolveVideoURLs(ctx, video)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, v)
+	}
+	return resolved, nil
+}
+
+// keyFromURL recovers the storage key from a URL that was stored before keys
+// were. It understands URLs handed out by store.URL, the local assets
+// server's /assets/ URLs (on any port) and S3/CloudFront URLs.
+func keyFromURL(store blobstore.BlobStore, rawURL string) (string, bool) {
+	if base := store.URL(""); strings.HasPrefix(rawURL, base) {
+		key := strings.TrimPrefix(rawURL, base)
+		return key, key != ""
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	p := strings.TrimPrefix(u.Path, "/")
+	if isLoopbackHost(u.Hostname()) {
+		if key, ok := strings.CutPrefix(p, "assets/"); ok {
+			return key, key != ""
+		}
+		return "", false
+	}
+	if strings.HasSuffix(u.Host, ".amazonaws.com") || strings.HasSuffix(u.Host, ".cloudfront.net") {
+		return p, p != ""
+	}
+	return "", false
+}
+
+// isLoopbackHost reports whether host is the machine the local assets server
+// ran on. Anywhere else, assets/ is part of the key.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (cfg *apiConfig) migrateVideoURLsToKeys() (int, error) {
+	return cfg.db.MigrateVideoURLsToKeys(
+		func(u string) (string, bool) { return keyFromURL(cfg.videoStore, u) },
+		func(u string) (string, bool) { return keyFromURL(cfg.thumbnailStore, u) },
+	)
+}
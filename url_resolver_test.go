@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/blobstore"
+)
+
+func TestKeyFromURL(t *testing.T) {
+	store := blobstore.NewMemory("http://localhost:8091/assets")
+	tests := []struct {
+		name   string
+		rawURL string
+		want   string
+		ok     bool
+	}{
+		{"current store URL", "http://localhost:8091/assets/thumbnails/a.png", "thumbnails/a.png", true},
+		{"local assets on another port", "http://localhost:8080/assets/thumbnails/a.png", "thumbnails/a.png", true},
+		{"local assets by loopback IP", "http://127.0.0.1:8080/assets/a.png", "a.png", true},
+		{"local URL outside assets", "http://localhost:8080/other/a.png", "", false},
+		{"S3 key under assets/", "https://tubely.s3.us-east-2.amazonaws.com/assets/landscape/a.mp4", "assets/landscape/a.mp4", true},
+		{"CloudFront key under assets/", "https://d111.cloudfront.net/assets/landscape/a.mp4", "assets/landscape/a.mp4", true},
+		{"CloudFront key", "https://d111.cloudfront.net/landscape/a.mp4", "landscape/a.mp4", true},
+		{"other host under assets/", "https://example.com/assets/a.png", "", false},
+		{"store base alone", "http://localhost:8091/assets/", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := keyFromURL(store, tt.rawURL)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("keyFromURL(%q) = %q, %v; want %q, %v", tt.rawURL, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+)
+
+// packageDASH encodes the video at inputPath into every rung of ladder as
+// fMP4 segments under outDir, with one adaptation set for the video
+// representations and one for audio. It returns the manifest's name relative
+// to outDir.
+func packageDASH(ctx context.Context, inputPath, outDir string, ladder []rendition, probe probeResult, onProgress func(percent float64)) (string, error) {
+	const manifestName = "manifest.mpd"
+
+	args := []string{"-i", inputPath}
+	for range ladder {
+		args = append(args, "-map", "0:v:0")
+	}
+	if probe.hasAudio {
+		args = append(args, "-map", "0:a:0")
+	}
+
+	segmentSecs, audioKbps := 0, 0
+	args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main", "-sc_threshold", "0")
+	for i, r := range ladder {
+		stream := strconv.Itoa(i)
+		width, height := r.dimensions(probe)
+		args = append(args,
+			"-filter:v:"+stream, fmt.Sprintf("scale=%d:%d,setsar=1", width, height),
+			"-b:v:"+stream, fmt.Sprintf("%dk", r.VideoKbps),
+			"-maxrate:v:"+stream, fmt.Sprintf("%dk", r.maxrateKbps()),
+			"-bufsize:v:"+stream, fmt.Sprintf("%dk", r.VideoKbps*3/2),
+		)
+		segmentSecs = max(segmentSecs, r.SegmentSecs)
+		audioKbps = max(audioKbps, r.AudioKbps)
+	}
+	// keyframes on segment boundaries so every representation switches cleanly
+	args = append(args, "-force_key_frames", keyframeExpr(segmentSecs))
+
+	adaptationSets := "id=0,streams=v"
+	if probe.hasAudio {
+		args = append(args, "-c:a", "aac", "-b:a", fmt.Sprintf("%dk", audioKbps), "-ac", "2")
+		adaptationSets += " id=1,streams=a"
+	}
+
+	args = append(args,
+		"-f", "dash",
+		"-seg_duration", strconv.Itoa(segmentSecs),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		"-adaptation_sets", adaptationSets,
+		filepath.Join(outDir, manifestName),
+	)
+
+	if err := runFFmpeg(ctx, args, probe.duration, onProgress); err != nil {
+		return "", fmt.Errorf("error packaging DASH: %w", err)
+	}
+	return manifestName, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	videoEventUploaded   = "uploaded"
+	videoEventProbing    = "probing"
+	videoEventProcessing = "processing"
+	videoEventStored     = "stored"
+	videoEventFailed     = "failed"
+)
+
+// The stages a processing event's percent refers to.
+const (
+	progressStageFastStart = "faststart"
+	progressStageHLS       = "hls"
+	progressStageDASH      = "dash"
+	progressStageSprites   = "sprites"
+)
+
+type videoEvent struct {
+	Type      string    `json:"type"`
+	Stage     string    `json:"stage,omitempty"`
+	Percent   *float64  `json:"percent,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	WillRetry bool      `json:"will_retry,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// terminal reports whether nothing follows the event until the video is
+// uploaded again.
+func (ev videoEvent) terminal() bool {
+	return ev.Type == videoEventStored || (ev.Type == videoEventFailed && !ev.WillRetry)
+}
+
+func (ev videoEvent) progress() bool {
+	return ev.Type == videoEventProcessing && ev.Percent != nil
+}
+
+// videoSubscriber queues the events for one client. Publishing never blocks
+// on a slow client: consecutive progress events collapse into the latest,
+// and every other event is kept until the client reads it.
+type videoSubscriber struct {
+	mu     sync.Mutex
+	queue  []videoEvent
+	notify chan struct{}
+}
+
+func (s *videoSubscriber) push(ev videoEvent) {
+	s.mu.Lock()
+	if n := len(s.queue); n > 0 && ev.progress() && s.queue[n-1].progress() {
+		s.queue[n-1] = ev
+	} else {
+		s.queue = append(s.queue, ev)
+	}
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+		// already signalled
+	}
+}
+
+// ready is signalled when events are waiting to be taken.
+func (s *videoSubscriber) ready() <-chan struct{} {
+	return s.notify
+}
+
+// take returns the waiting events in the order they were published.
+func (s *videoSubscriber) take() []videoEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := s.queue
+	s.queue = nil
+	return events
+}
+
+// videoEvents fans processing state transitions out to the clients watching
+// a video. It only lives in memory; the durable state is the video's
+// processing_status.
+type videoEvents struct {
+	mu   sync.Mutex
+	subs map[uuid.UUID]map[*videoSubscriber]struct{}
+	last map[uuid.UUID]videoEvent
+}
+
+func newVideoEvents() *videoEvents {
+	return &videoEvents{
+		subs: map[uuid.UUID]map[*videoSubscriber]struct{}{},
+		last: map[uuid.UUID]videoEvent{},
+	}
+}
+
+func (e *videoEvents) publish(videoID uuid.UUID, event videoEvent) {
+	event.Time = time.Now().UTC()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if event.terminal() {
+		delete(e.last, videoID)
+	} else {
+		e.last[videoID] = event
+	}
+	for sub := range e.subs[videoID] {
+		sub.push(event)
+	}
+}
+
+func (e *videoEvents) publishProgress(videoID uuid.UUID, stage string, percent float64) {
+	e.publish(videoID, videoEvent{Type: videoEventProcessing, Stage: stage, Percent: &percent})
+}
+
+// subscribe returns a subscriber to events for videoID, the most recent
+// in-flight event if any, and a function to unsubscribe.
+func (e *videoEvents) subscribe(videoID uuid.UUID) (*videoSubscriber, *videoEvent, func()) {
+	sub := &videoSubscriber{notify: make(chan struct{}, 1)}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.subs[videoID] == nil {
+		e.subs[videoID] = map[*videoSubscriber]struct{}{}
+	}
+	e.subs[videoID][sub] = struct{}{}
+
+	var last *videoEvent
+	if ev, ok := e.last[videoID]; ok {
+		last = &ev
+	}
+
+	return sub, last, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		delete(e.subs[videoID], sub)
+		if len(e.subs[videoID]) == 0 {
+			delete(e.subs, videoID)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestVideoEventsSlowSubscriber(t *testing.T) {
+	events := newVideoEvents()
+	videoID := uuid.New()
+	sub, _, unsubscribe := events.subscribe(videoID)
+	defer unsubscribe()
+
+	// nothing is read until everything has been published
+	events.publish(videoID, videoEvent{Type: videoEventProbing})
+	for i := range 1000 {
+		events.publishProgress(videoID, progressStageFastStart, float64(i)/10)
+	}
+	events.publishProgress(videoID, progressStageHLS, 50)
+	events.publish(videoID, videoEvent{Type: videoEventFailed, Reason: "boom", WillRetry: true})
+	events.publish(videoID, videoEvent{Type: videoEventStored})
+
+	select {
+	case <-sub.ready():
+	default:
+		t.Fatal("subscriber wasn't signalled")
+	}
+	got := sub.take()
+	want := []struct {
+		eventType, stage string
+		percent          float64
+	}{
+		{videoEventProbing, "", 0},
+		{videoEventProcessing, progressStageHLS, 50},
+		{videoEventFailed, "", 0},
+		{videoEventStored, "", 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("took %d events, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		ev := got[i]
+		if ev.Type != w.eventType || ev.Stage != w.stage || (ev.Percent != nil && *ev.Percent != w.percent) {
+			t.Errorf("event %d = %+v; want %+v", i, ev, w)
+		}
+	}
+	if !got[3].terminal() || got[2].terminal() {
+		t.Errorf("terminal() of failed with retry or stored is wrong")
+	}
+	if rest := sub.take(); len(rest) != 0 {
+		t.Errorf("second take = %+v; want nothing", rest)
+	}
+}
+
+func TestVideoEventsLastInFlight(t *testing.T) {
+	events := newVideoEvents()
+	videoID := uuid.New()
+
+	events.publishProgress(videoID, progressStageDASH, 20)
+	_, last, unsubscribe := events.subscribe(videoID)
+	unsubscribe()
+	if last == nil || last.Stage != progressStageDASH {
+		t.Fatalf("last = %+v; want the DASH progress event", last)
+	}
+
+	events.publish(videoID, videoEvent{Type: videoEventStored})
+	_, last, unsubscribe = events.subscribe(videoID)
+	unsubscribe()
+	if last != nil {
+		t.Errorf("last after stored = %+v; want none", last)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// keyframeExpr forces a keyframe every segmentSecs of presentation time,
+// whatever the frame rate, so segments can be cut on their boundaries.
+func keyframeExpr(segmentSecs int) string {
+	return fmt.Sprintf("expr:gte(t,n_forced*%d)", segmentSecs)
+}
+
+// transcodeHLS encodes the video at inputPath into every rung of ladder under
+// outDir and writes a master playlist. It returns the master playlist's name
+// relative to outDir. Renditions are encoded one after another; onProgress
+// sees the share of all of them done.
+func transcodeHLS(ctx context.Context, inputPath, outDir string, ladder []rendition, probe probeResult, onProgress func(percent float64)) (string, error) {
+	for i, r := range ladder {
+		width, height := r.dimensions(probe)
+		level := r.h264Level(probe)
+
+		args := []string{
+			"-i", inputPath,
+			"-vf", fmt.Sprintf("scale=%d:%d,setsar=1", width, height),
+			"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main",
+			"-level:v", fmt.Sprintf("%d.%d", level/10, level%10),
+			"-b:v", fmt.Sprintf("%dk", r.VideoKbps),
+			"-maxrate", fmt.Sprintf("%dk", r.maxrateKbps()),
+			"-bufsize", fmt.Sprintf("%dk", r.VideoKbps*3/2),
+			"-force_key_frames", keyframeExpr(r.SegmentSecs), "-sc_threshold", "0",
+			"-c:a", "aac", "-b:a", fmt.Sprintf("%dk", r.AudioKbps), "-ac", "2",
+			"-f", "hls",
+			"-hls_time", strconv.Itoa(r.SegmentSecs),
+			"-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(outDir, r.name()+"_%04d.ts"),
+			filepath.Join(outDir, r.playlistName()),
+		}
+		err := runFFmpeg(ctx, args, probe.duration, func(percent float64) {
+			if onProgress != nil {
+				onProgress((float64(i)*100 + percent) / float64(len(ladder)))
+			}
+		})
+		if err != nil {
+			return "", fmt.Errorf("error transcoding %s rendition: %w", r.name(), err)
+		}
+	}
+
+	const masterName = "master.m3u8"
+	if err := os.WriteFile(filepath.Join(outDir, masterName), hlsMasterPlaylist(ladder, probe), 0644); err != nil {
+		return "", fmt.Errorf("could not write master playlist: %w", err)
+	}
+	return masterName, nil
+}
+
+func (r rendition) playlistName() string {
+	return r.name() + ".m3u8"
+}
+
+// hlsMasterPlaylist lists every rendition of ladder with its bandwidth,
+// resolution and codecs.
+func hlsMasterPlaylist(ladder []rendition, probe probeResult) []byte {
+	master := bytes.Buffer{}
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, r := range ladder {
+		width, height := r.dimensions(probe)
+		bandwidth := (r.VideoKbps + r.AudioKbps) * 1000
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"\n%s\n",
+			bandwidth, width, height, r.codecs(r.h264Level(probe), probe.hasAudio), r.playlistName())
+	}
+	return master.Bytes()
+}
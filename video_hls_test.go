@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestTranscodeHLSSegmentsAtNonThirtyFPS checks segments are cut on the
+// configured boundaries for a frame rate a GOP of SegmentSecs*30 frames would
+// get wrong.
+func TestTranscodeHLSSegmentsAtNonThirtyFPS(t *testing.T) {
+	requireFFmpeg(t)
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.mp4")
+	cmd := exec.Command("ffmpeg", "-f", "lavfi", "-i", "testsrc=duration=8:size=320x180:rate=24",
+		"-c:v", "libx264", "-y", input)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generating input: %v\n%s", err, out)
+	}
+	probe, err := probeVideo(context.Background(), input)
+	if err != nil {
+		t.Fatalf("probeVideo: %v", err)
+	}
+
+	ladder := []rendition{{ShortSide: 180, VideoKbps: 300, AudioKbps: 64, SegmentSecs: 2}}
+	if _, err := transcodeHLS(context.Background(), input, dir, ladder, probe, nil); err != nil {
+		t.Fatalf("transcodeHLS: %v", err)
+	}
+
+	playlist, err := os.ReadFile(filepath.Join(dir, "180p.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments := 0
+	for _, line := range strings.Split(string(playlist), "\n") {
+		v, ok := strings.CutPrefix(line, "#EXTINF:")
+		if !ok {
+			continue
+		}
+		secs, err := strconv.ParseFloat(strings.TrimSuffix(v, ","), 64)
+		if err != nil {
+			t.Fatalf("EXTINF %q: %v", v, err)
+		}
+		if math.Abs(secs-2) > 0.05 {
+			t.Errorf("segment %d lasts %.3fs; want 2s", segments, secs)
+		}
+		segments++
+	}
+	if segments != 4 {
+		t.Errorf("got %d segments; want 4\n%s", segments, playlist)
+	}
+}
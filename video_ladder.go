@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rendition is one rung of an adaptive-bitrate ladder. ShortSide names it,
+// as in "1080p": it is the height of a landscape frame and the width of a
+// portrait one.
+type rendition struct {
+	ShortSide   int
+	VideoKbps   int
+	AudioKbps   int
+	SegmentSecs int
+}
+
+var defaultLadder = []rendition{
+	{ShortSide: 1080, VideoKbps: 5000, AudioKbps: 128, SegmentSecs: 6},
+	{ShortSide: 720, VideoKbps: 2800, AudioKbps: 128, SegmentSecs: 6},
+	{ShortSide: 480, VideoKbps: 1400, AudioKbps: 96, SegmentSecs: 6},
+	{ShortSide: 360, VideoKbps: 800, AudioKbps: 64, SegmentSecs: 6},
+}
+
+func (r rendition) name() string {
+	return fmt.Sprintf("%dp", r.ShortSide)
+}
+
+// parseLadder parses a ladder definition such as "1080:5000,720:2800" or
+// "720:2800:128" (short side:video kbps[:audio kbps]). "off" yields an empty
+// ladder.
+func parseLadder(def string) ([]rendition, error) {
+	def = strings.TrimSpace(def)
+	if def == "" {
+		return defaultLadder, nil
+	}
+	if def == "off" {
+		return nil, nil
+	}
+
+	ladder := []rendition{}
+	for _, rung := range strings.Split(def, ",") {
+		parts := strings.Split(strings.TrimSpace(rung), ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid rung %q, want shortSide:videoKbps[:audioKbps]", rung)
+		}
+		values := make([]int, len(parts))
+		for i, p := range parts {
+			v, err := strconv.Atoi(p)
+			if err != nil || v <= 0 {
+				return nil, fmt.Errorf("invalid rung %q", rung)
+			}
+			values[i] = v
+		}
+		r := rendition{ShortSide: values[0], VideoKbps: values[1], AudioKbps: 128, SegmentSecs: 6}
+		if len(values) == 3 {
+			r.AudioKbps = values[2]
+		}
+		ladder = append(ladder, r)
+	}
+	sort.Slice(ladder, func(i, j int) bool { return ladder[i].ShortSide > ladder[j].ShortSide })
+	return ladder, nil
+}
+
+// capLadder drops rungs above the source's short side so nothing is
+// upscaled. A source smaller than every rung gets a single rung at its own
+// size.
+func capLadder(ladder []rendition, probe probeResult) []rendition {
+	shortSide := min(probe.width, probe.height)
+	capped := []rendition{}
+	for _, r := range ladder {
+		if r.ShortSide <= shortSide {
+			capped = append(capped, r)
+		}
+	}
+	if len(capped) == 0 && len(ladder) > 0 && shortSide > 0 {
+		smallest := ladder[len(ladder)-1]
+		smallest.ShortSide = shortSide - shortSide%2
+		capped = append(capped, smallest)
+	}
+	return capped
+}
+
+// dimensions is the rendition's frame size for a source of the probed
+// display dimensions: the short side is the rung's and the long side keeps
+// the source's aspect ratio.
+func (r rendition) dimensions(probe probeResult) (width, height int) {
+	if probe.height > probe.width {
+		return r.ShortSide, evenDimension(float64(r.ShortSide) * float64(probe.height) / float64(probe.width))
+	}
+	return evenDimension(float64(r.ShortSide) * float64(probe.width) / float64(probe.height)), r.ShortSide
+}
+
+// h264Levels are the H.264 levels with their frame size (macroblocks),
+// macroblock rate and Main profile bitrate (kbps) limits.
+var h264Levels = []struct {
+	idc, maxFS, maxMBPS, maxKbps int
+}{
+	{10, 99, 1485, 64},
+	{11, 396, 3000, 192},
+	{12, 396, 6000, 384},
+	{13, 396, 11880, 768},
+	{20, 396, 11880, 2000},
+	{21, 792, 19800, 4000},
+	{22, 1620, 20250, 4000},
+	{30, 1620, 40500, 10000},
+	{31, 3600, 108000, 14000},
+	{32, 5120, 216000, 20000},
+	{40, 8192, 245760, 20000},
+	{41, 8192, 245760, 50000},
+	{42, 8704, 522240, 50000},
+	{50, 22080, 589824, 135000},
+	{51, 36864, 983040, 240000},
+	{52, 36864, 2073600, 240000},
+}
+
+// h264Level is the lowest H.264 level (as level_idc, 31 for 3.1) that fits
+// the rendition at the source's frame rate, taken as 30 if unknown.
+func (r rendition) h264Level(probe probeResult) int {
+	fps := probe.info.FrameRate
+	if fps <= 0 {
+		fps = 30
+	}
+	width, height := r.dimensions(probe)
+	mbWidth, mbHeight := (width+15)/16, (height+15)/16
+	frameSize := mbWidth * mbHeight
+	for _, l := range h264Levels {
+		// neither side may exceed sqrt(8*MaxFS) macroblocks
+		if frameSize <= l.maxFS && mbWidth*mbWidth <= 8*l.maxFS && mbHeight*mbHeight <= 8*l.maxFS &&
+			float64(frameSize)*fps <= float64(l.maxMBPS) && r.maxrateKbps() <= l.maxKbps {
+			return l.idc
+		}
+	}
+	return h264Levels[len(h264Levels)-1].idc
+}
+
+// maxrateKbps caps the encoder's bitrate a little above the target.
+func (r rendition) maxrateKbps() int {
+	return r.VideoKbps * 107 / 100
+}
+
+// codecs is the RFC 6381 CODECS value of the rendition encoded as H.264 Main
+// at level, with AAC-LC audio if the source has any.
+func (r rendition) codecs(level int, hasAudio bool) string {
+	codecs := fmt.Sprintf("avc1.4d40%02x", level)
+	if hasAudio {
+		codecs += ",mp4a.40.2"
+	}
+	return codecs
+}
+
+func evenDimension(v float64) int {
+	n := int(v + 0.5)
+	return n - n%2
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+func TestParseLadder(t *testing.T) {
+	ladder, err := parseLadder("480:1400:96, 1080:5000")
+	if err != nil {
+		t.Fatalf("parseLadder: %v", err)
+	}
+	want := []rendition{
+		{ShortSide: 1080, VideoKbps: 5000, AudioKbps: 128, SegmentSecs: 6},
+		{ShortSide: 480, VideoKbps: 1400, AudioKbps: 96, SegmentSecs: 6},
+	}
+	if !slices.Equal(ladder, want) {
+		t.Errorf("parseLadder = %+v; want %+v", ladder, want)
+	}
+	if ladder, err := parseLadder("off"); err != nil || len(ladder) != 0 {
+		t.Errorf("parseLadder(off) = %+v, %v; want an empty ladder", ladder, err)
+	}
+	for _, def := range []string{"1080", "1080:x", "1080:0", "1080:5000:128:6"} {
+		if _, err := parseLadder(def); err == nil {
+			t.Errorf("parseLadder(%q) succeeded", def)
+		}
+	}
+}
+
+func TestCapLadder(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		// want lists the rungs as WxH
+		want []string
+	}{
+		{"1080p landscape", 1920, 1080, []string{"1920x1080", "1280x720", "852x480", "640x360"}},
+		{"1080p portrait", 1080, 1920, []string{"1080x1920", "720x1280", "480x852", "360x640"}},
+		{"720p portrait", 720, 1280, []string{"720x1280", "480x852", "360x640"}},
+		{"1920x1088", 1920, 1088, []string{"1906x1080", "1270x720", "846x480", "634x360"}},
+		{"square", 1080, 1080, []string{"1080x1080", "720x720", "480x480", "360x360"}},
+		{"4K portrait", 2160, 3840, []string{"1080x1920", "720x1280", "480x852", "360x640"}},
+		{"smaller than every rung", 320, 240, []string{"320x240"}},
+		{"small odd portrait", 241, 427, []string{"240x424"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probe := probeResult{width: tt.width, height: tt.height}
+			got := []string{}
+			for _, r := range capLadder(defaultLadder, probe) {
+				width, height := r.dimensions(probe)
+				got = append(got, fmt.Sprintf("%dx%d", width, height))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ladder for %dx%d = %v; want %v", tt.width, tt.height, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestH264Level(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		fps           float64
+		rung          rendition
+		want          int
+	}{
+		{"360p", 1920, 1080, 30, defaultLadder[3], 30},
+		{"720p30", 1920, 1080, 30, defaultLadder[1], 31},
+		{"720p60", 1920, 1080, 60, defaultLadder[1], 32},
+		{"1080p30", 1920, 1080, 30, defaultLadder[0], 40},
+		{"1080p portrait", 1080, 1920, 30, defaultLadder[0], 40},
+		{"1080p60", 1920, 1080, 60, defaultLadder[0], 42},
+		{"unknown frame rate", 1920, 1080, 0, defaultLadder[0], 40},
+		{"high bitrate", 1920, 1080, 30, rendition{ShortSide: 1080, VideoKbps: 25000}, 41},
+		{"2160p", 3840, 2160, 30, rendition{ShortSide: 2160, VideoKbps: 16000}, 51},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probe := probeResult{width: tt.width, height: tt.height, info: database.MediaInfo{FrameRate: tt.fps}}
+			if got := tt.rung.h264Level(probe); got != tt.want {
+				t.Errorf("h264Level = %d; want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHLSMasterPlaylist(t *testing.T) {
+	probe := probeResult{width: 1080, height: 1920, hasAudio: true, info: database.MediaInfo{FrameRate: 30}}
+	got := string(hlsMasterPlaylist(capLadder(defaultLadder, probe)[:2], probe))
+	want := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:3",
+		`#EXT-X-STREAM-INF:BANDWIDTH=5128000,RESOLUTION=1080x1920,CODECS="avc1.4d4028,mp4a.40.2"`,
+		"1080p.m3u8",
+		`#EXT-X-STREAM-INF:BANDWIDTH=2928000,RESOLUTION=720x1280,CODECS="avc1.4d401f,mp4a.40.2"`,
+		"720p.m3u8",
+		"",
+	}, "\n")
+	if got != want {
+		t.Errorf("master playlist =\n%s\nwant\n%s", got, want)
+	}
+
+	probe.hasAudio = false
+	if got := string(hlsMasterPlaylist(defaultLadder[3:], probe)); !strings.Contains(got, `CODECS="avc1.4d401e"`) {
+		t.Errorf("master playlist without audio =\n%s\nwant video-only CODECS", got)
+	}
+}
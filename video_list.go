@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultVideoPageSize = 20
+	maxVideoPageSize     = 100
+)
+
+// videoCursor is what an X-Next-Cursor token encodes. It carries the sort it
+// was issued for, so it can't be replayed against a different order.
+type videoCursor struct {
+	Sort      string `json:"s"`
+	Ascending bool   `json:"a,omitempty"`
+	database.VideoCursor
+}
+
+func encodeVideoCursor(sort string, ascending bool, cursor database.VideoCursor) string {
+	data, _ := json.Marshal(videoCursor{Sort: sort, Ascending: ascending, VideoCursor: cursor})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeVideoCursor(token string) (videoCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return videoCursor{}, errors.New("malformed cursor")
+	}
+	var cursor videoCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == uuid.Nil {
+		return videoCursor{}, errors.New("malformed cursor")
+	}
+	return cursor, nil
+}
+
+// parseListVideosParams reads the query of GET /api/videos:
+//
+//	limit           page size, 1-100 (default 20)
+//	cursor          X-Next-Cursor of the previous page
+//	sort            created, updated, title or duration (default created)
+//	order           asc or desc (default desc, asc for title)
+//	has_video       true or false
+//	has_thumbnail   true or false
+//	created_after   RFC 3339 time, inclusive
+//	created_before  RFC 3339 time, exclusive
+func parseListVideosParams(userID uuid.UUID, query url.Values) (database.ListVideosParams, error) {
+	params := database.ListVideosParams{UserID: userID, Limit: defaultVideoPageSize}
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxVideoPageSize {
+			return params, fmt.Errorf("limit must be between 1 and %d", maxVideoPageSize)
+		}
+		params.Limit = limit
+	}
+
+	params.Sort = query.Get("sort")
+	switch params.Sort {
+	case "":
+		params.Sort = database.VideoSortCreated
+	case database.VideoSortCreated, database.VideoSortUpdated, database.VideoSortTitle, database.VideoSortDuration:
+	default:
+		return params, fmt.Errorf("unknown sort %q", params.Sort)
+	}
+
+	switch query.Get("order") {
+	case "":
+		params.Ascending = params.Sort == database.VideoSortTitle
+	case "asc":
+		params.Ascending = true
+	case "desc":
+	default:
+		return params, fmt.Errorf("order must be asc or desc")
+	}
+
+	for name, dst := range map[string]**bool{
+		"has_video":     &params.HasVideo,
+		"has_thumbnail": &params.HasThumbnail,
+	} {
+		if v := query.Get(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return params, fmt.Errorf("%s must be true or false", name)
+			}
+			*dst = &b
+		}
+	}
+
+	for name, dst := range map[string]**time.Time{
+		"created_after":  &params.CreatedAfter,
+		"created_before": &params.CreatedBefore,
+	} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return params, fmt.Errorf("%s must be an RFC 3339 time", name)
+			}
+			*dst = &t
+		}
+	}
+
+	if token := query.Get("cursor"); token != "" {
+		cursor, err := decodeVideoCursor(token)
+		if err != nil {
+			return params, err
+		}
+		if cursor.Sort != params.Sort || cursor.Ascending != params.Ascending {
+			return params, errors.New("cursor was issued for a different sort order")
+		}
+		params.After = &cursor.VideoCursor
+	}
+	return params, nil
+}
+
+// listAllVideos pages through every video matching params, for clients
+// that don't ask for a page.
+func (cfg *apiConfig) listAllVideos(params database.ListVideosParams) (database.VideoPage, error) {
+	params.Limit = maxVideoPageSize
+	all := database.VideoPage{Videos: []database.Video{}}
+	for {
+		page, err := cfg.db.ListVideos(params)
+		if err != nil {
+			return database.VideoPage{}, err
+		}
+		all.Videos = append(all.Videos, page.Videos...)
+		if page.Next == nil {
+			return all, nil
+		}
+		params.After = page.Next
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// The upload pipeline runs in explicit stages, each taking the previous
+// stage's output:
+//
+//	receive  -> local copy of the upload in the staging directory
+//	validate -> upload's sniffed content is an acceptable type matching
+//	            the declared one
+//	probe    -> media info, storage prefix from the display aspect ratio
+//	process  -> faststart MP4 of the received file, remuxed or transcoded
+//	store    -> processed file in the video store under its key, and the
+//	            original beside it if processing changed the container or codecs
+//	hls      -> HLS ladder stored under the key's artifact prefix
+//	dash     -> DASH manifest and segments, when enabled
+//	sprites  -> scrubbing preview sprite sheets and their WebVTT index
+//	thumbs   -> candidate thumbnails extracted from the processed file
+//	record   -> keys, candidates and media info saved on the video
+//
+// Every stage is a plain function so it can be exercised on its own. Uploads
+// are received and validated during the request; the remaining stages run on
+// the job queue.
+
+// errInvalidUpload marks failures caused by what the client sent rather than
+// by the server; handlers answer those with a 4xx.
+var errInvalidUpload = errors.New("invalid upload")
+
+// receiveVideo copies an upload to a new file in dir and returns its path.
+// The caller owns the file and must remove it.
+func receiveVideo(dir string, src io.Reader) (string, error) {
+	f, err := os.CreateTemp(dir, "tubely-upload-*.mp4")
+	if err != nil {
+		return "", fmt.Errorf("could not create the temp file locally: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, src); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("could not write file to disk: %w", err)
+	}
+	return f.Name(), nil
+}
+
+func validateVideo(mediaType string, accepted []string) error {
+	if !slices.Contains(accepted, mediaType) {
+		return fmt.Errorf("%w %q, accepted types are %s", errUnsupportedMediaType, mediaType, strings.Join(accepted, ", "))
+	}
+	return nil
+}
+
+// normalizeCodecArgs returns the ffmpeg codec arguments that turn a video with
+// the probed streams into a browser-playable MP4: a remux when the codecs
+// already fit the container, otherwise a transcode to H.264/AAC. Only the
+// first video and audio streams, the ones probed, are kept: subtitle, data
+// and attachment streams (common in MKV) have no place in the MP4.
+func normalizeCodecArgs(info database.MediaInfo) (args []string, transcode bool) {
+	args = []string{"-map", "0:v:0", "-map", "0:a:0?"}
+	videoOK := info.VideoCodec == "h264" || info.VideoCodec == "hevc"
+	audioOK := info.AudioCodec == "" || info.AudioCodec == "aac" || info.AudioCodec == "mp3"
+	if videoOK && audioOK {
+		args = append(args, "-codec", "copy")
+		if info.VideoCodec == "hevc" {
+			args = append(args, "-tag:v", "hvc1") // what Apple players expect
+		}
+		return args, false
+	}
+	return append(args,
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "20", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "160k",
+	), true
+}
+
+// probeResult is what the probe stage learns about a received video.
+type probeResult struct {
+	// prefix is the storage prefix from classifyAspectRatio
+	prefix   string
+	duration time.Duration
+	// width and height are the display dimensions, see displayDimensions
+	width    int
+	height   int
+	hasAudio bool
+	info     database.MediaInfo
+}
+
+func probeVideo(ctx context.Context, path string) (probeResult, error) {
+	info, err := getVideoMediaInfo(ctx, path)
+	if err != nil {
+		return probeResult{}, fmt.Errorf("error probing media info: %w", err)
+	}
+
+	width, height := displayDimensions(info)
+	return probeResult{
+		prefix:   classifyAspectRatio(info),
+		duration: time.Duration(info.DurationSeconds * float64(time.Second)),
+		width:    int(math.Round(width)),
+		height:   int(math.Round(height)),
+		hasAudio: info.AudioCodec != "",
+		info:     info,
+	}, nil
+}
+
+// processVideo writes a faststart MP4 of the video at path, remuxed or
+// transcoded as normalizeCodecArgs decides, and returns its path. The caller
+// owns the file and must remove it.
+func processVideo(ctx context.Context, path string, probe probeResult, onProgress func(percent float64)) (string, error) {
+	codecArgs, _ := normalizeCodecArgs(probe.info)
+	processedPath, err := processVideoForFastStart(ctx, path, probe.duration, codecArgs, onProgress)
+	if err != nil {
+		return "", err
+	}
+	ok, err := hasFastStart(processedPath)
+	if err != nil {
+		os.Remove(processedPath)
+		return "", fmt.Errorf("could not inspect processed file: %w", err)
+	}
+	if !ok {
+		os.Remove(processedPath)
+		return "", errors.New("processed file doesn't have moov before mdat")
+	}
+	return processedPath, nil
+}
+
+func (cfg *apiConfig) storeVideo(ctx context.Context, localPath, key, mediaType string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("could not open video file: %w", err)
+	}
+	defer f.Close()
+
+	if err := cfg.videoStore.Put(ctx, key, f, mediaType); err != nil {
+		return fmt.Errorf("error uploading file to storage: %w", err)
+	}
+	return nil
+}
+
+// storeDir uploads every file in dir to the video store under prefix.
+func (cfg *apiConfig) storeDir(ctx context.Context, dir, prefix string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if err := cfg.storeFile(ctx, filepath.Join(dir, entry.Name()), path.Join(prefix, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (cfg *apiConfig) storeFile(ctx context.Context, localPath, key string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := cfg.videoStore.Put(ctx, key, f, streamingContentType(key)); err != nil {
+		return fmt.Errorf("error uploading %s to storage: %w", key, err)
+	}
+	return nil
+}
+
+func streamingContentType(key string) string {
+	switch path.Ext(key) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	case ".mpd":
+		return "application/dash+xml"
+	case ".m4s":
+		return "video/iso.segment"
+	case ".vtt":
+		return "text/vtt"
+	}
+	if t := mime.TypeByExtension(path.Ext(key)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// storeHLS transcodes the processed file into the configured HLS ladder and
+// uploads it under the video key's artifact prefix. It returns the master
+// playlist's key, or "" when HLS is disabled.
+func (cfg *apiConfig) storeHLS(ctx context.Context, processedPath, key string, probe probeResult, onProgress func(percent float64)) (string, error) {
+	ladder := capLadder(cfg.hlsLadder, probe)
+	if len(ladder) == 0 {
+		return "", nil
+	}
+
+	dir, err := os.MkdirTemp(cfg.stagingRoot, "hls-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create HLS directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	master, err := transcodeHLS(ctx, processedPath, dir, ladder, probe, onProgress)
+	if err != nil {
+		return "", err
+	}
+	prefix := videoArtifactPrefix(key) + "hls"
+	if err := cfg.storeDir(ctx, dir, prefix); err != nil {
+		return "", err
+	}
+	return prefix + "/" + master, nil
+}
+
+// storeDASH packages the processed file as DASH when the deployment enables
+// it and uploads the manifest and segments under the video key's artifact
+// prefix. It returns the manifest's key, or "" when DASH is disabled.
+func (cfg *apiConfig) storeDASH(ctx context.Context, processedPath, key string, probe probeResult, onProgress func(percent float64)) (string, error) {
+	if !cfg.dashPackaging {
+		return "", nil
+	}
+	ladder := capLadder(cfg.dashLadder, probe)
+	if len(ladder) == 0 {
+		return "", nil
+	}
+
+	dir, err := os.MkdirTemp(cfg.stagingRoot, "dash-*")
+	if err != nil {
+		return "", fmt.Errorf("could not create DASH directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := packageDASH(ctx, processedPath, dir, ladder, probe, onProgress)
+	if err != nil {
+		return "", err
+	}
+	prefix := videoArtifactPrefix(key) + "dash"
+	if err := cfg.storeDir(ctx, dir, prefix); err != nil {
+		return "", err
+	}
+	return prefix + "/" + manifest, nil
+}
+
+// storedVideo is everything the store stages put in the blob stores.
+type storedVideo struct {
+	key          string
+	originalKey  string
+	hlsKey       string
+	dashKey      string
+	spriteVTTKey string
+	spriteSheets int
+	mediaInfo    database.MediaInfo
+
+	thumbnails       []database.ThumbnailCandidate
+	defaultThumbnail string
+}
+
+// recordVideo saves the object keys; the delivery URLs are resolved when the
+// video is read. The video is re-read first since processing may have taken
+// a while and the row could have changed meanwhile. The files of a previous
+// upload it replaces are queued for deletion.
+func (cfg *apiConfig) recordVideo(ctx context.Context, video database.Video, stored storedVideo) (database.Video, error) {
+	video, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		return database.Video{}, err
+	}
+	replaced := []database.BlobRef{}
+	if video.VideoKey == nil || *video.VideoKey != stored.key {
+		replaced, err = cfg.storedVideoBlobs(ctx, video)
+		if err != nil {
+			return database.Video{}, err
+		}
+	}
+
+	video.VideoKey = aws.String(stored.key)
+	video.OriginalKey = nil
+	if stored.originalKey != "" {
+		video.OriginalKey = aws.String(stored.originalKey)
+	}
+	video.HLSKey = nil
+	if stored.hlsKey != "" {
+		video.HLSKey = aws.String(stored.hlsKey)
+	}
+	video.DASHKey = nil
+	if stored.dashKey != "" {
+		video.DASHKey = aws.String(stored.dashKey)
+	}
+	video.SpriteVTTKey = nil
+	video.SpriteSheetCount = 0
+	if stored.spriteVTTKey != "" {
+		video.SpriteVTTKey = aws.String(stored.spriteVTTKey)
+		video.SpriteSheetCount = stored.spriteSheets
+	}
+	if err := cfg.recordThumbnailCandidates(&video, stored.thumbnails, stored.defaultThumbnail); err != nil {
+		return database.Video{}, err
+	}
+	stored.mediaInfo.VideoID = video.ID
+	if err := cfg.db.SaveMediaInfo(stored.mediaInfo); err != nil {
+		return database.Video{}, fmt.Errorf("couldn't save media info: %w", err)
+	}
+	if err := cfg.db.UpdateVideo(video); err != nil {
+		return database.Video{}, fmt.Errorf("couldn't update video: %w", err)
+	}
+	// the new upload is recorded; failing now would only leak the old files
+	if err := cfg.queueBlobDeletions(replaced); err != nil {
+		log.Printf("video %s: couldn't queue replaced files for deletion: %v", video.ID, err)
+	}
+	return video, nil
+}
+
+// jobVideoKey is where the job processing an upload stores the video, under
+// the aspect ratio prefix; its artifacts go under videoArtifactPrefix of it.
+// Retries of a job write the same keys, so a failed attempt leaves nothing
+// behind that the next one doesn't overwrite.
+func jobVideoKey(prefix string, jobID uuid.UUID) string {
+	return prefix + "/" + jobID.String() + ".mp4"
+}
+
+// jobThumbnailPrefix is where the job processing an upload stores thumbnail
+// candidates.
+func jobThumbnailPrefix(jobID uuid.UUID) string {
+	return "generated/" + jobID.String() + "/"
+}
+
+// runVideoPipeline takes a received upload at receivedPath through the
+// remaining stages and returns the updated video. Files are stored under
+// keys derived from jobID.
+func (cfg *apiConfig) runVideoPipeline(ctx context.Context, jobID uuid.UUID, video database.Video, receivedPath, mediaType string) (database.Video, error) {
+	mediaType, err := checkVideoContent(ctx, receivedPath, mediaType, cfg.acceptedVideoTypes)
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	cfg.events.publish(video.ID, videoEvent{Type: videoEventProbing})
+	probe, err := probeVideo(ctx, receivedPath)
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	progress := func(stage string) func(percent float64) {
+		return func(percent float64) {
+			cfg.events.publishProgress(video.ID, stage, percent)
+		}
+	}
+	cfg.events.publishProgress(video.ID, progressStageFastStart, 0)
+	processedPath, err := processVideo(ctx, receivedPath, probe, progress(progressStageFastStart))
+	if err != nil {
+		return database.Video{}, err
+	}
+	defer os.Remove(processedPath)
+
+	stored := storedVideo{
+		key:       jobVideoKey(probe.prefix, jobID),
+		mediaInfo: probe.info,
+	}
+	if err := cfg.storeVideo(ctx, processedPath, stored.key, "video/mp4"); err != nil {
+		return database.Video{}, err
+	}
+	if _, transcoded := normalizeCodecArgs(probe.info); transcoded || mediaType != "video/mp4" {
+		stored.originalKey = videoArtifactPrefix(stored.key) + "original" + mediaTypeToExt(mediaType)
+		if err := cfg.storeVideo(ctx, receivedPath, stored.originalKey, mediaType); err != nil {
+			return database.Video{}, err
+		}
+	}
+
+	stored.hlsKey, err = cfg.storeHLS(ctx, processedPath, stored.key, probe, progress(progressStageHLS))
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	stored.dashKey, err = cfg.storeDASH(ctx, processedPath, stored.key, probe, progress(progressStageDASH))
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	stored.spriteVTTKey, stored.spriteSheets, err = cfg.storeSprites(ctx, processedPath, stored.key, probe, progress(progressStageSprites))
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	stored.thumbnails, stored.defaultThumbnail, err = cfg.storeThumbnailCandidates(ctx, processedPath, jobThumbnailPrefix(jobID), probe)
+	if err != nil {
+		return database.Video{}, err
+	}
+
+	video, err = cfg.recordVideo(ctx, video, stored)
+	if err != nil {
+		return database.Video{}, err
+	}
+	cfg.events.publish(video.ID, videoEvent{Type: videoEventStored})
+	return video, nil
+}
+
+// hasFastStart reports whether the MP4 at path has its moov atom ahead of
+// mdat, i.e. whether players can start before the whole file is downloaded.
+func hasFastStart(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var offset int64
+	header := make([]byte, 16)
+	for {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			if errors.Is(err, io.EOF) {
+				return false, nil
+			}
+			return false, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		boxType := string(header[4:8])
+		switch boxType {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+
+		switch size {
+		case 0: // box runs to the end of the file
+			return false, nil
+		case 1: // 64-bit size follows the type
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return false, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if size < 8 {
+			return false, fmt.Errorf("invalid %q box size %d", boxType, size)
+		}
+		offset += size
+	}
+}
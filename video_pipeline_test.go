@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/blobstore"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// box is an MP4 box of the given type with size bytes of payload.
+func box(boxType string, size int) []byte {
+	b := make([]byte, 8+size)
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+	copy(b[4:], boxType)
+	return b
+}
+
+// largeBox is a box using the 64-bit size form.
+func largeBox(boxType string, size int) []byte {
+	b := make([]byte, 16+size)
+	binary.BigEndian.PutUint32(b, 1)
+	copy(b[4:], boxType)
+	binary.BigEndian.PutUint64(b[8:], uint64(len(b)))
+	return b
+}
+
+func writeTemp(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHasFastStart(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"moov first", concat(box("ftyp", 16), box("moov", 64), box("mdat", 256)), true},
+		{"mdat first", concat(box("ftyp", 16), box("mdat", 256), box("moov", 64)), false},
+		{"64-bit box before moov", concat(box("ftyp", 16), largeBox("free", 32), box("moov", 64), box("mdat", 8)), true},
+		{"64-bit mdat first", concat(box("ftyp", 16), largeBox("mdat", 32), box("moov", 64)), false},
+		{"no moov", concat(box("ftyp", 16), box("free", 8)), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := hasFastStart(writeTemp(t, tt.data))
+			if err != nil || got != tt.want {
+				t.Errorf("hasFastStart = %v, %v; want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := hasFastStart(writeTemp(t, []byte{0, 0, 0, 4, 'f', 't', 'y', 'p'})); err == nil {
+		t.Errorf("hasFastStart of a box smaller than its header succeeded")
+	}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// storedFastStart reads key back from the video store and reports whether
+// its bytes have moov before mdat.
+func storedFastStart(t *testing.T, store blobstore.BlobStore, key string) bool {
+	t.Helper()
+	rc, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := hasFastStart(writeTemp(t, data))
+	if err != nil {
+		t.Fatalf("hasFastStart of stored %q: %v", key, err)
+	}
+	return ok
+}
+
+func TestStoreVideoStoresProcessedFile(t *testing.T) {
+	cfg := &apiConfig{videoStore: blobstore.NewMemory("")}
+	processed := writeTemp(t, concat(box("ftyp", 16), box("moov", 64), box("mdat", 256)))
+
+	if err := cfg.storeVideo(context.Background(), processed, "landscape/a.mp4", "video/mp4"); err != nil {
+		t.Fatalf("storeVideo: %v", err)
+	}
+	if !storedFastStart(t, cfg.videoStore, "landscape/a.mp4") {
+		t.Errorf("stored bytes don't have moov before mdat")
+	}
+}
+
+func requireFFmpeg(t *testing.T) {
+	t.Helper()
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s isn't installed", tool)
+		}
+	}
+}
+
+// TestProcessAndStoreFastStart runs the probe, process and store stages on a
+// generated MP4 that has mdat first, as cameras and ffmpeg write by default.
+func TestProcessAndStoreFastStart(t *testing.T) {
+	requireFFmpeg(t)
+	input := filepath.Join(t.TempDir(), "input.mp4")
+	cmd := exec.Command("ffmpeg", "-f", "lavfi", "-i", "testsrc=duration=2:size=320x180:rate=25",
+		"-f", "lavfi", "-i", "sine=duration=2", "-c:v", "libx264", "-c:a", "aac", "-shortest", "-y", input)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generating input: %v\n%s", err, out)
+	}
+	if ok, err := hasFastStart(input); err != nil || ok {
+		t.Fatalf("input hasFastStart = %v, %v; want an mdat-first file", ok, err)
+	}
+
+	probe, err := probeVideo(context.Background(), input)
+	if err != nil {
+		t.Fatalf("probeVideo: %v", err)
+	}
+	processed, err := processVideo(context.Background(), input, probe, nil)
+	if err != nil {
+		t.Fatalf("processVideo: %v", err)
+	}
+	defer os.Remove(processed)
+
+	cfg := &apiConfig{videoStore: blobstore.NewMemory("")}
+	key := probe.prefix + "/a.mp4"
+	if err := cfg.storeVideo(context.Background(), processed, key, "video/mp4"); err != nil {
+		t.Fatalf("storeVideo: %v", err)
+	}
+	if !storedFastStart(t, cfg.videoStore, key) {
+		t.Errorf("stored bytes don't have moov before mdat")
+	}
+}
+
+// TestProcessMKVWithSubtitlesAndAttachments remuxes an MKV carrying streams
+// the MP4 container can't take.
+func TestProcessMKVWithSubtitlesAndAttachments(t *testing.T) {
+	requireFFmpeg(t)
+	dir := t.TempDir()
+	subs := filepath.Join(dir, "subs.ass")
+	font := filepath.Join(dir, "font.ttf")
+	subsText := "[Script Info]\nScriptType: v4.00+\n\n[V4+ Styles]\nFormat: Name, Fontname, Fontsize\nStyle: Default,Arial,20\n\n" +
+		"[Events]\nFormat: Layer, Start, End, Style, Text\nDialogue: 0,0:00:00.00,0:00:01.00,Default,hello\n"
+	if err := os.WriteFile(subs, []byte(subsText), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(font, []byte("not really a font"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "input.mkv")
+	cmd := exec.Command("ffmpeg", "-f", "lavfi", "-i", "testsrc=duration=2:size=320x180:rate=25",
+		"-f", "lavfi", "-i", "sine=duration=2", "-i", subs,
+		"-map", "0", "-map", "1", "-map", "2", "-c:v", "libx264", "-c:a", "aac", "-c:s", "copy",
+		"-attach", font, "-metadata:s:t", "mimetype=application/x-truetype-font", "-shortest", "-y", input)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generating input: %v\n%s", err, out)
+	}
+
+	probe, err := probeVideo(context.Background(), input)
+	if err != nil {
+		t.Fatalf("probeVideo: %v", err)
+	}
+	if _, transcoded := normalizeCodecArgs(probe.info); transcoded {
+		t.Fatalf("H.264/AAC input would be transcoded; want a remux")
+	}
+	processed, err := processVideo(context.Background(), input, probe, nil)
+	if err != nil {
+		t.Fatalf("processVideo: %v", err)
+	}
+	defer os.Remove(processed)
+
+	out, err := exec.Command("ffprobe", "-v", "error", "-show_entries", "stream=codec_type", "-of", "csv=p=0", processed).Output()
+	if err != nil {
+		t.Fatalf("ffprobe: %v", err)
+	}
+	if got := strings.Fields(string(out)); !slices.Equal(got, []string{"video", "audio"}) {
+		t.Errorf("processed streams = %v; want video and audio only", got)
+	}
+}
+
+func TestRecordVideoQueuesReplacedFiles(t *testing.T) {
+	ctx := context.Background()
+	db := database.NewMemoryStore()
+	cfg := &apiConfig{db: db, videoStore: blobstore.NewMemory("")}
+
+	user, err := db.CreateUser(database.CreateUserParams{Email: "a@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := db.CreateVideo(database.CreateVideoParams{Title: "v", UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	video.VideoKey = aws.String("landscape/old.mp4")
+	video.HLSKey = aws.String("landscape/old/hls/master.m3u8")
+	if err := db.UpdateVideo(video); err != nil {
+		t.Fatal(err)
+	}
+	oldFiles := []string{"landscape/old.mp4", "landscape/old/hls/master.m3u8", "landscape/old/original.mov", "landscape/old/sprites/sprites.vtt"}
+	newFiles := []string{"portrait/new.mp4", "portrait/new/hls/master.m3u8"}
+	for _, key := range append(oldFiles, newFiles...) {
+		if err := cfg.videoStore.Put(ctx, key, bytes.NewReader(nil), "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	recorded, err := cfg.recordVideo(ctx, video, storedVideo{key: "portrait/new.mp4", hlsKey: "portrait/new/hls/master.m3u8"})
+	if err != nil {
+		t.Fatalf("recordVideo: %v", err)
+	}
+	if aws.ToString(recorded.VideoKey) != "portrait/new.mp4" || recorded.OriginalKey != nil {
+		t.Errorf("recorded video = %+v", recorded)
+	}
+
+	if got := pendingDeletionKeys(t, db); !equalStrings(got, oldFiles) {
+		t.Errorf("pending deletions = %v; want the old files %v", got, oldFiles)
+	}
+
+	// recording the same upload again (a retried job) replaces nothing
+	if _, err := cfg.recordVideo(ctx, recorded, storedVideo{key: "portrait/new.mp4"}); err != nil {
+		t.Fatalf("recordVideo: %v", err)
+	}
+	if got := pendingDeletionKeys(t, db); !equalStrings(got, oldFiles) {
+		t.Errorf("after recording again, pending deletions = %v; want %v", got, oldFiles)
+	}
+}
+
+func pendingDeletionKeys(t *testing.T, db database.Store) []string {
+	t.Helper()
+	due, err := db.GetDuePendingDeletions(time.Now().Add(time.Second), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []string{}
+	for _, d := range due {
+		keys = append(keys, d.Key)
+	}
+	return keys
+}
+
+func equalStrings(a, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Scrubbing previews are tiled spriteCols x spriteRows to a sheet, each tile
+// spriteTileWidth wide.
+const (
+	spriteTileWidth = 160
+	spriteCols      = 10
+	spriteRows      = 10
+	spriteVTTName   = "sprites.vtt"
+)
+
+func spriteSheetName(n int) string {
+	return fmt.Sprintf("sprite-%03d.jpg", n)
+}
+
+// writeSpriteVTT writes a WebVTT file mapping each interval of the video to
+// its tile, as sprite-NNN.jpg#xywh=x,y,w,h relative to the VTT file. It
+// returns the number of sheets the tiles span.
+func writeSpriteVTT(vttPath string, duration, interval time.Duration, tileWidth, tileHeight int) (int, error) {
+	buf := bytes.Buffer{}
+	buf.WriteString("WEBVTT\n")
+
+	perSheet := spriteCols * spriteRows
+	frames := int((duration + interval - 1) / interval)
+	for i := range frames {
+		start := time.Duration(i) * interval
+		end := min(start+interval, duration)
+		tile := i % perSheet
+		fmt.Fprintf(&buf, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), spriteSheetName(i/perSheet+1),
+			(tile%spriteCols)*tileWidth, (tile/spriteCols)*tileHeight, tileWidth, tileHeight)
+	}
+
+	if err := os.WriteFile(vttPath, buf.Bytes(), 0644); err != nil {
+		return 0, err
+	}
+	return (frames + perSheet - 1) / perSheet, nil
+}
+
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// storeSprites generates scrubbing preview sprite sheets and their WebVTT
+// index, and uploads them under the video key's artifact prefix. It returns
+// the VTT key and sheet count, or "" when sprites are disabled.
+func (cfg *apiConfig) storeSprites(ctx context.Context, processedPath, key string, probe probeResult, onProgress func(percent float64)) (string, int, error) {
+	if cfg.spriteInterval <= 0 || probe.duration <= 0 {
+		return "", 0, nil
+	}
+
+	dir, err := os.MkdirTemp(cfg.stagingRoot, "sprites-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("could not create sprite directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tileHeight := evenDimension(float64(spriteTileWidth) * float64(probe.height) / float64(probe.width))
+	if err := generateSpriteSheets(ctx, processedPath, dir, probe.duration, cfg.spriteInterval, spriteTileWidth, tileHeight, spriteCols, spriteRows, onProgress); err != nil {
+		return "", 0, err
+	}
+	sheets, err := writeSpriteVTT(filepath.Join(dir, spriteVTTName), probe.duration, cfg.spriteInterval, spriteTileWidth, tileHeight)
+	if err != nil {
+		return "", 0, fmt.Errorf("could not write sprite index: %w", err)
+	}
+
+	prefix := videoArtifactPrefix(key) + "sprites"
+	if err := cfg.storeDir(ctx, dir, prefix); err != nil {
+		return "", 0, err
+	}
+	return path.Join(prefix, spriteVTTName), sheets, nil
+}
+
+// rewriteSpriteVTT replaces the relative sheet names in a VTT file written by
+// writeSpriteVTT with the URLs resolve returns for them, keeping the #xywh
+// fragments.
+func rewriteSpriteVTT(vtt []byte, resolve func(sheet string) (string, error)) ([]byte, error) {
+	urls := map[string]string{}
+	lines := strings.Split(string(vtt), "\n")
+	for i, line := range lines {
+		sheet, fragment, ok := strings.Cut(line, "#xywh=")
+		if !ok || strings.Contains(sheet, "/") {
+			continue
+		}
+		u, ok := urls[sheet]
+		if !ok {
+			var err error
+			u, err = resolve(sheet)
+			if err != nil {
+				return nil, err
+			}
+			urls[sheet] = u
+		}
+		lines[i] = u + "#xywh=" + fragment
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// spriteVTTPath is the API route serving a video's sprite index with
+// resolved sheet URLs.
+func spriteVTTPath(videoID uuid.UUID) string {
+	return "/api/videos/" + videoID.String() + "/sprites.vtt"
+}
+
+// handlerSpriteVTTGet serves the owner a video's sprite index with every
+// sheet reference resolved, so the sheets load from a private bucket too.
+func (cfg *apiConfig) handlerSpriteVTTGet(w http.ResponseWriter, r *http.Request) {
+	video, ok := cfg.authorizeVideoOwner(w, r)
+	if !ok {
+		return
+	}
+	if video.SpriteVTTKey == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no sprite sheets", nil)
+		return
+	}
+
+	rc, err := cfg.videoStore.Get(r.Context(), *video.SpriteVTTKey)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read sprite index", err)
+		return
+	}
+	defer rc.Close()
+	vtt, err := io.ReadAll(rc)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read sprite index", err)
+		return
+	}
+
+	dir := path.Dir(*video.SpriteVTTKey)
+	vtt, err = rewriteSpriteVTT(vtt, func(sheet string) (string, error) {
+		return cfg.videoURLResolver.ResolveURL(r.Context(), path.Join(dir, sheet))
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't resolve sprite URLs", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(vtt)
+}
+
+// spriteSheetKeys lists the sheet keys beside the VTT file at vttKey.
+func spriteSheetKeys(vttKey string, sheets int) []string {
+	keys := make([]string, 0, sheets)
+	for n := 1; n <= sheets; n++ {
+		keys = append(keys, path.Join(path.Dir(vttKey), spriteSheetName(n)))
+	}
+	return keys
+}
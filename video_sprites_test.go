@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/google/uuid"
+)
+
+func TestRewriteSpriteVTT(t *testing.T) {
+	vttPath := filepath.Join(t.TempDir(), spriteVTTName)
+	// 150 tiles span two sheets
+	sheets, err := writeSpriteVTT(vttPath, 750*time.Second, 5*time.Second, 160, 90)
+	if err != nil || sheets != 2 {
+		t.Fatalf("writeSpriteVTT = %d, %v", sheets, err)
+	}
+	vtt, err := os.ReadFile(vttPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolved := map[string]int{}
+	rewritten, err := rewriteSpriteVTT(vtt, func(sheet string) (string, error) {
+		resolved[sheet]++
+		return "https://bucket.example.com/landscape/a/sprites/" + sheet + "?X-Amz-Signature=abc", nil
+	})
+	if err != nil {
+		t.Fatalf("rewriteSpriteVTT: %v", err)
+	}
+	if len(resolved) != 2 || resolved["sprite-001.jpg"] != 1 || resolved["sprite-002.jpg"] != 1 {
+		t.Errorf("resolved %v; want each sheet once", resolved)
+	}
+
+	lines := strings.Split(string(rewritten), "\n")
+	if lines[0] != "WEBVTT" {
+		t.Errorf("header = %q", lines[0])
+	}
+	cues := 0
+	for _, line := range lines {
+		if strings.Contains(line, "#xywh=") {
+			cues++
+			if !strings.HasPrefix(line, "https://bucket.example.com/") {
+				t.Errorf("cue %q still references a relative sheet", line)
+			}
+		}
+	}
+	if cues != 150 {
+		t.Errorf("got %d cues; want 150", cues)
+	}
+	want := "https://bucket.example.com/landscape/a/sprites/sprite-002.jpg?X-Amz-Signature=abc#xywh=480,0,160,90"
+	if !strings.Contains(string(rewritten), want) {
+		t.Errorf("rewritten VTT lacks %q", want)
+	}
+}
+
+func TestHandlerSpriteVTTGet(t *testing.T) {
+	const vtt = "WEBVTT\n\n00:00:00.000 --> 00:00:05.000\nsprite-001.jpg#xywh=0,0,160,90\n"
+	tests := []struct {
+		name   string
+		caller string
+		// videoID is the video asked for; empty asks for the owner's
+		videoID    string
+		noSprites  bool
+		wantStatus int
+	}{
+		{"owner", "owner", "", false, http.StatusOK},
+		{"no sprites", "owner", "", true, http.StatusNotFound},
+		{"no JWT", "", "", false, http.StatusUnauthorized},
+		{"bad JWT", "bad", "", false, http.StatusUnauthorized},
+		{"other user", "other", "", false, http.StatusUnauthorized},
+		{"invalid ID", "owner", "not-a-uuid", false, http.StatusBadRequest},
+		{"missing video", "owner", uuid.NewString(), false, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestAPI(t)
+			owner, ownerToken := createTestUser(t, cfg, "owner@example.com")
+			_, otherToken := createTestUser(t, cfg, "other@example.com")
+			video := createTestVideo(t, cfg, owner)
+			if !tt.noSprites {
+				video.SpriteVTTKey = aws.String("landscape/a/sprites/" + spriteVTTName)
+				video.SpriteSheetCount = 1
+				if err := cfg.db.UpdateVideo(video); err != nil {
+					t.Fatal(err)
+				}
+				if err := cfg.videoStore.Put(context.Background(), *video.SpriteVTTKey, strings.NewReader(vtt), "text/vtt"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			token := map[string]string{"owner": ownerToken, "other": otherToken, "bad": "not-a-jwt"}[tt.caller]
+			videoID := tt.videoID
+			if videoID == "" {
+				videoID = video.ID.String()
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/videos/"+videoID+"/sprites.vtt", nil)
+			rec := serve("GET /api/videos/{videoID}/sprites.vtt", cfg.handlerSpriteVTTGet, req, token)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d; want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "text/vtt" {
+				t.Errorf("Content-Type = %q; want text/vtt", ct)
+			}
+			want := "http://localhost/blobs/landscape/a/sprites/sprite-001.jpg#xywh=0,0,160,90"
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("VTT = %q; want the sheet resolved to %q", rec.Body, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// thumbnailPositions are where candidate thumbnails are taken, as
+// percentages of the video's duration.
+var thumbnailPositions = []int{10, 25, 50, 75, 90}
+
+type thumbnailFrame struct {
+	position int
+	path     string
+	size     int64
+}
+
+// extractThumbnailFrames writes a JPEG of the frame at each of
+// thumbnailPositions to dir.
+func extractThumbnailFrames(ctx context.Context, inputPath, dir string, probe probeResult) ([]thumbnailFrame, error) {
+	frames := []thumbnailFrame{}
+	for _, position := range thumbnailPositions {
+		at := probe.duration * time.Duration(position) / 100
+		framePath := filepath.Join(dir, fmt.Sprintf("%d.jpg", position))
+
+		cmd := exec.CommandContext(ctx, "ffmpeg",
+			"-ss", strconv.FormatFloat(at.Seconds(), 'f', 3, 64),
+			"-i", inputPath,
+			"-frames:v", "1",
+			"-q:v", "2",
+			"-y", framePath,
+		)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("error extracting frame at %d%%: %s, %v", position, stderr.String(), err)
+		}
+
+		info, err := os.Stat(framePath)
+		if err != nil {
+			return nil, fmt.Errorf("could not stat frame at %d%%: %w", position, err)
+		}
+		if info.Size() == 0 {
+			continue // past the last frame
+		}
+		frames = append(frames, thumbnailFrame{position: position, path: framePath, size: info.Size()})
+	}
+	return frames, nil
+}
+
+// storeThumbnailCandidates extracts candidate thumbnails from the processed
+// file and uploads them to the thumbnail store under prefix. It also returns
+// the key of the default pick: the largest JPEG, as black or blank frames
+// compress best.
+func (cfg *apiConfig) storeThumbnailCandidates(ctx context.Context, processedPath, prefix string, probe probeResult) ([]database.ThumbnailCandidate, string, error) {
+	dir, err := os.MkdirTemp(cfg.stagingRoot, "thumbnails-*")
+	if err != nil {
+		return nil, "", fmt.Errorf("could not create thumbnail directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	frames, err := extractThumbnailFrames(ctx, processedPath, dir, probe)
+	if err != nil {
+		return nil, "", err
+	}
+
+	candidates := []database.ThumbnailCandidate{}
+	var defaultKey string
+	var defaultSize int64
+	for _, frame := range frames {
+		key := fmt.Sprintf("%s%d.jpg", prefix, frame.position)
+		f, err := os.Open(frame.path)
+		if err != nil {
+			return nil, "", err
+		}
+		err = cfg.thumbnailStore.Put(ctx, key, f, "image/jpeg")
+		f.Close()
+		if err != nil {
+			return nil, "", fmt.Errorf("error uploading thumbnail candidate: %w", err)
+		}
+
+		candidates = append(candidates, database.ThumbnailCandidate{Position: frame.position, Key: key})
+		if frame.size > defaultSize {
+			defaultKey, defaultSize = key, frame.size
+		}
+	}
+	return candidates, defaultKey, nil
+}
+
+// recordThumbnailCandidates replaces the video's candidates and makes
+// defaultKey its thumbnail unless the owner uploaded one. Replaced candidates
+// are queued for deletion.
+func (cfg *apiConfig) recordThumbnailCandidates(video *database.Video, candidates []database.ThumbnailCandidate, defaultKey string) error {
+	if len(candidates) == 0 {
+		return nil
+	}
+	old, err := cfg.db.ReplaceThumbnailCandidates(video.ID, candidates)
+	if err != nil {
+		return fmt.Errorf("couldn't save thumbnail candidates: %w", err)
+	}
+	for _, candidate := range old {
+		// a retried job stores its candidates under the same keys
+		if slices.ContainsFunc(candidates, func(c database.ThumbnailCandidate) bool { return c.Key == candidate.Key }) {
+			continue
+		}
+		if _, err := cfg.db.CreatePendingDeletion(deletionStoreThumbnail, candidate.Key); err != nil {
+			return fmt.Errorf("couldn't record pending deletion: %w", err)
+		}
+	}
+
+	hasThumbnail := video.ThumbnailKey != nil || video.ThumbnailURL != nil
+	if !hasThumbnail || video.ThumbnailGenerated {
+		video.ThumbnailKey = &defaultKey
+		video.ThumbnailWidths = ""
+		video.ThumbnailFormats = ""
+		video.ThumbnailGenerated = true
+	}
+	return nil
+}